@@ -2,7 +2,6 @@ package main
 
 import (
 	"context"
-	"fmt"
 	"log"
 	"net/http"
 	"os"
@@ -15,37 +14,51 @@ import (
 	"gagarin-soft/internal/admin/config"
 	"gagarin-soft/internal/admin/handlers"
 	"gagarin-soft/internal/admin/middleware"
-	"gagarin-soft/internal/admin/storage"
+	"gagarin-soft/internal/admin/worker"
+	"gagarin-soft/internal/oidc"
+	"gagarin-soft/internal/storage"
 )
 
 func main() {
 	_ = godotenv.Load() // Ignore error if .env doesn't exist
 	cfg := config.Load()
 
-	// Build DB Connection String
-	var connString string
-	if cfg.InstanceConnectionName != "" {
-		// When using connector, host/port are ignored in DSN, user/pass/db matter
-		// DSN format for pgx with connector often just needs user/pass/db
-		connString = fmt.Sprintf("user=%s password=%s dbname=%s", cfg.DBUser, cfg.DBPass, cfg.DBName)
-	} else {
-		// Local TCP
-		dbHost := os.Getenv("DB_HOST")
-		if dbHost == "" {
-			dbHost = "localhost"
-		}
-		connString = fmt.Sprintf("postgres://%s:%s@%s:5432/%s",
-			cfg.DBUser, cfg.DBPass, dbHost, cfg.DBName)
-	}
-
 	ctx := context.Background()
-	store, err := storage.New(ctx, connString, cfg.InstanceConnectionName)
+	dbOpts := cfg.ConnOptions()
+	log.Printf("Connecting to Postgres (%s)...", dbOpts.Describe())
+	store, closeStore, err := storage.NewPostgresRepository(ctx, dbOpts)
 	if err != nil {
 		log.Fatalf("Failed to connect to storage: %v", err)
 	}
-	defer store.Close()
+	defer closeStore()
+	if cfg.MigrateOnStart {
+		if err := store.Migrate(ctx); err != nil {
+			log.Fatalf("Failed to migrate database: %v", err)
+		}
+	}
 
-	h := handlers.NewHandler(cfg, store)
+	var workerClient *worker.Client
+	if cfg.WorkerBaseURL != "" {
+		var signer oidc.Signer
+		switch {
+		case cfg.WorkerSigningKey != "":
+			log.Printf("Signing worker requests with local key %s", cfg.WorkerSigningKey)
+			signer, err = oidc.NewLocalSignerFromFile(cfg.WorkerSigningKey, cfg.WorkerSigningKeyID, "")
+			if err != nil {
+				log.Fatalf("Failed to load worker signing key: %v", err)
+			}
+		case cfg.AppEnv == "local":
+			log.Println("Calling the worker without ID tokens (local env)")
+		default:
+			signer = &oidc.GoogleSigner{}
+		}
+		workerClient = worker.NewClient(cfg.WorkerBaseURL, signer)
+		workerClient.Audience = cfg.WorkerAudience
+	} else {
+		log.Println("WORKER_BASE_URL not set, admin actions are disabled")
+	}
+
+	h := handlers.NewHandler(cfg, store, workerClient)
 	iap := middleware.NewIAPMiddleware(cfg.AdminAllowlist, cfg.AppEnv)
 
 	r := chi.NewRouter()
@@ -69,9 +82,18 @@ func main() {
 			r.Patch("/filters/{id}", h.UpdateFilter)
 			r.Delete("/filters/{id}", h.DeleteFilter)
 
+			r.Get("/mailboxes", h.GetMailboxes)
+			r.Post("/mailboxes", h.CreateMailbox)
+			r.Patch("/mailboxes/{id}", h.UpdateMailbox)
+
 			r.Get("/events", h.GetEvents)
+			r.Get("/processed-emails", h.GetProcessedEmails)
+			r.Get("/processed-emails/{messageId}", h.GetProcessedEmail)
+			r.Get("/messages/{messageId}", h.GetMessageTimeline)
 
 			r.Post("/actions/{action}", h.TriggerAction) // renew-watch, resync, reprocess
+			r.Get("/jobs", h.GetJobs)
+			r.Get("/jobs/{id}", h.GetJob)
 		})
 	})
 
@@ -4,15 +4,22 @@ import (
 	"context"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/joho/godotenv"
 
 	"gagarin-soft/internal/auth"
+	"gagarin-soft/internal/blob"
 	"gagarin-soft/internal/config"
 	"gagarin-soft/internal/handlers"
+	"gagarin-soft/internal/oidc"
+	"gagarin-soft/internal/scheduler"
 	"gagarin-soft/internal/services"
 	"gagarin-soft/internal/storage"
+	"gagarin-soft/internal/worker"
 )
 
 func main() {
@@ -39,35 +46,105 @@ func main() {
 
 	// 3. Initialize Storage
 	var repo storage.HistoryRepository
-	if cfg.InstanceConnectionName != "" {
-		log.Printf("Initializing Cloud SQL storage...")
-		postgresRepo, cleanup, err := storage.NewPostgresRepository(
-			ctx,
-			cfg.InstanceConnectionName,
-			cfg.DBUser,
-			cfg.DBPass,
-			cfg.DBName,
-		)
+	if cfg.DatabaseConfigured() {
+		dbOpts := cfg.ConnOptions()
+		log.Printf("Initializing Postgres storage (%s)...", dbOpts.Describe())
+		postgresRepo, cleanup, err := storage.NewPostgresRepository(ctx, dbOpts)
 		if err != nil {
-			log.Fatalf("Failed to initialize Cloud SQL: %v", err)
+			log.Fatalf("Failed to initialize Postgres storage: %v", err)
 		}
 		defer func() {
 			if err := cleanup(); err != nil {
-				log.Printf("Failed to close Cloud SQL dialer: %v", err)
+				log.Printf("Failed to close database connection: %v", err)
 			}
 		}()
+		if cfg.MigrateOnStart {
+			if err := postgresRepo.Migrate(ctx); err != nil {
+				log.Fatalf("Failed to migrate database: %v", err)
+			}
+		}
 		repo = postgresRepo
 	} else {
 		log.Println("Using No-Op storage (no DB configured)")
 		repo = &storage.NoOpRepository{}
 	}
 
-	// 4. Initialize Services and Handlers
+	// 4. Initialize Attachment Storage
+	var blobs blob.Store
+	switch cfg.BlobBackend {
+	case "local":
+		log.Printf("Storing attachments in %s", cfg.BlobLocalDir)
+		blobs, err = blob.NewLocalStore(cfg.BlobLocalDir)
+	case "s3":
+		log.Printf("Storing attachments in bucket %s at %s", cfg.S3Bucket, cfg.S3Endpoint)
+		blobs, err = blob.NewS3Store(blob.S3Config{
+			Endpoint:        cfg.S3Endpoint,
+			Region:          cfg.S3Region,
+			Bucket:          cfg.S3Bucket,
+			AccessKeyID:     cfg.S3AccessKeyID,
+			SecretAccessKey: cfg.S3SecretAccessKey,
+			PathStyle:       cfg.S3PathStyle,
+		})
+	case "":
+		log.Println("No blob backend configured, attachments will not be downloaded")
+	default:
+		log.Fatalf("Unknown BLOB_BACKEND %q", cfg.BlobBackend)
+	}
+	if err != nil {
+		log.Fatalf("Failed to initialize blob storage: %v", err)
+	}
+
+	// 5. Initialize Services and Handlers
 	gmailService := services.NewGmailWatchService(cfg, authManager, repo)
-	renewHandler := &handlers.RenewWatchHandler{Service: gmailService}
-	pushHandler := &handlers.PushHandler{Service: gmailService}
+	gmailService.Blobs = blobs
+	// Pushes are acknowledged once queued; WORKER_CONCURRENCY=0 processes them inline instead.
+	var pool *worker.Pool
+	if cfg.WorkerConcurrency > 0 {
+		pool = worker.NewPool(cfg.WorkerConcurrency, cfg.WorkerQueueSize, cfg.WorkerJobTimeout)
+	}
+	pushHandler := &handlers.PushHandler{Service: gmailService, SharedToken: cfg.PushAuthToken, Queue: pool}
+	if cfg.PushAuthAudience != "" {
+		log.Printf("Verifying push OIDC tokens for audience %s", cfg.PushAuthAudience)
+		pushHandler.Verifier, err = oidc.NewVerifier(oidc.Config{
+			JWKS:     cfg.PushAuthJWKS,
+			Audience: cfg.PushAuthAudience,
+			Issuers:  cfg.PushAuthIssuers,
+			Email:    cfg.PushAuthEmail,
+		})
+		if err != nil {
+			log.Fatalf("Failed to initialize push authentication: %v", err)
+		}
+	} else if cfg.PushAuthToken == "" {
+		log.Println("Push authentication disabled, /gmail/push accepts any caller")
+	}
+	renewHandler := &handlers.RenewWatchHandler{Service: gmailService, Queue: pool}
+	resyncHandler := &handlers.ResyncHandler{Service: gmailService, Queue: pool}
+	reprocessHandler := &handlers.ReprocessHandler{Service: gmailService, Queue: pool}
+
+	// The routes the admin service (or a scheduler) calls take its ID tokens; only local runs
+	// skip them.
+	adminOnly := func(h http.Handler) http.Handler { return h }
+	switch {
+	case cfg.AdminAuthAudience != "":
+		log.Printf("Verifying admin OIDC tokens for audience %s", cfg.AdminAuthAudience)
+		adminVerifier, err := oidc.NewVerifier(oidc.Config{
+			JWKS:     cfg.AdminAuthJWKS,
+			Audience: cfg.AdminAuthAudience,
+			Email:    cfg.AdminAuthEmail,
+		})
+		if err != nil {
+			log.Fatalf("Failed to initialize admin authentication: %v", err)
+		}
+		adminOnly = func(h http.Handler) http.Handler { return handlers.RequireOIDC(adminVerifier, h) }
+	case cfg.AppEnv == "local":
+		log.Println("Admin authentication disabled (local env)")
+	default:
+		log.Fatal("ADMIN_AUTH_AUDIENCE is required outside APP_ENV=local: /renew-watch, /resync, " +
+			"/reprocess and /worker/stats only accept ID tokens for that audience " +
+			"(set ADMIN_AUTH_EMAIL to the caller's service account)")
+	}
 
-	// 5. Define Handlers
+	// 6. Define Handlers
 	mux := http.NewServeMux()
 
 	mux.HandleFunc("POST /health", func(w http.ResponseWriter, r *http.Request) {
@@ -75,10 +152,15 @@ func main() {
 		w.Write([]byte("OK"))
 	})
 
-	mux.Handle("POST /renew-watch", renewHandler)
+	mux.Handle("POST /renew-watch", adminOnly(renewHandler))
 	mux.Handle("POST /gmail/push", pushHandler)
+	mux.Handle("POST /resync", adminOnly(resyncHandler))
+	mux.Handle("POST /reprocess", adminOnly(reprocessHandler))
+	if pool != nil {
+		mux.Handle("GET /worker/stats", adminOnly(&handlers.WorkerStatsHandler{Pool: pool}))
+	}
 
-	// 6. Start Server
+	// 7. Start Server
 	log.Printf("Starting server on :%s", cfg.Port)
 	server := &http.Server{
 		Addr:         ":" + cfg.Port,
@@ -87,7 +169,41 @@ func main() {
 		WriteTimeout: 30 * time.Second,
 	}
 
-	if err := server.ListenAndServe(); err != nil {
+	// 8. Start Watch Renewal Scheduler
+	stop, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	if _, noDB := repo.(*storage.NoOpRepository); cfg.WatchRenewScheduler && !noDB {
+		renewScheduler := scheduler.NewRenewScheduler(gmailService, repo)
+		renewScheduler.Interval = cfg.WatchRenewInterval
+		renewScheduler.RenewBefore = cfg.WatchRenewBefore
+		go renewScheduler.Run(stop)
+	} else {
+		log.Println("Watch renewal scheduler disabled, renew with POST /renew-watch and an ID token for ADMIN_AUTH_AUDIENCE")
+	}
+
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- server.ListenAndServe()
+	}()
+
+	// 9. Graceful Shutdown: stop taking requests, then drain queued pushes
+	select {
+	case err := <-serverErr:
 		log.Fatalf("Server failed to start: %v", err)
+	case <-stop.Done():
+	}
+
+	log.Println("Shutting down...")
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancelShutdown()
+
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("HTTP server shutdown: %v", err)
+	}
+	if pool != nil {
+		if err := pool.Shutdown(shutdownCtx); err != nil {
+			log.Printf("Worker pool shutdown: %v", err)
+		}
 	}
 }
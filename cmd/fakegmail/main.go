@@ -0,0 +1,56 @@
+// Command fakegmail serves the gmailtest fake Gmail API for local development. Point the
+// worker at it with GMAIL_ENDPOINT=http://localhost:8085/ and APP_ENV=local, then script
+// the mailbox through the /fake/ endpoints (see gmailtest.Mailbox.Control):
+//
+//	curl -X POST localhost:8085/fake/messages -d '{"from": "shop@example.com", "subject": "Receipt"}'
+//
+// and announce the change with cmd/pushctl.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+
+	"gagarin-soft/internal/gmailtest"
+)
+
+func main() {
+	addr := flag.String("addr", ":8085", "listen address")
+	email := flag.String("email", "me@example.com", "address of the fake mailbox")
+	seed := flag.String("seed", "", "JSON file with an array of messages to start with")
+	pageSize := flag.Int("page-size", 0, "cap on list page sizes, to exercise pagination")
+	flag.Parse()
+
+	mb := gmailtest.NewMailbox(*email)
+	mb.PageSize = *pageSize
+
+	if *seed != "" {
+		data, err := os.ReadFile(*seed)
+		if err != nil {
+			log.Fatalf("Failed to read seed: %v", err)
+		}
+		var messages []gmailtest.Message
+		if err := json.Unmarshal(data, &messages); err != nil {
+			log.Fatalf("Failed to parse seed: %v", err)
+		}
+		for _, m := range messages {
+			mb.AddMessage(m)
+		}
+		log.Printf("Seeded %d messages", len(messages))
+	}
+
+	log.Printf("Fake Gmail for %s on %s, history ID %d", *email, *addr, mb.HistoryID())
+	if err := http.ListenAndServe(*addr, logRequests(mb)); err != nil {
+		log.Fatalf("Server failed: %v", err)
+	}
+}
+
+func logRequests(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		log.Printf("%s %s", r.Method, r.URL.RequestURI())
+		h.ServeHTTP(w, r)
+	})
+}
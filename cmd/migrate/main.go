@@ -0,0 +1,105 @@
+// Command migrate applies the embedded SQL migrations to the shared database.
+//
+//	migrate up            apply all pending migrations
+//	migrate down [N]      revert the last N migrations (default 1)
+//	migrate status        list migrations and whether they are applied
+//	migrate force VERSION record VERSION as current without running SQL
+//
+// The database is configured as for the API worker: DATABASE_URL, or DB_HOST, DB_PORT,
+// DB_USER, DB_PASS and DB_NAME (or INSTANCE_CONNECTION_NAME for the Cloud SQL connector).
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/joho/godotenv"
+
+	"gagarin-soft/internal/config"
+	"gagarin-soft/internal/storage"
+)
+
+func main() {
+	_ = godotenv.Load()
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "usage: migrate up | down [N] | status | force VERSION")
+		flag.PrintDefaults()
+	}
+	cfg := config.Load()
+	dsn := flag.String("database", cfg.DB.DSN, "Postgres connection string")
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	ctx := context.Background()
+	dbOpts := cfg.ConnOptions()
+	dbOpts.DSN = *dsn
+	repo, cleanup, err := storage.NewPostgresRepository(ctx, dbOpts)
+	if err != nil {
+		log.Fatalf("Failed to connect: %v", err)
+	}
+	defer cleanup()
+
+	m, err := repo.Migrator()
+	if err != nil {
+		log.Fatalf("Failed to load migrations: %v", err)
+	}
+
+	switch cmd := flag.Arg(0); cmd {
+	case "up":
+		applied, err := m.Up(ctx)
+		if err != nil {
+			log.Fatalf("Migration failed: %v", err)
+		}
+		if len(applied) == 0 {
+			log.Println("Schema is up to date")
+		}
+	case "down":
+		steps := 1
+		if flag.NArg() > 1 {
+			if steps, err = strconv.Atoi(flag.Arg(1)); err != nil || steps < 1 {
+				log.Fatalf("Invalid number of steps %q", flag.Arg(1))
+			}
+		}
+		if _, err := m.Down(ctx, steps); err != nil {
+			log.Fatalf("Migration failed: %v", err)
+		}
+	case "status":
+		statuses, err := m.Status(ctx)
+		if err != nil {
+			log.Fatalf("Failed to read status: %v", err)
+		}
+		for _, st := range statuses {
+			state := "pending"
+			switch {
+			case st.Missing:
+				state = "applied " + st.AppliedAt.Format("2006-01-02 15:04:05") + " (no file)"
+			case st.Applied:
+				state = "applied " + st.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%03d  %-30s  %s\n", st.Version, st.Name, state)
+		}
+	case "force":
+		if flag.NArg() < 2 {
+			log.Fatal("force requires a version")
+		}
+		version, err := strconv.ParseInt(flag.Arg(1), 10, 64)
+		if err != nil || version < 0 {
+			log.Fatalf("Invalid version %q", flag.Arg(1))
+		}
+		if err := m.Force(ctx, version); err != nil {
+			log.Fatalf("Force failed: %v", err)
+		}
+		log.Printf("Schema version set to %d", version)
+	default:
+		log.Printf("Unknown command %q", cmd)
+		flag.Usage()
+		os.Exit(2)
+	}
+}
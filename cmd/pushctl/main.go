@@ -0,0 +1,334 @@
+// Command pushctl delivers Gmail push notifications to the worker the way Pub/Sub does, for
+// debugging ingestion locally (against cmd/fakegmail, for instance).
+//
+//	pushctl send -email me@example.com -history-id 1234   one synthetic push
+//	pushctl replay pushes.jsonl                           re-send captured pushes
+//	pushctl replay -follow -speed 10 pushes.jsonl         ...at their original timing
+//
+// Each line of a replay file is either a captured push body ({"message": {...}}) or a bare
+// notification ({"emailAddress": "...", "historyId": 1234, "publishTime": "..."}); "-"
+// reads stdin. Replayed pushes get fresh message IDs unless -keep-ids is set, since the
+// worker ignores message IDs it has already accepted.
+//
+// With -sign-key the requests carry an ID token signed by the local RSA key, for a worker
+// started with PUSH_AUTH_AUDIENCE and a PUSH_AUTH_JWKS holding the public key. -print
+// writes the request bodies to stdout instead of sending them.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"time"
+
+	"gagarin-soft/internal/handlers"
+	"gagarin-soft/internal/oidc"
+)
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: pushctl send -email ADDRESS -history-id ID [flags]")
+	fmt.Fprintln(os.Stderr, "       pushctl replay [-follow] [-speed N] [-keep-ids] [flags] FILE")
+	fmt.Fprintln(os.Stderr, "run pushctl COMMAND -h for the flags")
+	os.Exit(2)
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	var err error
+	switch cmd, args := os.Args[1], os.Args[2:]; cmd {
+	case "send":
+		err = runSend(ctx, args)
+	case "replay":
+		err = runReplay(ctx, args)
+	default:
+		usage()
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func runSend(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("send", flag.ExitOnError)
+	var opts options
+	opts.register(fs)
+	email := fs.String("email", "", "mailbox address of the notification")
+	historyID := fs.Uint64("history-id", 0, "history ID of the notification")
+	messageID := fs.String("message-id", "", "Pub/Sub message ID, random when empty")
+	attrs := make(map[string]string)
+	fs.Func("attr", "message attribute as KEY=VALUE, repeatable", func(s string) error {
+		k, v, ok := strings.Cut(s, "=")
+		if !ok {
+			return errors.New("expected KEY=VALUE")
+		}
+		attrs[k] = v
+		return nil
+	})
+	fs.Parse(args)
+	if *email == "" || *historyID == 0 {
+		return errors.New("send needs -email and -history-id")
+	}
+
+	p, err := opts.pusher()
+	if err != nil {
+		return err
+	}
+	msg, err := wrap(handlers.GmailPushData{EmailAddress: *email, HistoryID: *historyID}, time.Now(), attrs)
+	if err != nil {
+		return err
+	}
+	msg.Message.MessageID = *messageID
+	return p.push(ctx, msg)
+}
+
+func runReplay(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	var opts options
+	opts.register(fs)
+	follow := fs.Bool("follow", false, "wait between pushes as long as between their publish times")
+	speed := fs.Float64("speed", 1, "with -follow, replay this many times faster")
+	keepIDs := fs.Bool("keep-ids", false, "send the captured message IDs instead of fresh ones")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return errors.New("replay needs one FILE (or - for stdin)")
+	}
+	if *speed <= 0 {
+		return errors.New("-speed must be positive")
+	}
+
+	p, err := opts.pusher()
+	if err != nil {
+		return err
+	}
+	in := os.Stdin
+	if name := fs.Arg(0); name != "-" {
+		if in, err = os.Open(name); err != nil {
+			return err
+		}
+		defer in.Close()
+	}
+
+	// Pushes are scheduled relative to the first one's publish time.
+	start := time.Now()
+	var first time.Time
+	var sent, failed int
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1<<20)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		msg, published, err := parseCaptured(scanner.Bytes())
+		if err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+		if *follow && !published.IsZero() {
+			if first.IsZero() {
+				first = published
+			}
+			due := start.Add(time.Duration(float64(published.Sub(first)) / *speed))
+			if wait := time.Until(due); wait > 0 {
+				select {
+				case <-time.After(wait):
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+		}
+		if !*keepIDs {
+			msg.Message.MessageID = ""
+		}
+		if err := p.push(ctx, msg); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			log.Printf("line %d: %v", line, err)
+			failed++
+			continue
+		}
+		sent++
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	log.Printf("Replayed %d pushes, %d failed", sent, failed)
+	if failed > 0 {
+		return fmt.Errorf("%d pushes failed", failed)
+	}
+	return nil
+}
+
+// captured is one line of a replay file: a push body, or a bare notification with its
+// publish time.
+type captured struct {
+	handlers.PubSubMessage
+	handlers.GmailPushData
+	PublishTime time.Time `json:"publishTime"`
+}
+
+func parseCaptured(line []byte) (handlers.PubSubMessage, time.Time, error) {
+	var c captured
+	if err := json.Unmarshal(line, &c); err != nil {
+		return handlers.PubSubMessage{}, time.Time{}, err
+	}
+	switch {
+	case c.Message.Data != "":
+		var published time.Time
+		if c.Message.PublishTime != "" {
+			t, err := time.Parse(time.RFC3339Nano, c.Message.PublishTime)
+			if err != nil {
+				return handlers.PubSubMessage{}, time.Time{}, fmt.Errorf("invalid publishTime: %w", err)
+			}
+			published = t
+		}
+		return c.PubSubMessage, published, nil
+	case c.EmailAddress != "":
+		published := c.PublishTime
+		if published.IsZero() {
+			published = time.Now()
+		}
+		msg, err := wrap(c.GmailPushData, published, nil)
+		return msg, c.PublishTime, err
+	default:
+		return handlers.PubSubMessage{}, time.Time{}, errors.New("neither a push body nor a notification")
+	}
+}
+
+// wrap encodes a notification as Pub/Sub wraps it.
+func wrap(data handlers.GmailPushData, published time.Time, attrs map[string]string) (handlers.PubSubMessage, error) {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return handlers.PubSubMessage{}, err
+	}
+	var msg handlers.PubSubMessage
+	msg.Message = handlers.PubSubPayload{
+		Data:        base64.StdEncoding.EncodeToString(raw),
+		PublishTime: published.UTC().Format(time.RFC3339Nano),
+	}
+	if len(attrs) > 0 {
+		msg.Message.Attributes = attrs
+	}
+	return msg, nil
+}
+
+type options struct {
+	url          string
+	token        string
+	subscription string
+	signKey      string
+	keyID        string
+	signEmail    string
+	audience     string
+	print        bool
+}
+
+func (o *options) register(fs *flag.FlagSet) {
+	fs.StringVar(&o.url, "url", "http://localhost:8080/gmail/push", "push endpoint of the worker")
+	fs.StringVar(&o.token, "token", "", "shared token sent as ?token= (PUSH_AUTH_TOKEN)")
+	fs.StringVar(&o.subscription, "subscription", "projects/local/subscriptions/gmail-push", "subscription named in the push body")
+	fs.StringVar(&o.signKey, "sign-key", "", "PEM RSA key to sign an ID token with")
+	fs.StringVar(&o.keyID, "key-id", "", "kid of the signing key")
+	fs.StringVar(&o.signEmail, "sign-email", "", "email claim of the ID token (PUSH_AUTH_EMAIL)")
+	fs.StringVar(&o.audience, "audience", "", "audience of the ID token, defaults to -url (PUSH_AUTH_AUDIENCE)")
+	fs.BoolVar(&o.print, "print", false, "print the request bodies instead of sending them")
+}
+
+func (o *options) pusher() (*pusher, error) {
+	target, err := url.Parse(o.url)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -url: %w", err)
+	}
+	if o.token != "" {
+		q := target.Query()
+		q.Set("token", o.token)
+		target.RawQuery = q.Encode()
+	}
+	p := &pusher{
+		url:          target.String(),
+		subscription: o.subscription,
+		audience:     o.audience,
+		print:        o.print,
+		client:       &http.Client{Timeout: 30 * time.Second},
+	}
+	if p.audience == "" {
+		p.audience = o.url
+	}
+	if o.signKey != "" {
+		if p.signer, err = oidc.NewLocalSignerFromFile(o.signKey, o.keyID, o.signEmail); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// pusher POSTs push bodies to the worker.
+type pusher struct {
+	url          string
+	subscription string
+	audience     string
+	signer       oidc.Signer
+	print        bool
+	client       *http.Client
+}
+
+func (p *pusher) push(ctx context.Context, msg handlers.PubSubMessage) error {
+	if msg.Message.MessageID == "" {
+		// Pub/Sub message IDs are decimal numbers.
+		msg.Message.MessageID = strconv.FormatUint(rand.Uint64()>>1, 10)
+	}
+	if msg.Subscription == "" {
+		msg.Subscription = p.subscription
+	}
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	if p.print {
+		fmt.Println(string(body))
+		return nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if p.signer != nil {
+		token, err := p.signer.Token(ctx, p.audience)
+		if err != nil {
+			return fmt.Errorf("failed to sign push: %w", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// Pub/Sub treats any 2xx as an acknowledgement and redelivers otherwise.
+	if resp.StatusCode/100 != 2 {
+		text, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("push %s not acknowledged: %s %s", msg.Message.MessageID, resp.Status, strings.TrimSpace(string(text)))
+	}
+	log.Printf("Pushed %s (%s)", msg.Message.MessageID, resp.Status)
+	return nil
+}
@@ -16,15 +16,10 @@ func main() {
 		log.Println("Warning: No .env file found (or error loading it)")
 	}
 	cfg := config.Load()
-	log.Printf("Testing connection to: %s User: %s", cfg.InstanceConnectionName, cfg.DBUser)
+	dbOpts := cfg.ConnOptions()
+	log.Printf("Testing connection to: %s User: %s", dbOpts.Describe(), dbOpts.User)
 
-	_, cleanup, err := storage.NewPostgresRepository(
-		context.Background(),
-		cfg.InstanceConnectionName,
-		cfg.DBUser,
-		cfg.DBPass,
-		cfg.DBName,
-	)
+	_, cleanup, err := storage.NewPostgresRepository(context.Background(), dbOpts)
 	if err != nil {
 		log.Fatalf("❌ Connection FAILED: %v\nHint: Ensure you have run 'gcloud auth application-default login' if running locally.", err)
 	}
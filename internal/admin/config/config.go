@@ -3,18 +3,21 @@ package config
 import (
 	"os"
 	"strings"
+
+	"gagarin-soft/internal/storage"
 )
 
 type Config struct {
-	ProjectID              string
-	Port                   string
-	DBUser                 string
-	DBPass                 string
-	DBName                 string
-	InstanceConnectionName string
-	AdminAllowlist         []string
-	WorkerBaseURL          string
-	AppEnv                 string
+	ProjectID          string
+	Port               string
+	DB                 storage.ConnOptions
+	MigrateOnStart     bool
+	AdminAllowlist     []string
+	WorkerBaseURL      string
+	WorkerAudience     string
+	WorkerSigningKey   string
+	WorkerSigningKeyID string
+	AppEnv             string
 }
 
 func Load() *Config {
@@ -37,18 +40,25 @@ func Load() *Config {
 	}
 
 	return &Config{
-		ProjectID:              getEnv("GOOGLE_CLOUD_PROJECT", "GCP_PROJECT"),
-		Port:                   port,
-		DBUser:                 os.Getenv("DB_USER"),
-		DBPass:                 getEnv("DB_PASSWORD", "DB_PASS"), // Support both
-		DBName:                 os.Getenv("DB_NAME"),
-		InstanceConnectionName: getEnv("DB_INSTANCE_CONNECTION_NAME", "INSTANCE_CONNECTION_NAME"), // Support both
-		AdminAllowlist:         allowlist,
-		WorkerBaseURL:          os.Getenv("WORKER_BASE_URL"),
-		AppEnv:                 appEnv,
+		ProjectID:          getEnv("GOOGLE_CLOUD_PROJECT", "GCP_PROJECT"),
+		Port:               port,
+		DB:                 storage.ConnOptionsFromEnv(),
+		MigrateOnStart:     os.Getenv("MIGRATE_ON_START") == "true",
+		AdminAllowlist:     allowlist,
+		WorkerBaseURL:      os.Getenv("WORKER_BASE_URL"),
+		WorkerAudience:     os.Getenv("WORKER_AUDIENCE"),         // defaults to WORKER_BASE_URL
+		WorkerSigningKey:   os.Getenv("WORKER_SIGNING_KEY_FILE"), // local PEM key instead of Google ID tokens
+		WorkerSigningKeyID: os.Getenv("WORKER_SIGNING_KEY_ID"),
+		AppEnv:             appEnv,
 	}
 }
 
+// ConnOptions returns the database settings for storage.NewPostgresRepository, read by
+// storage.ConnOptionsFromEnv like the worker's.
+func (c *Config) ConnOptions() storage.ConnOptions {
+	return c.DB
+}
+
 func getEnv(keys ...string) string {
 	for _, k := range keys {
 		if v := os.Getenv(k); v != "" {
@@ -1,27 +1,38 @@
 package handlers
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
 
 	"gagarin-soft/internal/admin/config"
-	"gagarin-soft/internal/admin/storage"
+	"gagarin-soft/internal/admin/worker"
+	"gagarin-soft/internal/gmailquery"
+	"gagarin-soft/internal/storage"
 )
 
 type Handler struct {
 	cfg     *config.Config
-	storage *storage.Storage
+	storage storage.AdminRepository
+	worker  *worker.Client
 }
 
-func NewHandler(cfg *config.Config, store *storage.Storage) *Handler {
+func NewHandler(cfg *config.Config, store storage.AdminRepository, workerClient *worker.Client) *Handler {
 	return &Handler{
 		cfg:     cfg,
 		storage: store,
+		worker:  workerClient,
 	}
 }
 
@@ -40,7 +51,7 @@ func (h *Handler) GetStats(w http.ResponseWriter, r *http.Request) {
 		to = time.Now().Format("2006-01-02")
 	}
 
-	stats, err := h.storage.GetDailyStats(r.Context(), from, to)
+	stats, err := h.storage.GetDailyStats(r.Context(), from, to, r.URL.Query().Get("mailbox_id"))
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -64,6 +75,11 @@ func (h *Handler) CreateFilter(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if _, err := gmailquery.Parse(f.GmailQuery); err != nil {
+		http.Error(w, "Invalid gmail_query: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	f.UpdatedBy = getAdminEmail(r)
 
 	if err := h.storage.CreateFilter(r.Context(), &f); err != nil {
@@ -81,6 +97,11 @@ func (h *Handler) UpdateFilter(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if _, err := gmailquery.Parse(f.GmailQuery); err != nil {
+		http.Error(w, "Invalid gmail_query: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	f.UpdatedBy = getAdminEmail(r)
 
 	if err := h.storage.UpdateFilter(r.Context(), id, &f); err != nil {
@@ -99,34 +120,343 @@ func (h *Handler) DeleteFilter(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
+func (h *Handler) GetMailboxes(w http.ResponseWriter, r *http.Request) {
+	mailboxes, err := h.storage.ListMailboxes(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(mailboxes)
+}
+
+func (h *Handler) CreateMailbox(w http.ResponseWriter, r *http.Request) {
+	var m storage.Mailbox
+	if err := json.NewDecoder(r.Body).Decode(&m); err != nil {
+		http.Error(w, "Invalid body", http.StatusBadRequest)
+		return
+	}
+	if err := validateMailbox(&m); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	m.UpdatedBy = getAdminEmail(r)
+
+	if err := h.storage.CreateMailbox(r.Context(), &m); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (h *Handler) UpdateMailbox(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	var m storage.Mailbox
+	if err := json.NewDecoder(r.Body).Decode(&m); err != nil {
+		http.Error(w, "Invalid body", http.StatusBadRequest)
+		return
+	}
+	if err := validateMailbox(&m); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	m.UpdatedBy = getAdminEmail(r)
+
+	if err := h.storage.UpdateMailbox(r.Context(), id, &m); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// validateMailbox checks the required fields and defaults the status. Empty watch labels
+// and filter behavior are kept, so the worker's WATCH_LABELS and WATCH_LABEL_FILTER_BEHAVIOR
+// apply to the mailbox.
+func validateMailbox(m *storage.Mailbox) error {
+	if m.EmailAddress == "" || m.RefreshTokenSecret == "" {
+		return errors.New("email_address and refresh_token_secret are required")
+	}
+	if m.WatchLabels == nil {
+		m.WatchLabels = storage.StringArray{} // the column is NOT NULL
+	}
+	switch m.WatchLabelFilter {
+	case "", "include", "exclude":
+	default:
+		return fmt.Errorf("invalid watch_label_filter_behavior %q", m.WatchLabelFilter)
+	}
+	switch m.Status {
+	case "":
+		m.Status = "active"
+	case "active", "disabled":
+	default:
+		return fmt.Errorf("invalid status %q", m.Status)
+	}
+	return nil
+}
+
+// GetEvents lists events newest first, a page at a time. Filters: status (comma separated),
+// message_id, filter_id, mailbox_id, mailbox (address), since and until (RFC 3339) and error
+// (a case-insensitive substring). Without status only outcome events are listed, unless
+// steps=true. limit is 1 to 500, cursor is the next_cursor of the previous page, and
+// total=true adds the number of matching events.
 func (h *Handler) GetEvents(w http.ResponseWriter, r *http.Request) {
-	limitStr := r.URL.Query().Get("limit")
-	limit := 50
-	if limitStr != "" {
-		l, err := strconv.Atoi(limitStr)
-		if err == nil && l > 0 {
-			limit = l
+	query := r.URL.Query()
+	q := storage.EventQuery{
+		MessageID:    query.Get("message_id"),
+		FilterID:     query.Get("filter_id"),
+		MailboxID:    query.Get("mailbox_id"),
+		MailboxEmail: query.Get("mailbox"),
+		ErrorText:    query.Get("error"),
+	}
+	for _, status := range strings.Split(query.Get("status"), ",") {
+		if status = strings.TrimSpace(status); status != "" {
+			q.Statuses = append(q.Statuses, status)
+		}
+	}
+	if err := validateUUIDs(query, "filter_id", "mailbox_id"); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	limit, err := parseLimit(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	q.Limit = limit
+	for param, t := range map[string]*time.Time{"since": &q.Since, "until": &q.Until} {
+		v := query.Get(param)
+		if v == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			http.Error(w, param+" must be an RFC 3339 timestamp", http.StatusBadRequest)
+			return
 		}
+		*t = parsed
 	}
-	events, err := h.storage.GetEvents(r.Context(), limit)
+	if v := query.Get("cursor"); v != "" {
+		cursor, err := storage.DecodeEventCursor(v)
+		if err != nil {
+			http.Error(w, "Invalid cursor", http.StatusBadRequest)
+			return
+		}
+		q.After = &cursor
+	}
+	for param, b := range map[string]*bool{"total": &q.WithTotal, "steps": &q.Steps} {
+		v := query.Get(param)
+		if v == "" {
+			continue
+		}
+		parsed, err := strconv.ParseBool(v)
+		if err != nil {
+			http.Error(w, param+" must be true or false", http.StatusBadRequest)
+			return
+		}
+		*b = parsed
+	}
+
+	page, err := h.storage.ListEvents(r.Context(), q)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	json.NewEncoder(w).Encode(events)
+	json.NewEncoder(w).Encode(page)
 }
 
+// GetProcessedEmails lists recorded messages. Filters: mailbox_id, filter_id, label (a label
+// ID), from (sender address), since and until (RFC 3339), and limit (1 to 500).
+func (h *Handler) GetProcessedEmails(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	q := storage.ProcessedEmailQuery{
+		MailboxID:   query.Get("mailbox_id"),
+		FilterID:    query.Get("filter_id"),
+		Label:       query.Get("label"),
+		FromAddress: query.Get("from"),
+	}
+	if err := validateUUIDs(query, "mailbox_id", "filter_id"); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	limit, err := parseLimit(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	q.Limit = limit
+	for param, t := range map[string]*time.Time{"since": &q.Since, "until": &q.Until} {
+		v := query.Get(param)
+		if v == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			http.Error(w, param+" must be an RFC 3339 timestamp", http.StatusBadRequest)
+			return
+		}
+		*t = parsed
+	}
+
+	emails, err := h.storage.GetProcessedEmails(r.Context(), q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(emails)
+}
+
+func (h *Handler) GetProcessedEmail(w http.ResponseWriter, r *http.Request) {
+	email, err := h.storage.GetProcessedEmail(r.Context(), chi.URLParam(r, "messageId"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if email == nil {
+		http.Error(w, "Message not found", http.StatusNotFound)
+		return
+	}
+	json.NewEncoder(w).Encode(email)
+}
+
+// validateUUIDs checks that the given query parameters are empty or UUIDs, which is what
+// the storage queries compare them against.
+func validateUUIDs(query url.Values, params ...string) error {
+	for _, param := range params {
+		if v := query.Get(param); v != "" && uuid.Validate(v) != nil {
+			return fmt.Errorf("%s must be a UUID", param)
+		}
+	}
+	return nil
+}
+
+// parseLimit reads the limit parameter of a list: 50 when it is absent, 1 to 500 otherwise.
+func parseLimit(query url.Values) (int, error) {
+	v := query.Get("limit")
+	if v == "" {
+		return 50, nil
+	}
+	l, err := strconv.Atoi(v)
+	if err != nil || l < 1 || l > 500 {
+		return 0, errors.New("limit must be between 1 and 500")
+	}
+	return l, nil
+}
+
+// workerActions maps admin actions to the worker endpoints that perform them.
+var workerActions = map[string]string{
+	"renew-watch": "/renew-watch",
+	"resync":      "/resync",
+	"reprocess":   "/reprocess",
+}
+
+// ActionResult relays the worker's answer to an action.
+type ActionResult struct {
+	Action       string          `json:"action"`
+	JobID        string          `json:"job_id,omitempty"`
+	WorkerStatus int             `json:"worker_status"`
+	WorkerBody   json.RawMessage `json:"worker_body,omitempty"`
+}
+
+// TriggerAction records the action as a job and hands it to the worker, which runs it in the
+// background and reports progress on the job. The UI polls GET /admin/jobs/{id}.
 func (h *Handler) TriggerAction(w http.ResponseWriter, r *http.Request) {
 	action := chi.URLParam(r, "action") // renew-watch, resync, reprocess
 
-	// Delegate to worker implementation
-	// For now just return OK
-	// Real implementation needs to call Worker URL
+	path, ok := workerActions[action]
+	if !ok {
+		http.Error(w, "Unknown action: "+action, http.StatusBadRequest)
+		return
+	}
+	if h.worker == nil {
+		http.Error(w, "Worker is not configured", http.StatusServiceUnavailable)
+		return
+	}
 
-	// TODO: Call h.cfg.WorkerBaseURL + ...
+	// The request body holds the action's parameters and is passed on unchanged.
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		http.Error(w, "Invalid body", http.StatusBadRequest)
+		return
+	}
+	if len(bytes.TrimSpace(body)) == 0 {
+		body = []byte("{}")
+	}
+	if !json.Valid(body) {
+		http.Error(w, "Invalid body", http.StatusBadRequest)
+		return
+	}
 
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte("Action triggered: " + action))
+	job := &storage.Job{Type: action, Params: body, RequestedBy: getAdminEmail(r)}
+	if err := h.storage.CreateJob(r.Context(), job); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	result := ActionResult{Action: action, JobID: job.ID}
+	resp, err := h.worker.PostJob(r.Context(), path, job.ID, body)
+	if err != nil {
+		log.Printf("Action %s by %s: worker call failed: %v", action, getAdminEmail(r), err)
+		h.failJob(r, result.JobID, "Worker call failed: "+err.Error())
+		http.Error(w, "Worker call failed: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+	log.Printf("Action %s by %s: worker answered %d", action, getAdminEmail(r), resp.StatusCode)
+
+	result.WorkerStatus = resp.StatusCode
+	if len(resp.Body) > 0 {
+		if json.Valid(resp.Body) {
+			result.WorkerBody = resp.Body
+		} else {
+			// Plain text errors from http.Error are relayed as a JSON string.
+			result.WorkerBody, _ = json.Marshal(strings.TrimSpace(string(resp.Body)))
+		}
+	}
+	if resp.StatusCode >= 300 {
+		// The worker refused the job, so it will never report on it.
+		h.failJob(r, result.JobID, fmt.Sprintf("Worker answered %d: %s", resp.StatusCode, strings.TrimSpace(string(resp.Body))))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(resp.StatusCode)
+	json.NewEncoder(w).Encode(result)
+}
+
+func (h *Handler) failJob(r *http.Request, jobID, errMsg string) {
+	if err := h.storage.FailJob(r.Context(), jobID, errMsg); err != nil {
+		log.Printf("Failed to mark job %s as failed: %v", jobID, err)
+	}
+}
+
+func (h *Handler) GetJobs(w http.ResponseWriter, r *http.Request) {
+	limit, err := parseLimit(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	jobs, err := h.storage.GetJobs(r.Context(), r.URL.Query().Get("status"), limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(jobs)
+}
+
+func (h *Handler) GetJob(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if uuid.Validate(id) != nil {
+		http.Error(w, "id must be a UUID", http.StatusBadRequest)
+		return
+	}
+	job, err := h.storage.GetJob(r.Context(), id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if job == nil {
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return
+	}
+	json.NewEncoder(w).Encode(job)
 }
 
 func getAdminEmail(r *http.Request) string {
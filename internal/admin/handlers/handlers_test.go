@@ -0,0 +1,359 @@
+package handlers_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"gagarin-soft/internal/admin/config"
+	"gagarin-soft/internal/admin/handlers"
+	"gagarin-soft/internal/admin/worker"
+	"gagarin-soft/internal/storage"
+)
+
+func triggerAction(h *handlers.Handler, action, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", "/admin/actions/"+action, strings.NewReader(body))
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("action", action)
+	req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+	w := httptest.NewRecorder()
+	h.TriggerAction(w, req)
+	return w
+}
+
+// jobStore records the jobs TriggerAction creates and fails.
+type jobStore struct {
+	storage.AdminRepository
+	created []storage.Job
+	failed  map[string]string
+}
+
+func (s *jobStore) CreateJob(ctx context.Context, j *storage.Job) error {
+	j.ID = fmt.Sprintf("job-%d", len(s.created)+1)
+	s.created = append(s.created, *j)
+	return nil
+}
+
+func (s *jobStore) FailJob(ctx context.Context, id, errMsg string) error {
+	if s.failed == nil {
+		s.failed = make(map[string]string)
+	}
+	s.failed[id] = errMsg
+	return nil
+}
+
+func TestTriggerAction_RelaysWorkerResult(t *testing.T) {
+	var gotPath, gotBody, gotJobID string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		gotJobID = r.Header.Get(worker.JobIDHeader)
+		buf := new(strings.Builder)
+		_, _ = io.Copy(buf, r.Body)
+		gotBody = buf.String()
+		if r.URL.Path == "/reprocess" {
+			http.Error(w, "mailbox is not registered", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"processed": 3}`))
+	}))
+	defer srv.Close()
+
+	store := &jobStore{}
+	h := handlers.NewHandler(&config.Config{}, store, worker.NewClient(srv.URL, nil))
+
+	w := triggerAction(h, "resync", `{"emailAddress": "shop@example.com"}`)
+	if w.Code != http.StatusOK || gotPath != "/resync" || !strings.Contains(gotBody, "shop@example.com") {
+		t.Fatalf("Expected resync to reach the worker, got %d %s %q", w.Code, gotPath, gotBody)
+	}
+	var result handlers.ActionResult
+	if err := json.NewDecoder(w.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+	if result.Action != "resync" || result.JobID != "job-1" || result.WorkerStatus != 200 || string(result.WorkerBody) != `{"processed":3}` {
+		t.Errorf("Unexpected result %+v", result)
+	}
+	if gotJobID != "job-1" || len(store.created) != 1 || store.created[0].Type != "resync" {
+		t.Errorf("Expected the action to be sent as job job-1, got %q and %+v", gotJobID, store.created)
+	}
+
+	w = triggerAction(h, "reprocess", `{}`)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected the worker's 404 to be relayed, got %d", w.Code)
+	}
+	json.NewDecoder(w.Body).Decode(&result)
+	if string(result.WorkerBody) != `"mailbox is not registered"` {
+		t.Errorf("Expected the plain text error as a JSON string, got %s", result.WorkerBody)
+	}
+	if msg := store.failed["job-2"]; !strings.Contains(msg, "404") || len(store.failed) != 1 {
+		t.Errorf("Expected the refused job to be failed, got %v", store.failed)
+	}
+}
+
+func TestTriggerAction_RejectsUnknownAction(t *testing.T) {
+	h := handlers.NewHandler(&config.Config{}, nil, worker.NewClient("http://worker.invalid", nil))
+	if w := triggerAction(h, "format-disk", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400, got %d", w.Code)
+	}
+}
+
+func TestGetProcessedEmails_RejectsInvalidRange(t *testing.T) {
+	h := handlers.NewHandler(&config.Config{}, nil, nil)
+
+	w := httptest.NewRecorder()
+	h.GetProcessedEmails(w, httptest.NewRequest("GET", "/admin/processed-emails?since=yesterday", nil))
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "since") {
+		t.Errorf("Expected 400 for an invalid since, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestGetProcessedEmails_RejectsInvalidParameters(t *testing.T) {
+	h := handlers.NewHandler(&config.Config{}, nil, nil)
+
+	for _, query := range []string{"mailbox_id=shop", "filter_id=42", "limit=0", "limit=501", "limit=ten"} {
+		w := httptest.NewRecorder()
+		h.GetProcessedEmails(w, httptest.NewRequest("GET", "/admin/processed-emails?"+query, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", query, w.Code)
+		}
+	}
+}
+
+func TestGetJobs_RejectsInvalidParameters(t *testing.T) {
+	h := handlers.NewHandler(&config.Config{}, nil, nil)
+
+	for _, query := range []string{"limit=0", "limit=501", "limit=ten"} {
+		w := httptest.NewRecorder()
+		h.GetJobs(w, httptest.NewRequest("GET", "/admin/jobs?"+query, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", query, w.Code)
+		}
+	}
+}
+
+func TestGetJob_RejectsInvalidID(t *testing.T) {
+	h := handlers.NewHandler(&config.Config{}, nil, nil)
+
+	req := httptest.NewRequest("GET", "/admin/jobs/job-1", nil)
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("id", "job-1")
+	req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+	w := httptest.NewRecorder()
+	h.GetJob(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an ID that is not a UUID, got %d", w.Code)
+	}
+}
+
+// eventStore records the query of ListEvents; the other methods are not used.
+type eventStore struct {
+	storage.AdminRepository
+	got  storage.EventQuery
+	page storage.EventPage
+}
+
+func (s *eventStore) ListEvents(ctx context.Context, q storage.EventQuery) (*storage.EventPage, error) {
+	s.got = q
+	return &s.page, nil
+}
+
+func TestGetEvents_ParsesQuery(t *testing.T) {
+	created := time.Date(2026, 3, 1, 12, 0, 0, 123, time.UTC)
+	cursor := storage.EventCursor{CreatedAt: created, ID: "0b5a3c1e-8f7d-4c2a-9e1b-2d3f4a5b6c7d"}
+	store := &eventStore{page: storage.EventPage{
+		Events:     []storage.Event{{ID: "ev-1", MessageID: "m1", Status: "error"}},
+		NextCursor: "next",
+	}}
+	h := handlers.NewHandler(&config.Config{}, store, nil)
+
+	target := "/admin/events?status=error,+ignored&message_id=m1&mailbox=Shop@example.com" +
+		"&filter_id=7c9e6679-7425-40de-944b-e07fc1f90ae7&since=2026-03-01T00:00:00Z&error=timeout" +
+		"&limit=20&total=true&cursor=" + cursor.Encode()
+	w := httptest.NewRecorder()
+	h.GetEvents(w, httptest.NewRequest("GET", target, nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	want := storage.EventQuery{
+		Statuses:     []string{"error", "ignored"},
+		MessageID:    "m1",
+		FilterID:     "7c9e6679-7425-40de-944b-e07fc1f90ae7",
+		MailboxEmail: "Shop@example.com",
+		Since:        time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC),
+		ErrorText:    "timeout",
+		After:        &cursor,
+		Limit:        20,
+		WithTotal:    true,
+	}
+	if !reflect.DeepEqual(store.got, want) {
+		t.Errorf("Unexpected query:\n got %+v\nwant %+v", store.got, want)
+	}
+
+	var page storage.EventPage
+	if err := json.NewDecoder(w.Body).Decode(&page); err != nil || page.NextCursor != "next" || len(page.Events) != 1 {
+		t.Errorf("Unexpected page %+v, %v", page, err)
+	}
+}
+
+func TestGetEvents_RejectsInvalidParameters(t *testing.T) {
+	h := handlers.NewHandler(&config.Config{}, &eventStore{}, nil)
+
+	for _, query := range []string{"limit=0", "limit=501", "limit=ten", "cursor=garbage", "filter_id=42", "mailbox_id=shop", "until=tomorrow", "total=maybe", "steps=maybe"} {
+		w := httptest.NewRecorder()
+		h.GetEvents(w, httptest.NewRequest("GET", "/admin/events?"+query, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", query, w.Code)
+		}
+	}
+}
+
+// timelineStore serves the events, row and filters of one message.
+type timelineStore struct {
+	storage.AdminRepository
+	events  []storage.Event
+	email   *storage.ProcessedEmail
+	filters []storage.Filter
+}
+
+func (s *timelineStore) GetMessageEvents(ctx context.Context, messageID string) ([]storage.Event, error) {
+	return s.events, nil
+}
+
+func (s *timelineStore) GetProcessedEmail(ctx context.Context, messageID string) (*storage.ProcessedEmail, error) {
+	return s.email, nil
+}
+
+func (s *timelineStore) GetFilters(ctx context.Context) ([]storage.Filter, error) {
+	return s.filters, nil
+}
+
+func getTimeline(h *handlers.Handler, messageID string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", "/admin/messages/"+messageID, nil)
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("messageId", messageID)
+	req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+	w := httptest.NewRecorder()
+	h.GetMessageTimeline(w, req)
+	return w
+}
+
+func TestGetMessageTimeline(t *testing.T) {
+	at := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	step := func(offset time.Duration, status string, durationMS int64, details *storage.EventDetails) storage.Event {
+		details.Attempt = "a1"
+		if offset >= time.Hour {
+			details.Attempt = "a2"
+		}
+		return storage.Event{MessageID: "m1", Status: status, DurationMS: durationMS, Details: details, CreatedAt: at.Add(offset)}
+	}
+	store := &timelineStore{
+		events: []storage.Event{
+			// Missed first, then picked up after a new filter was added and it was reprocessed.
+			step(0, "fetched", 120, &storage.EventDetails{Trigger: "push", HistoryTypes: []string{"messageAdded"}}),
+			step(time.Millisecond, "unmatched", 1, &storage.EventDetails{Reason: "no enabled filter matched", FiltersChecked: []string{"Invoices"}}),
+			step(time.Hour, "fetched", 80, &storage.EventDetails{Trigger: "reprocess"}),
+			step(time.Hour+time.Millisecond, "matched", 1, &storage.EventDetails{Filter: "Receipts"}),
+			step(time.Hour+20*time.Millisecond, "saved", 19, &storage.EventDetails{Result: "created"}),
+			step(time.Hour+21*time.Millisecond, "processed", 101, &storage.EventDetails{}),
+		},
+		email:   &storage.ProcessedEmail{MessageID: "m1", FilterID: "f-receipts"},
+		filters: []storage.Filter{{ID: "f-invoices", Name: "Invoices"}, {ID: "f-receipts", Name: "Receipts"}},
+	}
+	store.events[3].FilterID = "f-receipts"
+	h := handlers.NewHandler(&config.Config{}, store, nil)
+
+	w := getTimeline(h, "m1")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var got handlers.MessageTimeline
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+
+	if got.ProcessedEmail == nil || len(got.Events) != 6 || !reflect.DeepEqual(got.HistoryTypes, []string{"messageAdded"}) {
+		t.Errorf("Unexpected timeline %+v", got)
+	}
+	if m := got.Match; m == nil || !m.Matched || m.Filter == nil || m.Filter.Name != "Receipts" {
+		t.Errorf("Expected the latest match by Receipts, got %+v", m)
+	}
+	if len(got.Attempts) != 2 {
+		t.Fatalf("Expected two attempts, got %+v", got.Attempts)
+	}
+	missed, retried := got.Attempts[0], got.Attempts[1]
+	if missed.Trigger != "push" || missed.Outcome != "unmatched" || missed.DurationMS != 121 || !missed.StartedAt.Equal(at.Add(-120*time.Millisecond)) {
+		t.Errorf("Unexpected first attempt %+v", missed)
+	}
+	if retried.Trigger != "reprocess" || retried.Outcome != "processed" || retried.DurationMS != 101 || len(retried.Steps) != 3 {
+		t.Errorf("Unexpected second attempt %+v", retried)
+	}
+
+	if w := getTimeline(handlers.NewHandler(&config.Config{}, &timelineStore{}, nil), "m404"); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown message, got %d", w.Code)
+	}
+}
+
+func TestGetMessageTimeline_SplitsAttemptsByID(t *testing.T) {
+	at := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	event := func(offset time.Duration, attempt, status string, durationMS int64) storage.Event {
+		return storage.Event{MessageID: "m1", Status: status, DurationMS: durationMS, CreatedAt: at.Add(offset),
+			Details: &storage.EventDetails{Attempt: attempt}}
+	}
+	store := &timelineStore{events: []storage.Event{
+		// A miss without an outcome, then an attempt that could not even fetch the message.
+		event(0, "a1", "fetched", 100),
+		event(time.Millisecond, "a1", "unmatched", 1),
+		event(time.Minute, "a2", "error", 30),
+	}}
+	store.events[2].Error = "Failed to get message: 500"
+
+	w := getTimeline(handlers.NewHandler(&config.Config{}, store, nil), "m1")
+	var got handlers.MessageTimeline
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if len(got.Attempts) != 2 {
+		t.Fatalf("Expected two attempts, got %+v", got.Attempts)
+	}
+	if a := got.Attempts[0]; a.ID != "a1" || a.Outcome != "unmatched" || a.Error != "" || len(a.Steps) != 2 {
+		t.Errorf("Unexpected first attempt %+v", a)
+	}
+	if a := got.Attempts[1]; a.ID != "a2" || a.Outcome != "error" || a.Error == "" || len(a.Steps) != 0 || a.DurationMS != 30 {
+		t.Errorf("Unexpected second attempt %+v", a)
+	}
+}
+
+// mailboxStore records the mailbox CreateMailbox stores.
+type mailboxStore struct {
+	storage.AdminRepository
+	created *storage.Mailbox
+}
+
+func (s *mailboxStore) CreateMailbox(ctx context.Context, m *storage.Mailbox) error {
+	s.created = m
+	return nil
+}
+
+func TestCreateMailbox_KeepsWatchSettingsEmpty(t *testing.T) {
+	store := &mailboxStore{}
+	h := handlers.NewHandler(&config.Config{}, store, nil)
+
+	w := httptest.NewRecorder()
+	h.CreateMailbox(w, httptest.NewRequest("POST", "/admin/mailboxes", strings.NewReader(`{"email_address": "shop@example.com", "refresh_token_secret": "shop-token"}`)))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	if m := store.created; m == nil || m.WatchLabels == nil || len(m.WatchLabels) != 0 || m.WatchLabelFilter != "" || m.Status != "active" {
+		t.Errorf("Expected empty watch settings, which defer to the worker config, got %+v", m)
+	}
+}
@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"gagarin-soft/internal/storage"
+)
+
+// MessageTimeline is everything recorded about one Gmail message: its stored row, how it was
+// matched, and each time the worker went over it.
+type MessageTimeline struct {
+	MessageID      string                  `json:"message_id"`
+	ProcessedEmail *storage.ProcessedEmail `json:"processed_email"`
+	Match          *MatchDecision          `json:"match,omitempty"`
+	// HistoryTypes are the Gmail history changes that brought the message up, over all pushes.
+	HistoryTypes []string        `json:"history_types,omitempty"`
+	Attempts     []Attempt       `json:"attempts"`
+	Events       []storage.Event `json:"events"`
+}
+
+// MatchDecision is the latest verdict of the filters on the message.
+type MatchDecision struct {
+	Matched  bool            `json:"matched"`
+	FilterID string          `json:"filter_id,omitempty"`
+	Filter   *storage.Filter `json:"filter,omitempty"` // nil once the filter is deleted
+	// Reason and FiltersChecked explain a miss.
+	Reason         string    `json:"reason,omitempty"`
+	FiltersChecked []string  `json:"filters_checked,omitempty"`
+	At             time.Time `json:"at"`
+}
+
+// Attempt is one pass of the worker over the message. Outcome is the status of its outcome
+// event, or of its last step when it ended without one (a miss outside of reprocessing).
+type Attempt struct {
+	ID         string       `json:"id,omitempty"` // empty for events recorded before attempts were tagged
+	Trigger    string       `json:"trigger,omitempty"`
+	StartedAt  time.Time    `json:"started_at"`
+	Outcome    string       `json:"outcome"`
+	Error      string       `json:"error,omitempty"`
+	DurationMS int64        `json:"duration_ms"`
+	Steps      []StepTiming `json:"steps,omitempty"`
+}
+
+// StepTiming is how long a step of an attempt took.
+type StepTiming struct {
+	Status     string `json:"status"`
+	DurationMS int64  `json:"duration_ms"`
+	Error      string `json:"error,omitempty"`
+}
+
+// GetMessageTimeline answers why a message was or wasn't picked up, from the events the
+// worker recorded about it and its processed_emails row.
+func (h *Handler) GetMessageTimeline(w http.ResponseWriter, r *http.Request) {
+	messageID := chi.URLParam(r, "messageId")
+	events, err := h.storage.GetMessageEvents(r.Context(), messageID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	email, err := h.storage.GetProcessedEmail(r.Context(), messageID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(events) == 0 && email == nil {
+		http.Error(w, "Message not found", http.StatusNotFound)
+		return
+	}
+
+	timeline := buildTimeline(messageID, events, email)
+	if m := timeline.Match; m != nil && m.FilterID != "" {
+		filters, err := h.storage.GetFilters(r.Context())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		for i := range filters {
+			if filters[i].ID == m.FilterID {
+				m.Filter = &filters[i]
+			}
+		}
+	}
+	json.NewEncoder(w).Encode(timeline)
+}
+
+// buildTimeline groups the events (oldest first) into attempts by the attempt ID in their
+// details. Untagged events, recorded before steps were, make outcome-only attempts.
+func buildTimeline(messageID string, events []storage.Event, email *storage.ProcessedEmail) *MessageTimeline {
+	t := &MessageTimeline{MessageID: messageID, ProcessedEmail: email, Attempts: []Attempt{}, Events: events}
+	if t.Events == nil {
+		t.Events = []storage.Event{}
+	}
+
+	var current *Attempt
+	for _, e := range events {
+		id := ""
+		if e.Details != nil {
+			id = e.Details.Attempt
+		}
+		if current == nil || id != current.ID || id == "" && storage.IsOutcome(current.Outcome) {
+			t.Attempts = append(t.Attempts, Attempt{ID: id, StartedAt: e.CreatedAt.Add(-time.Duration(e.DurationMS) * time.Millisecond)})
+			current = &t.Attempts[len(t.Attempts)-1]
+		}
+		current.Outcome = e.Status
+		if storage.IsOutcome(e.Status) {
+			current.Error = e.Error
+			current.DurationMS = e.DurationMS
+		} else {
+			current.Steps = append(current.Steps, StepTiming{Status: e.Status, DurationMS: e.DurationMS, Error: e.Error})
+			current.DurationMS += e.DurationMS
+		}
+
+		d := e.Details
+		if d == nil {
+			continue
+		}
+		if d.Trigger != "" {
+			current.Trigger = d.Trigger
+		}
+		for _, typ := range d.HistoryTypes {
+			if !slices.Contains(t.HistoryTypes, typ) {
+				t.HistoryTypes = append(t.HistoryTypes, typ)
+			}
+		}
+		switch e.Status {
+		case storage.EventMatched:
+			t.Match = &MatchDecision{Matched: true, FilterID: e.FilterID, At: e.CreatedAt}
+		case storage.EventUnmatched:
+			t.Match = &MatchDecision{Reason: d.Reason, FiltersChecked: d.FiltersChecked, At: e.CreatedAt}
+		}
+	}
+
+	// Messages processed before steps were recorded only tell which filter they matched.
+	if t.Match == nil && email != nil {
+		t.Match = &MatchDecision{Matched: true, FilterID: email.FilterID, At: email.CreatedAt}
+	}
+	return t
+}
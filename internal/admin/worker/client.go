@@ -0,0 +1,97 @@
+// Package worker calls the API worker service on behalf of the admin service.
+package worker
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"gagarin-soft/internal/oidc"
+)
+
+// maxResponseSize caps how much of a worker response is relayed to the UI.
+const maxResponseSize = 1 << 20
+
+// Client sends authenticated requests to the worker.
+type Client struct {
+	BaseURL string
+	// Audience of the ID tokens; defaults to BaseURL, which is what Cloud Run expects.
+	Audience string
+	// Signer issues the ID tokens. Requests are sent unauthenticated when nil.
+	Signer     oidc.Signer
+	HTTPClient *http.Client
+}
+
+func NewClient(baseURL string, signer oidc.Signer) *Client {
+	return &Client{
+		BaseURL:    strings.TrimRight(baseURL, "/"),
+		Signer:     signer,
+		HTTPClient: &http.Client{Timeout: 55 * time.Second}, // just under the admin router's timeout
+	}
+}
+
+// Response is the worker's answer, relayed as is.
+type Response struct {
+	StatusCode  int
+	ContentType string
+	Body        []byte
+}
+
+// JobIDHeader tells the worker to run the request in the background as the given job and
+// to report its progress to the jobs table.
+const JobIDHeader = "X-Job-ID"
+
+// Post sends body as JSON to path on the worker. Non-2xx answers are returned as a
+// Response, not an error; errors mean the worker could not be reached.
+func (c *Client) Post(ctx context.Context, path string, body []byte) (*Response, error) {
+	return c.post(ctx, path, body, "")
+}
+
+// PostJob is Post for an action tracked as job jobID. The worker accepts it with 202 and
+// runs it in the background.
+func (c *Client) PostJob(ctx context.Context, path, jobID string, body []byte) (*Response, error) {
+	return c.post(ctx, path, body, jobID)
+}
+
+func (c *Client) post(ctx context.Context, path string, body []byte, jobID string) (*Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.BaseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if jobID != "" {
+		req.Header.Set(JobIDHeader, jobID)
+	}
+
+	if c.Signer != nil {
+		audience := c.Audience
+		if audience == "" {
+			audience = c.BaseURL
+		}
+		token, err := c.Signer.Token(ctx, audience)
+		if err != nil {
+			return nil, fmt.Errorf("failed to sign worker request: %w", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read worker response: %w", err)
+	}
+	return &Response{
+		StatusCode:  resp.StatusCode,
+		ContentType: resp.Header.Get("Content-Type"),
+		Body:        data,
+	}, nil
+}
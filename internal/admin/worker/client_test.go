@@ -0,0 +1,90 @@
+package worker_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gagarin-soft/internal/admin/worker"
+	"gagarin-soft/internal/oidc"
+)
+
+func TestClient_PostSignsRequests(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwks, _ := oidc.MarshalJWKS(map[string]*rsa.PublicKey{"local": &key.PublicKey})
+	jwksPath := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(jwksPath, jwks, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	var srvURL string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		verifier, _ := oidc.NewVerifier(oidc.Config{JWKS: jwksPath, Audience: srvURL, Email: "admin@test.iam.gserviceaccount.com"})
+		token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if _, err := verifier.Verify(r.Context(), token); err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Path != "/resync" {
+			http.NotFound(w, r)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"echo": ` + string(body) + `}`))
+	}))
+	defer srv.Close()
+	srvURL = srv.URL
+
+	signer := &oidc.LocalSigner{Key: key, KeyID: "local", Email: "admin@test.iam.gserviceaccount.com"}
+	client := worker.NewClient(srv.URL+"/", signer)
+
+	resp, err := client.Post(context.Background(), "/resync", []byte(`{"emailAddress": "shop@example.com"}`))
+	if err != nil {
+		t.Fatalf("Post: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", resp.StatusCode, resp.Body)
+	}
+	if !strings.Contains(string(resp.Body), "shop@example.com") || resp.ContentType != "application/json" {
+		t.Errorf("Unexpected response %s (%s)", resp.Body, resp.ContentType)
+	}
+
+	// Error answers are relayed, not turned into errors.
+	resp, err = client.Post(context.Background(), "/missing", nil)
+	if err != nil {
+		t.Fatalf("Post: %v", err)
+	}
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected 404, got %d", resp.StatusCode)
+	}
+}
+
+func TestClient_PostRejectedWithoutToken(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "" {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	resp, err := worker.NewClient(srv.URL, nil).Post(context.Background(), "/renew-watch", nil)
+	if err != nil {
+		t.Fatalf("Post: %v", err)
+	}
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected 401, got %d", resp.StatusCode)
+	}
+}
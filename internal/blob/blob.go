@@ -0,0 +1,17 @@
+// Package blob stores attachment content in a pluggable backend
+// (local filesystem or an S3-compatible object store).
+package blob
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrNotFound is returned by Get when no object exists under the key.
+var ErrNotFound = errors.New("blob not found")
+
+// Store persists opaque content under slash-separated keys.
+type Store interface {
+	Put(ctx context.Context, key string, data []byte, contentType string) error
+	Get(ctx context.Context, key string) ([]byte, error)
+}
@@ -0,0 +1,113 @@
+package blob_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"gagarin-soft/internal/blob"
+)
+
+func TestLocalStore_PutGet(t *testing.T) {
+	store, err := blob.NewLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewLocalStore: %v", err)
+	}
+	ctx := context.Background()
+
+	if err := store.Put(ctx, "attachments/ab/abcdef", []byte("pdf-bytes"), "application/pdf"); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	got, err := store.Get(ctx, "attachments/ab/abcdef")
+	if err != nil || string(got) != "pdf-bytes" {
+		t.Fatalf("Get = %q, %v", got, err)
+	}
+
+	if _, err := store.Get(ctx, "attachments/missing"); !errors.Is(err, blob.ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+	if err := store.Put(ctx, "../escape", []byte("x"), ""); err == nil {
+		t.Errorf("expected an error for a key escaping the root")
+	}
+}
+
+// fakeObjectStore is a minimal MinIO-style stand-in that keeps objects in memory.
+type fakeObjectStore struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	types   map[string]string
+}
+
+func (f *fakeObjectStore) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=minio/") || !strings.Contains(auth, "/us-east-1/s3/aws4_request") {
+		http.Error(w, "AccessDenied", http.StatusForbidden)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch r.Method {
+	case http.MethodPut:
+		body, _ := io.ReadAll(r.Body)
+		sum := sha256.Sum256(body)
+		if r.Header.Get("X-Amz-Content-Sha256") != hex.EncodeToString(sum[:]) {
+			http.Error(w, "XAmzContentSHA256Mismatch", http.StatusBadRequest)
+			return
+		}
+		f.objects[r.URL.Path] = body
+		f.types[r.URL.Path] = r.Header.Get("Content-Type")
+	case http.MethodGet:
+		body, ok := f.objects[r.URL.Path]
+		if !ok {
+			http.Error(w, "NoSuchKey", http.StatusNotFound)
+			return
+		}
+		w.Write(body)
+	default:
+		http.Error(w, "MethodNotAllowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func TestS3Store_PutGet(t *testing.T) {
+	fake := &fakeObjectStore{objects: map[string][]byte{}, types: map[string]string{}}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	store, err := blob.NewS3Store(blob.S3Config{
+		Endpoint:        srv.URL,
+		Bucket:          "receipts",
+		AccessKeyID:     "minio",
+		SecretAccessKey: "minio123",
+		PathStyle:       true,
+	})
+	if err != nil {
+		t.Fatalf("NewS3Store: %v", err)
+	}
+	ctx := context.Background()
+
+	if err := store.Put(ctx, "attachments/ab/report 1.csv", []byte("a,b\n1,2"), "text/csv"); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if _, ok := fake.objects["/receipts/attachments/ab/report 1.csv"]; !ok {
+		t.Fatalf("object not stored under the bucket path, have %v", fake.objects)
+	}
+	if fake.types["/receipts/attachments/ab/report 1.csv"] != "text/csv" {
+		t.Errorf("content type not forwarded")
+	}
+
+	got, err := store.Get(ctx, "attachments/ab/report 1.csv")
+	if err != nil || string(got) != "a,b\n1,2" {
+		t.Fatalf("Get = %q, %v", got, err)
+	}
+	if _, err := store.Get(ctx, "attachments/none"); !errors.Is(err, blob.ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
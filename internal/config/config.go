@@ -2,20 +2,57 @@ package config
 
 import (
 	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gagarin-soft/internal/storage"
 )
 
 type Config struct {
-	ProjectID              string
-	OAuthClientID          string
-	OAuthClientSecret      string
-	Port                   string
-	DBUser                 string
-	DBPass                 string
-	DBName                 string
-	InstanceConnectionName string
-	AppEnv                 string
-	GmailPubSubTopic       string
-	TargetGmailLabel       string
+	ProjectID           string
+	OAuthClientID       string
+	OAuthClientSecret   string
+	Port                string
+	DB                  storage.ConnOptions
+	MigrateOnStart      bool
+	AppEnv              string
+	GmailPubSubTopic    string
+	GmailEndpoint       string
+	TargetGmailLabel    string
+	ResyncQuery         string
+	ResyncLookbackDays  int
+	ResyncMaxMessages   int
+	BlobBackend         string
+	BlobLocalDir        string
+	S3Endpoint          string
+	S3Region            string
+	S3Bucket            string
+	S3AccessKeyID       string
+	S3SecretAccessKey   string
+	S3PathStyle         bool
+	PushAuthAudience    string
+	PushAuthJWKS        string
+	PushAuthIssuers     []string
+	PushAuthEmail       string
+	PushAuthToken       string
+	AdminAuthAudience   string
+	AdminAuthJWKS       string
+	AdminAuthEmail      string
+	WorkerConcurrency   int
+	WorkerQueueSize     int
+	WorkerJobTimeout    time.Duration
+	AdminJobTimeout     time.Duration
+	ShutdownTimeout     time.Duration
+	PubSubDedupTTL      time.Duration
+	WatchRenewScheduler bool
+	WatchRenewInterval  time.Duration
+	WatchRenewBefore    time.Duration
+	WatchLabels         []string
+	WatchLabelFilter    string
+	LabelProcessed      string
+	LabelError          string
+	LabelIgnored        string
 }
 
 func Load() *Config {
@@ -37,16 +74,84 @@ func Load() *Config {
 	}
 
 	return &Config{
-		ProjectID:              projectID,
-		OAuthClientID:          os.Getenv("OAUTH_CLIENT_ID"),
-		OAuthClientSecret:      os.Getenv("OAUTH_CLIENT_SECRET"),
-		Port:                   port,
-		DBUser:                 os.Getenv("DB_USER"),
-		DBPass:                 os.Getenv("DB_PASS"),
-		DBName:                 os.Getenv("DB_NAME"),
-		InstanceConnectionName: os.Getenv("INSTANCE_CONNECTION_NAME"),
-		AppEnv:                 appEnv,
-		GmailPubSubTopic:       os.Getenv("GMAIL_PUBSUB_TOPIC"),
-		TargetGmailLabel:       os.Getenv("TARGET_GMAIL_LABEL"),
+		ProjectID:           projectID,
+		OAuthClientID:       os.Getenv("OAUTH_CLIENT_ID"),
+		OAuthClientSecret:   os.Getenv("OAUTH_CLIENT_SECRET"),
+		Port:                port,
+		DB:                  storage.ConnOptionsFromEnv(),
+		MigrateOnStart:      os.Getenv("MIGRATE_ON_START") == "true",
+		AppEnv:              appEnv,
+		GmailPubSubTopic:    os.Getenv("GMAIL_PUBSUB_TOPIC"),
+		GmailEndpoint:       os.Getenv("GMAIL_ENDPOINT"), // e.g. http://localhost:8085/ for cmd/fakegmail
+		TargetGmailLabel:    os.Getenv("TARGET_GMAIL_LABEL"),
+		ResyncQuery:         os.Getenv("RESYNC_QUERY"),
+		ResyncLookbackDays:  getEnvInt("RESYNC_LOOKBACK_DAYS", 7),
+		ResyncMaxMessages:   getEnvInt("RESYNC_MAX_MESSAGES", 500),
+		BlobBackend:         os.Getenv("BLOB_BACKEND"), // "", "local" or "s3"
+		BlobLocalDir:        getEnv("BLOB_LOCAL_DIR", "./data/blobs"),
+		S3Endpoint:          os.Getenv("S3_ENDPOINT"),
+		S3Region:            os.Getenv("S3_REGION"),
+		S3Bucket:            os.Getenv("S3_BUCKET"),
+		S3AccessKeyID:       os.Getenv("S3_ACCESS_KEY_ID"),
+		S3SecretAccessKey:   os.Getenv("S3_SECRET_ACCESS_KEY"),
+		S3PathStyle:         os.Getenv("S3_PATH_STYLE") != "false",
+		PushAuthAudience:    os.Getenv("PUSH_AUTH_AUDIENCE"), // enables OIDC verification of pushes
+		PushAuthJWKS:        os.Getenv("PUSH_AUTH_JWKS"),     // URL or file, defaults to Google's certs
+		PushAuthIssuers:     getEnvList("PUSH_AUTH_ISSUERS"),
+		PushAuthEmail:       os.Getenv("PUSH_AUTH_EMAIL"),
+		PushAuthToken:       os.Getenv("PUSH_AUTH_TOKEN"),
+		AdminAuthAudience:   os.Getenv("ADMIN_AUTH_AUDIENCE"), // audience of the admin's ID tokens, its WORKER_AUDIENCE
+		AdminAuthJWKS:       os.Getenv("ADMIN_AUTH_JWKS"),     // URL or file, defaults to Google's certs
+		AdminAuthEmail:      os.Getenv("ADMIN_AUTH_EMAIL"),    // service account the admin runs as
+		WorkerConcurrency:   getEnvInt("WORKER_CONCURRENCY", 4),
+		WorkerQueueSize:     getEnvInt("WORKER_QUEUE_SIZE", 100),
+		WorkerJobTimeout:    time.Duration(getEnvInt("WORKER_JOB_TIMEOUT_SECONDS", 300)) * time.Second,
+		AdminJobTimeout:     time.Duration(getEnvInt("ADMIN_JOB_TIMEOUT_MINUTES", 0)) * time.Minute, // resyncs and reprocesses; 0 means no limit
+		ShutdownTimeout:     time.Duration(getEnvInt("SHUTDOWN_TIMEOUT_SECONDS", 8)) * time.Second,
+		PubSubDedupTTL:      time.Duration(getEnvInt("PUBSUB_DEDUP_TTL_HOURS", 24)) * time.Hour,
+		WatchRenewScheduler: os.Getenv("WATCH_RENEW_SCHEDULER") != "false",
+		WatchRenewInterval:  time.Duration(getEnvInt("WATCH_RENEW_INTERVAL_MINUTES", 15)) * time.Minute,
+		WatchRenewBefore:    time.Duration(getEnvInt("WATCH_RENEW_BEFORE_HOURS", 24)) * time.Hour,
+		WatchLabels:         getEnvList("WATCH_LABELS"),               // names or IDs, INBOX if unset
+		WatchLabelFilter:    os.Getenv("WATCH_LABEL_FILTER_BEHAVIOR"), // "include" (default) or "exclude"
+		LabelProcessed:      os.Getenv("LABEL_PROCESSED"),             // e.g. pos/processed; unset disables that label
+		LabelError:          os.Getenv("LABEL_ERROR"),                 // e.g. pos/error
+		LabelIgnored:        os.Getenv("LABEL_IGNORED"),               // e.g. pos/ignored
+	}
+}
+
+// DatabaseConfigured reports whether a Postgres database is configured: a DSN, a Cloud SQL
+// instance or a host.
+func (c *Config) DatabaseConfigured() bool {
+	return c.DB.Configured()
+}
+
+// ConnOptions returns the database settings for storage.NewPostgresRepository.
+func (c *Config) ConnOptions() storage.ConnOptions {
+	return c.DB
+}
+
+func getEnv(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+func getEnvList(key string) []string {
+	var list []string
+	for _, v := range strings.Split(os.Getenv(key), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+func getEnvInt(key string, def int) int {
+	v, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return def
 	}
+	return v
 }
@@ -1,48 +1,241 @@
 package gmail
 
 import (
+	"errors"
 	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
 
 	gmail "google.golang.org/api/gmail/v1"
+	"google.golang.org/api/googleapi"
 )
 
-// ListMessageIDs returns a list of message IDs added since the given historyId
-func (c *Client) ListMessageIDs(historyId uint64) ([]string, error) {
+// ErrHistoryTooOld is returned when Gmail no longer has history for the requested start ID
+// (typically older than about a week). Callers have to fall back to a full resync.
+var ErrHistoryTooOld = errors.New("start history id is too old")
+
+// HistoryType is a Gmail history change type as reported by Users.History.List.
+type HistoryType string
+
+const (
+	HistoryMessageAdded HistoryType = "messageAdded"
+	HistoryLabelAdded   HistoryType = "labelAdded"
+	HistoryLabelRemoved HistoryType = "labelRemoved"
+)
+
+// HistoryMessage is a single message touched by one or more history records.
+type HistoryMessage struct {
+	ID    string
+	Types []HistoryType
+	// LabelIDs are the labels added to or removed from the message by those records.
+	LabelIDs []string
+}
+
+// Has reports whether the given change type applied to the message.
+func (m HistoryMessage) Has(t HistoryType) bool {
+	for _, v := range m.Types {
+		if v == t {
+			return true
+		}
+	}
+	return false
+}
+
+// IsNew reports whether the message was added to the mailbox (as opposed to only relabeled).
+func (m HistoryMessage) IsNew() bool {
+	return m.Has(HistoryMessageAdded)
+}
+
+// ListMessageIDs returns every message touched since the given historyId, following all
+// history pages. Each message appears once, in the order it was first seen, together with
+// the change types that applied to it.
+func (c *Client) ListMessageIDs(historyId uint64) ([]HistoryMessage, error) {
 	if historyId == 0 {
-		return []string{}, nil
+		return []HistoryMessage{}, nil
 	}
 
-	// Запрашиваем не только добавленные письма, но и события по меткам
-	resp, err := c.service.Users.History.List("me").
-		StartHistoryId(historyId).
-		HistoryTypes("messageAdded", "labelAdded", "labelRemoved").
-		Do()
-	if err != nil {
-		return nil, fmt.Errorf("failed to list history: %w", err)
+	var messages []HistoryMessage
+	index := make(map[string]int)
+	add := func(m *gmail.Message, t HistoryType, labelIDs []string) {
+		if m == nil || m.Id == "" {
+			return
+		}
+		i, ok := index[m.Id]
+		if !ok {
+			i = len(messages)
+			index[m.Id] = i
+			messages = append(messages, HistoryMessage{ID: m.Id})
+		}
+		msg := &messages[i]
+		if !msg.Has(t) {
+			msg.Types = append(msg.Types, t)
+		}
+		for _, id := range labelIDs {
+			if !slices.Contains(msg.LabelIDs, id) {
+				msg.LabelIDs = append(msg.LabelIDs, id)
+			}
+		}
 	}
 
-	var messageIDs []string
-	for _, h := range resp.History {
-		// письма, добавленные в историю
-		for _, m := range h.MessagesAdded {
-			if m.Message != nil {
-				messageIDs = append(messageIDs, m.Message.Id)
+	pageToken := ""
+	for {
+		// Запрашиваем не только добавленные письма, но и события по меткам
+		call := c.service.Users.History.List("me").
+			StartHistoryId(historyId).
+			HistoryTypes(string(HistoryMessageAdded), string(HistoryLabelAdded), string(HistoryLabelRemoved))
+		if pageToken != "" {
+			call = call.PageToken(pageToken)
+		}
+		resp, err := call.Do()
+		if err != nil {
+			if IsNotFound(err) {
+				return nil, fmt.Errorf("%w: %v", ErrHistoryTooOld, err)
 			}
+			return nil, fmt.Errorf("failed to list history: %w", err)
 		}
-		// письма, получившие новые метки
-		for _, m := range h.LabelsAdded {
-			if m.Message != nil {
-				messageIDs = append(messageIDs, m.Message.Id)
+
+		for _, h := range resp.History {
+			// письма, добавленные в историю
+			for _, m := range h.MessagesAdded {
+				add(m.Message, HistoryMessageAdded, nil)
 			}
+			// письма, получившие новые метки
+			for _, m := range h.LabelsAdded {
+				add(m.Message, HistoryLabelAdded, m.LabelIds)
+			}
+			// письма, у которых сняли метки
+			for _, m := range h.LabelsRemoved {
+				add(m.Message, HistoryLabelRemoved, m.LabelIds)
+			}
+		}
+
+		if resp.NextPageToken == "" {
+			break
+		}
+		pageToken = resp.NextPageToken
+	}
+	return messages, nil
+}
+
+// ListMessages returns the IDs of messages matching the search query and label IDs that
+// arrived after the given time, newest first. At most limit IDs are returned (0 means no limit).
+func (c *Client) ListMessages(query string, labelIDs []string, after time.Time, limit int) ([]string, error) {
+	q := query
+	if !after.IsZero() {
+		if q != "" {
+			q += " "
+		}
+		q += fmt.Sprintf("after:%d", after.Unix())
+	}
+
+	var ids []string
+	pageToken := ""
+	for {
+		call := c.service.Users.Messages.List("me").Q(q)
+		if len(labelIDs) > 0 {
+			call = call.LabelIds(labelIDs...)
 		}
-		// письма, у которых сняли метки
-		for _, m := range h.LabelsRemoved {
-			if m.Message != nil {
-				messageIDs = append(messageIDs, m.Message.Id)
+		if pageToken != "" {
+			call = call.PageToken(pageToken)
+		}
+		resp, err := call.Do()
+		if err != nil {
+			return nil, fmt.Errorf("failed to list messages: %w", err)
+		}
+
+		for _, m := range resp.Messages {
+			ids = append(ids, m.Id)
+			if limit > 0 && len(ids) >= limit {
+				return ids, nil
+			}
+		}
+
+		if resp.NextPageToken == "" {
+			break
+		}
+		pageToken = resp.NextPageToken
+	}
+	return ids, nil
+}
+
+// LabelNames returns a map of label ID to display name for all labels in the mailbox
+func (c *Client) LabelNames() (map[string]string, error) {
+	resp, err := c.service.Users.Labels.List("me").Do()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list labels: %w", err)
+	}
+	names := make(map[string]string, len(resp.Labels))
+	for _, l := range resp.Labels {
+		names[l.Id] = l.Name
+	}
+	return names, nil
+}
+
+// EnsureLabels returns the IDs of the named user labels, keyed by the given names. Labels
+// that don't exist yet are created; names match existing labels case-insensitively.
+func (c *Client) EnsureLabels(names []string) (map[string]string, error) {
+	byName, err := c.labelIDsByName()
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make(map[string]string, len(names))
+	for _, name := range names {
+		if id, ok := byName[strings.ToLower(name)]; ok {
+			ids[name] = id
+			continue
+		}
+		label, err := c.service.Users.Labels.Create("me", &gmail.Label{
+			Name:                  name,
+			LabelListVisibility:   "labelShow",
+			MessageListVisibility: "show",
+		}).Do()
+		var apiErr *googleapi.Error
+		if errors.As(err, &apiErr) && apiErr.Code == http.StatusConflict {
+			// Created concurrently by another instance since the listing.
+			if byName, err = c.labelIDsByName(); err != nil {
+				return nil, err
 			}
+			if id, ok := byName[strings.ToLower(name)]; ok {
+				ids[name] = id
+				continue
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to create label %q: %w", name, err)
 		}
+		byName[strings.ToLower(name)] = label.Id
+		ids[name] = label.Id
 	}
-	return messageIDs, nil
+	return ids, nil
+}
+
+func (c *Client) labelIDsByName() (map[string]string, error) {
+	names, err := c.LabelNames()
+	if err != nil {
+		return nil, err
+	}
+	byName := make(map[string]string, len(names))
+	for id, name := range names {
+		byName[strings.ToLower(name)] = id
+	}
+	return byName, nil
+}
+
+// ModifyLabels adds and removes label IDs on a message.
+func (c *Client) ModifyLabels(messageId string, add, remove []string) error {
+	_, err := c.service.Users.Messages.Modify("me", messageId, &gmail.ModifyMessageRequest{
+		AddLabelIds:    add,
+		RemoveLabelIds: remove,
+	}).Do()
+	return err
+}
+
+// GetProfile returns the mailbox address and its current history ID
+func (c *Client) GetProfile() (*gmail.Profile, error) {
+	return c.service.Users.GetProfile("me").Do()
 }
 
 // GetMessage returns the full message details including labels
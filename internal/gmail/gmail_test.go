@@ -0,0 +1,120 @@
+package gmail_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"gagarin-soft/internal/gmail"
+)
+
+type MockTransport struct {
+	RoundTripFunc func(req *http.Request) (*http.Response, error)
+}
+
+func (m *MockTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return m.RoundTripFunc(req)
+}
+
+func jsonResponse(body string) *http.Response {
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Body:       io.NopCloser(bytes.NewBufferString(body)),
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+	}
+}
+
+func TestClient_ListMessageIDs_PaginatesAndDeduplicates(t *testing.T) {
+	pages := map[string]string{
+		"": `{"history": [
+			{"id": "101", "messagesAdded": [{"message": {"id": "m1"}}]},
+			{"id": "102", "labelsAdded": [{"message": {"id": "m1"}, "labelIds": ["Label_1"]}]}
+		], "nextPageToken": "p2", "historyId": "110"}`,
+		"p2": `{"history": [
+			{"id": "103", "labelsRemoved": [{"message": {"id": "m2"}, "labelIds": ["UNREAD"]}]},
+			{"id": "104", "messagesAdded": [{"message": {"id": "m1"}}, {"message": {"id": "m3"}}]}
+		], "historyId": "110"}`,
+	}
+
+	var calls []string
+	transport := &MockTransport{
+		RoundTripFunc: func(req *http.Request) (*http.Response, error) {
+			if req.URL.Path != "/gmail/v1/users/me/history" {
+				t.Fatalf("unexpected request path %s", req.URL.Path)
+			}
+			if got := req.URL.Query().Get("startHistoryId"); got != "100" {
+				t.Errorf("expected startHistoryId 100, got %q", got)
+			}
+			token := req.URL.Query().Get("pageToken")
+			calls = append(calls, token)
+			return jsonResponse(pages[token]), nil
+		},
+	}
+
+	client, err := gmail.NewClient(context.Background(), &http.Client{Transport: transport})
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+
+	got, err := client.ListMessageIDs(100)
+	if err != nil {
+		t.Fatalf("ListMessageIDs: %v", err)
+	}
+
+	if !reflect.DeepEqual(calls, []string{"", "p2"}) {
+		t.Errorf("expected two page requests, got %q", calls)
+	}
+
+	want := []gmail.HistoryMessage{
+		{ID: "m1", Types: []gmail.HistoryType{gmail.HistoryMessageAdded, gmail.HistoryLabelAdded}, LabelIDs: []string{"Label_1"}},
+		{ID: "m2", Types: []gmail.HistoryType{gmail.HistoryLabelRemoved}, LabelIDs: []string{"UNREAD"}},
+		{ID: "m3", Types: []gmail.HistoryType{gmail.HistoryMessageAdded}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected messages:\n got %+v\nwant %+v", got, want)
+	}
+
+	if !got[0].IsNew() || got[1].IsNew() {
+		t.Errorf("IsNew mismatch: m1=%v m2=%v", got[0].IsNew(), got[1].IsNew())
+	}
+}
+
+func TestClient_ResolveLabelIDs(t *testing.T) {
+	labelCalls := 0
+	transport := &MockTransport{
+		RoundTripFunc: func(req *http.Request) (*http.Response, error) {
+			labelCalls++
+			return jsonResponse(`{"labels": [
+				{"id": "INBOX", "name": "INBOX"},
+				{"id": "Label_7", "name": "POS/Receipts"}
+			]}`), nil
+		},
+	}
+	client, err := gmail.NewClient(context.Background(), &http.Client{Transport: transport})
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+
+	ids, err := client.ResolveLabelIDs([]string{"inbox", "CATEGORY_UPDATES"})
+	if err != nil {
+		t.Fatalf("ResolveLabelIDs: %v", err)
+	}
+	if !reflect.DeepEqual(ids, []string{"INBOX", "CATEGORY_UPDATES"}) || labelCalls != 0 {
+		t.Errorf("Expected system labels without a lookup, got %v after %d calls", ids, labelCalls)
+	}
+
+	ids, err = client.ResolveLabelIDs([]string{"pos/receipts", "Label_7", "INBOX"})
+	if err != nil {
+		t.Fatalf("ResolveLabelIDs: %v", err)
+	}
+	if !reflect.DeepEqual(ids, []string{"Label_7", "Label_7", "INBOX"}) || labelCalls != 1 {
+		t.Errorf("Expected user labels resolved with one lookup, got %v after %d calls", ids, labelCalls)
+	}
+
+	if _, err := client.ResolveLabelIDs([]string{"Missing"}); err == nil {
+		t.Error("Expected an error for an unknown label")
+	}
+}
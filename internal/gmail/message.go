@@ -0,0 +1,340 @@
+package gmail
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"time"
+
+	"golang.org/x/text/encoding/htmlindex"
+	gmail "google.golang.org/api/gmail/v1"
+)
+
+// DecodedMessage is a Gmail message with its MIME tree walked and decoded.
+type DecodedMessage struct {
+	ID           string
+	ThreadID     string
+	HistoryID    uint64
+	LabelIDs     []string
+	Snippet      string
+	SizeEstimate int64
+	InternalDate time.Time
+
+	Headers  Headers
+	TextBody string // first text/plain part, converted to UTF-8
+	HTMLBody string // first text/html part, converted to UTF-8
+
+	Attachments []AttachmentInfo
+}
+
+// Headers holds the decoded top-level headers of a message.
+type Headers struct {
+	From      string
+	To        string
+	Cc        string
+	Subject   string
+	Date      time.Time
+	MessageID string
+	ListID    string
+}
+
+// AttachmentInfo describes a single attachment. Either AttachmentID is set and the content
+// has to be fetched with GetAttachment, or the content was small enough to be inline in Data.
+type AttachmentInfo struct {
+	PartID       string
+	Filename     string
+	MimeType     string
+	Size         int64
+	AttachmentID string
+	ContentID    string
+	Inline       bool
+	Data         []byte
+}
+
+// GetDecodedMessage fetches a message in full format and decodes it.
+func (c *Client) GetDecodedMessage(messageId string) (*DecodedMessage, error) {
+	msg, err := c.GetMessage(messageId)
+	if err != nil {
+		return nil, err
+	}
+	return Decode(msg)
+}
+
+// GetAttachment downloads the content of an attachment by its attachment ID.
+func (c *Client) GetAttachment(messageId, attachmentId string) ([]byte, error) {
+	body, err := c.service.Users.Messages.Attachments.Get("me", messageId, attachmentId).Do()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get attachment: %w", err)
+	}
+	return decodeBase64URL(body.Data)
+}
+
+// AttachmentContent returns the content of an attachment, downloading it unless it was inline.
+func (c *Client) AttachmentContent(messageId string, a AttachmentInfo) ([]byte, error) {
+	if a.AttachmentID == "" {
+		return a.Data, nil
+	}
+	return c.GetAttachment(messageId, a.AttachmentID)
+}
+
+// Decode decodes a message fetched in "full" format (payload tree) or "raw" format
+// (base64url RFC 822 source). Bodies are converted to UTF-8 from their declared charset.
+func Decode(msg *gmail.Message) (*DecodedMessage, error) {
+	d := &DecodedMessage{
+		ID:           msg.Id,
+		ThreadID:     msg.ThreadId,
+		HistoryID:    msg.HistoryId,
+		LabelIDs:     msg.LabelIds,
+		Snippet:      msg.Snippet,
+		SizeEstimate: msg.SizeEstimate,
+	}
+	if msg.InternalDate > 0 {
+		d.InternalDate = time.UnixMilli(msg.InternalDate)
+	}
+
+	switch {
+	case msg.Raw != "":
+		raw, err := decodeBase64URL(msg.Raw)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode raw message %s: %w", msg.Id, err)
+		}
+		if err := d.decodeRaw(raw); err != nil {
+			return nil, fmt.Errorf("failed to parse raw message %s: %w", msg.Id, err)
+		}
+	case msg.Payload != nil:
+		d.Headers = decodeHeaders(partHeader(msg.Payload))
+		if err := d.walkPart(msg.Payload); err != nil {
+			return nil, fmt.Errorf("failed to decode message %s: %w", msg.Id, err)
+		}
+	}
+	return d, nil
+}
+
+// walkPart handles the payload tree of a "full" message. Gmail has already removed the
+// Content-Transfer-Encoding; the body data is base64url in the part's charset.
+func (d *DecodedMessage) walkPart(p *gmail.MessagePart) error {
+	if len(p.Parts) > 0 {
+		for _, child := range p.Parts {
+			if err := d.walkPart(child); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	header := partHeader(p)
+	mediaType, params, _ := mime.ParseMediaType(p.MimeType)
+	if ct := header.Get("Content-Type"); ct != "" {
+		if mt, ps, err := mime.ParseMediaType(ct); err == nil {
+			mediaType, params = mt, ps
+		}
+	}
+
+	var data []byte
+	if p.Body != nil && p.Body.Data != "" {
+		var err error
+		data, err = decodeBase64URL(p.Body.Data)
+		if err != nil {
+			return fmt.Errorf("part %s: %w", p.PartId, err)
+		}
+	}
+
+	var size int64
+	var attachmentID string
+	if p.Body != nil {
+		size = p.Body.Size
+		attachmentID = p.Body.AttachmentId
+	}
+	d.addLeaf(p.PartId, mediaType, params, header, p.Filename, attachmentID, size, data)
+	return nil
+}
+
+// decodeRaw parses an RFC 822 message, including multipart bodies and transfer encodings.
+func (d *DecodedMessage) decodeRaw(raw []byte) error {
+	m, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return err
+	}
+	d.Headers = decodeHeaders(m.Header)
+	return d.readEntity("", m.Header, m.Body)
+}
+
+func (d *DecodedMessage) readEntity(partID string, header mail.Header, body io.Reader) error {
+	contentType := header.Get("Content-Type")
+	if contentType == "" {
+		contentType = "text/plain"
+	}
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType, params = "text/plain", map[string]string{}
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		mr := multipart.NewReader(body, params["boundary"])
+		for i := 0; ; i++ {
+			part, err := mr.NextRawPart()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			childID := fmt.Sprint(i)
+			if partID != "" {
+				childID = partID + "." + childID
+			}
+			if err := d.readEntity(childID, mail.Header(part.Header), part); err != nil {
+				return err
+			}
+		}
+	}
+
+	data, err := io.ReadAll(transferDecoder(header.Get("Content-Transfer-Encoding"), body))
+	if err != nil {
+		return fmt.Errorf("part %s: %w", partID, err)
+	}
+	filename := ""
+	if _, dparams, err := mime.ParseMediaType(header.Get("Content-Disposition")); err == nil {
+		filename = dparams["filename"]
+	}
+	if filename == "" {
+		filename = params["name"]
+	}
+	d.addLeaf(partID, mediaType, params, header, decodeWords(filename), "", int64(len(data)), data)
+	return nil
+}
+
+// addLeaf stores a non-multipart part either as a body or as an attachment.
+func (d *DecodedMessage) addLeaf(partID, mediaType string, params map[string]string, header mail.Header, filename, attachmentID string, size int64, data []byte) {
+	disposition, _, _ := mime.ParseMediaType(header.Get("Content-Disposition"))
+	isBody := filename == "" && attachmentID == "" && disposition != "attachment"
+
+	if isBody && mediaType == "text/plain" && d.TextBody == "" {
+		d.TextBody = toUTF8(data, params["charset"])
+		return
+	}
+	if isBody && mediaType == "text/html" && d.HTMLBody == "" {
+		d.HTMLBody = toUTF8(data, params["charset"])
+		return
+	}
+	if filename == "" && attachmentID == "" {
+		// Alternative bodies beyond the first and other unnamed inline parts are not attachments.
+		return
+	}
+
+	d.Attachments = append(d.Attachments, AttachmentInfo{
+		PartID:       partID,
+		Filename:     filename,
+		MimeType:     mediaType,
+		Size:         size,
+		AttachmentID: attachmentID,
+		ContentID:    strings.Trim(header.Get("Content-ID"), "<>"),
+		Inline:       disposition == "inline",
+		Data:         data,
+	})
+}
+
+// partHeader converts API headers to a mail.Header with canonical keys.
+func partHeader(p *gmail.MessagePart) mail.Header {
+	header := make(mail.Header)
+	for _, h := range p.Headers {
+		key := textproto.CanonicalMIMEHeaderKey(h.Name)
+		header[key] = append(header[key], h.Value)
+	}
+	return header
+}
+
+func decodeHeaders(h mail.Header) Headers {
+	out := Headers{
+		From:      decodeWords(h.Get("From")),
+		To:        decodeWords(h.Get("To")),
+		Cc:        decodeWords(h.Get("Cc")),
+		Subject:   decodeWords(h.Get("Subject")),
+		MessageID: strings.Trim(strings.TrimSpace(h.Get("Message-ID")), "<>"),
+		ListID:    decodeWords(h.Get("List-Id")),
+	}
+	if date, err := mail.ParseDate(h.Get("Date")); err == nil {
+		out.Date = date
+	}
+	return out
+}
+
+var wordDecoder = &mime.WordDecoder{
+	CharsetReader: func(charset string, input io.Reader) (io.Reader, error) {
+		enc, err := htmlindex.Get(charset)
+		if err != nil {
+			return nil, err
+		}
+		return enc.NewDecoder().Reader(input), nil
+	},
+}
+
+// decodeWords decodes RFC 2047 encoded-words (=?utf-8?B?...?=), leaving the value as-is on error.
+func decodeWords(s string) string {
+	if !strings.Contains(s, "=?") {
+		return s
+	}
+	decoded, err := wordDecoder.DecodeHeader(s)
+	if err != nil {
+		return s
+	}
+	return decoded
+}
+
+func transferDecoder(encoding string, r io.Reader) io.Reader {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "base64":
+		return base64.NewDecoder(base64.StdEncoding, &newlineStripper{r: r})
+	case "quoted-printable":
+		return quotedprintable.NewReader(r)
+	default:
+		return r
+	}
+}
+
+// newlineStripper drops CR/LF so that line-wrapped base64 can be fed to base64.NewDecoder.
+type newlineStripper struct {
+	r io.Reader
+}
+
+func (s *newlineStripper) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	j := 0
+	for _, b := range p[:n] {
+		if b != '\r' && b != '\n' {
+			p[j] = b
+			j++
+		}
+	}
+	return j, err
+}
+
+// toUTF8 converts text in the given charset to UTF-8. Unknown charsets are passed through.
+func toUTF8(data []byte, charset string) string {
+	charset = strings.ToLower(strings.TrimSpace(charset))
+	if charset == "" || charset == "utf-8" || charset == "us-ascii" {
+		return string(data)
+	}
+	enc, err := htmlindex.Get(charset)
+	if err != nil {
+		return string(data)
+	}
+	out, err := enc.NewDecoder().Bytes(data)
+	if err != nil {
+		return string(data)
+	}
+	return string(out)
+}
+
+// decodeBase64URL decodes Gmail's base64url data, with or without padding.
+func decodeBase64URL(s string) ([]byte, error) {
+	s = strings.TrimRight(s, "=")
+	return base64.RawURLEncoding.DecodeString(s)
+}
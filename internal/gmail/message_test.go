@@ -0,0 +1,142 @@
+package gmail_test
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+
+	gmailapi "google.golang.org/api/gmail/v1"
+
+	"gagarin-soft/internal/gmail"
+)
+
+func b64url(s string) string {
+	return base64.URLEncoding.EncodeToString([]byte(s))
+}
+
+func TestDecode_FullFormat(t *testing.T) {
+	// "Чек" in windows-1251
+	cp1251 := string([]byte{0xD7, 0xE5, 0xEA})
+
+	msg := &gmailapi.Message{
+		Id:           "m1",
+		ThreadId:     "t1",
+		HistoryId:    42,
+		InternalDate: 1718000000000,
+		Payload: &gmailapi.MessagePart{
+			MimeType: "multipart/mixed",
+			Headers: []*gmailapi.MessagePartHeader{
+				{Name: "From", Value: "=?utf-8?B?0JrQsNGB0YHQsA==?= <pos@shop.example>"},
+				{Name: "To", Value: "shop@example.com"},
+				{Name: "Subject", Value: "Receipt #1"},
+				{Name: "Date", Value: "Mon, 10 Jun 2024 09:30:00 +0300"},
+				{Name: "Message-ID", Value: "<abc@shop.example>"},
+				{Name: "List-Id", Value: "Receipts <receipts.shop.example>"},
+			},
+			Parts: []*gmailapi.MessagePart{
+				{
+					MimeType: "multipart/alternative",
+					Parts: []*gmailapi.MessagePart{
+						{
+							PartId:   "0.0",
+							MimeType: "text/plain",
+							Headers:  []*gmailapi.MessagePartHeader{{Name: "Content-Type", Value: "text/plain; charset=windows-1251"}},
+							Body:     &gmailapi.MessagePartBody{Data: b64url(cp1251 + " 100")},
+						},
+						{
+							PartId:   "0.1",
+							MimeType: "text/html",
+							Headers:  []*gmailapi.MessagePartHeader{{Name: "Content-Type", Value: "text/html; charset=utf-8"}},
+							Body:     &gmailapi.MessagePartBody{Data: strings.TrimRight(b64url("<b>Чек</b> 100"), "=")},
+						},
+					},
+				},
+				{
+					PartId:   "1",
+					MimeType: "application/pdf",
+					Filename: "receipt.pdf",
+					Headers:  []*gmailapi.MessagePartHeader{{Name: "Content-Disposition", Value: `attachment; filename="receipt.pdf"`}},
+					Body:     &gmailapi.MessagePartBody{AttachmentId: "att-1", Size: 2048},
+				},
+			},
+		},
+	}
+
+	d, err := gmail.Decode(msg)
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+
+	if d.Headers.From != "Касса <pos@shop.example>" {
+		t.Errorf("unexpected From %q", d.Headers.From)
+	}
+	if d.Headers.MessageID != "abc@shop.example" {
+		t.Errorf("unexpected Message-ID %q", d.Headers.MessageID)
+	}
+	if d.Headers.ListID != "Receipts <receipts.shop.example>" {
+		t.Errorf("unexpected List-Id %q", d.Headers.ListID)
+	}
+	wantDate := time.Date(2024, 6, 10, 6, 30, 0, 0, time.UTC)
+	if !d.Headers.Date.Equal(wantDate) {
+		t.Errorf("unexpected Date %v", d.Headers.Date)
+	}
+	if d.TextBody != "Чек 100" {
+		t.Errorf("unexpected text body %q", d.TextBody)
+	}
+	if d.HTMLBody != "<b>Чек</b> 100" {
+		t.Errorf("unexpected html body %q", d.HTMLBody)
+	}
+	if len(d.Attachments) != 1 {
+		t.Fatalf("expected 1 attachment, got %+v", d.Attachments)
+	}
+	att := d.Attachments[0]
+	if att.Filename != "receipt.pdf" || att.AttachmentID != "att-1" || att.Size != 2048 || att.MimeType != "application/pdf" {
+		t.Errorf("unexpected attachment %+v", att)
+	}
+}
+
+func TestDecode_RawFormat(t *testing.T) {
+	raw := strings.Join([]string{
+		"From: POS <pos@shop.example>",
+		"To: shop@example.com",
+		"Subject: =?utf-8?Q?Z=C3=A1kaz_123?=",
+		"Message-ID: <raw@shop.example>",
+		"MIME-Version: 1.0",
+		`Content-Type: multipart/mixed; boundary="b1"`,
+		"",
+		"--b1",
+		"Content-Type: text/plain; charset=utf-8",
+		"Content-Transfer-Encoding: quoted-printable",
+		"",
+		"Total: 10=E2=82=AC, thank =",
+		"you",
+		"--b1",
+		`Content-Type: text/csv; name="items.csv"`,
+		`Content-Disposition: attachment; filename="items.csv"`,
+		"Content-Transfer-Encoding: base64",
+		"",
+		"c2t1LHF0eQ0KMSwy",
+		"--b1--",
+		"",
+	}, "\r\n")
+
+	d, err := gmail.Decode(&gmailapi.Message{Id: "m2", Raw: b64url(raw)})
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+
+	if d.Headers.Subject != "Zákaz 123" {
+		t.Errorf("unexpected subject %q", d.Headers.Subject)
+	}
+	if d.TextBody != "Total: 10€, thank you" {
+		t.Errorf("unexpected text body %q", d.TextBody)
+	}
+	if len(d.Attachments) != 1 {
+		t.Fatalf("expected 1 attachment, got %+v", d.Attachments)
+	}
+	att := d.Attachments[0]
+	if att.Filename != "items.csv" || att.MimeType != "text/csv" || string(att.Data) != "sku,qty\r\n1,2" {
+		t.Errorf("unexpected attachment %+v (data %q)", att, att.Data)
+	}
+}
@@ -2,10 +2,13 @@ package gmail
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
+	"strings"
 
 	gmail "google.golang.org/api/gmail/v1"
+	"google.golang.org/api/googleapi"
 	"google.golang.org/api/option"
 )
 
@@ -13,8 +16,10 @@ type Client struct {
 	service *gmail.Service
 }
 
-func NewClient(ctx context.Context, httpClient *http.Client) (*Client, error) {
-	srv, err := gmail.NewService(ctx, option.WithHTTPClient(httpClient))
+// NewClient creates a client that sends its requests through httpClient. Extra options such
+// as option.WithEndpoint (e.g. for gmailtest) are passed on to the API service.
+func NewClient(ctx context.Context, httpClient *http.Client, opts ...option.ClientOption) (*Client, error) {
+	srv, err := gmail.NewService(ctx, append([]option.ClientOption{option.WithHTTPClient(httpClient)}, opts...)...)
 	if err != nil {
 		return nil, fmt.Errorf("unable to retrieve Gmail client: %w", err)
 	}
@@ -24,15 +29,52 @@ func NewClient(ctx context.Context, httpClient *http.Client) (*Client, error) {
 	}, nil
 }
 
+// IsNotFound reports whether err is a Gmail API 404 response.
+func IsNotFound(err error) bool {
+	var apiErr *googleapi.Error
+	return errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound
+}
+
 type WatchResponse struct {
 	HistoryId  uint64 `json:"historyId,string"`
 	Expiration int64  `json:"expiration,string"`
 }
 
-func (c *Client) RenewWatch(topicName string) (*WatchResponse, error) {
+// Label filter behaviors of a watch: push for changes to the listed labels only, or for
+// changes to everything but them.
+const (
+	LabelFilterInclude = "include"
+	LabelFilterExclude = "exclude"
+)
+
+// WatchRequest configures RenewWatch.
+type WatchRequest struct {
+	TopicName string
+	// LabelIDs are label IDs, not names; see ResolveLabelIDs.
+	LabelIDs []string
+	// LabelFilterBehavior is LabelFilterInclude (default) or LabelFilterExclude.
+	LabelFilterBehavior string
+}
+
+// RenewWatch (re)starts push notifications. An include watch without labels watches INBOX;
+// an exclude watch without labels watches the whole mailbox.
+func (c *Client) RenewWatch(r WatchRequest) (*WatchResponse, error) {
+	behavior := r.LabelFilterBehavior
+	if behavior == "" {
+		behavior = LabelFilterInclude
+	}
+	if behavior != LabelFilterInclude && behavior != LabelFilterExclude {
+		return nil, fmt.Errorf("invalid label filter behavior %q", r.LabelFilterBehavior)
+	}
+
+	labelIDs := r.LabelIDs
+	if len(labelIDs) == 0 && behavior == LabelFilterInclude {
+		labelIDs = []string{"INBOX"}
+	}
 	req := &gmail.WatchRequest{
-		TopicName: topicName,
-		LabelIds:  []string{"INBOX"}, // Listening to INBOX by default, can be parameterized if needed
+		TopicName:           r.TopicName,
+		LabelIds:            labelIDs,
+		LabelFilterBehavior: behavior,
 	}
 
 	resp, err := c.service.Users.Watch("me", req).Do()
@@ -45,3 +87,51 @@ func (c *Client) RenewWatch(topicName string) (*WatchResponse, error) {
 		Expiration: resp.Expiration,
 	}, nil
 }
+
+// systemLabels are the built-in label IDs, which double as their names.
+var systemLabels = map[string]bool{
+	"INBOX": true, "SENT": true, "DRAFT": true, "SPAM": true, "TRASH": true,
+	"UNREAD": true, "STARRED": true, "IMPORTANT": true, "CHAT": true,
+}
+
+func isSystemLabel(label string) bool {
+	return systemLabels[label] || strings.HasPrefix(label, "CATEGORY_")
+}
+
+// ResolveLabelIDs maps label names (or IDs) to label IDs. System labels such as INBOX are
+// passed through; anything else is looked up, so the label list is only fetched when a
+// user label is involved. User label names match case-insensitively.
+func (c *Client) ResolveLabelIDs(labels []string) ([]string, error) {
+	ids := make([]string, 0, len(labels))
+	var byName map[string]string
+	var known map[string]bool
+	for _, label := range labels {
+		if upper := strings.ToUpper(label); isSystemLabel(upper) {
+			ids = append(ids, upper)
+			continue
+		}
+
+		if byName == nil {
+			names, err := c.LabelNames()
+			if err != nil {
+				return nil, err
+			}
+			byName = make(map[string]string, len(names))
+			known = make(map[string]bool, len(names))
+			for id, name := range names {
+				byName[strings.ToLower(name)] = id
+				known[id] = true
+			}
+		}
+
+		switch {
+		case known[label]:
+			ids = append(ids, label)
+		case byName[strings.ToLower(label)] != "":
+			ids = append(ids, byName[strings.ToLower(label)])
+		default:
+			return nil, fmt.Errorf("unknown label %q", label)
+		}
+	}
+	return ids, nil
+}
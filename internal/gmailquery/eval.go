@@ -0,0 +1,125 @@
+package gmailquery
+
+import (
+	"strings"
+	"time"
+)
+
+// Match reports whether the message matches the query, evaluating relative
+// dates (newer_than:, older_than:) against the current time.
+func (q *Query) Match(m *Message) bool {
+	return q.MatchAt(m, time.Now())
+}
+
+// MatchAt is like Match but evaluates relative dates against now.
+func (q *Query) MatchAt(m *Message, now time.Time) bool {
+	return q.root.eval(m, now)
+}
+
+type node interface {
+	eval(m *Message, now time.Time) bool
+}
+
+type andNode []node
+
+func (n andNode) eval(m *Message, now time.Time) bool {
+	for _, c := range n {
+		if !c.eval(m, now) {
+			return false
+		}
+	}
+	return true
+}
+
+type orNode []node
+
+func (n orNode) eval(m *Message, now time.Time) bool {
+	for _, c := range n {
+		if c.eval(m, now) {
+			return true
+		}
+	}
+	return false
+}
+
+type notNode struct {
+	child node
+}
+
+func (n notNode) eval(m *Message, now time.Time) bool {
+	return !n.child.eval(m, now)
+}
+
+// termNode is a single search term. field is empty for free text.
+type termNode struct {
+	field    string
+	value    string // lower-cased, except for is:/in: which hold label IDs
+	relative relative
+	at       time.Time
+}
+
+func (t *termNode) eval(m *Message, now time.Time) bool {
+	switch t.field {
+	case "":
+		return containsFold(m.From, t.value) || containsFold(m.To, t.value) ||
+			containsFold(m.Cc, t.value) || containsFold(m.Subject, t.value) ||
+			containsFold(m.Text, t.value) || anyContainsFold(m.Filenames, t.value)
+	case "from":
+		return containsFold(m.From, t.value)
+	case "to":
+		return containsFold(m.To, t.value) || containsFold(m.Cc, t.value) || containsFold(m.Bcc, t.value)
+	case "cc":
+		return containsFold(m.Cc, t.value)
+	case "bcc":
+		return containsFold(m.Bcc, t.value)
+	case "subject":
+		return containsFold(m.Subject, t.value)
+	case "list":
+		return containsFold(m.ListID, t.value)
+	case "rfc822msgid":
+		return strings.EqualFold(strings.Trim(m.RFC822MessageID, "<>"), strings.Trim(t.value, "<>"))
+	case "label":
+		for _, l := range m.Labels {
+			if normalizeLabel(l) == t.value {
+				return true
+			}
+		}
+		return false
+	case "is", "in":
+		if t.value == "" {
+			return true
+		}
+		for _, l := range m.Labels {
+			if l == t.value {
+				return true
+			}
+		}
+		return false
+	case "has":
+		return m.HasAttachment
+	case "filename":
+		return anyContainsFold(m.Filenames, t.value)
+	case "newer_than":
+		return m.Date.After(t.relative.since(now))
+	case "older_than":
+		return m.Date.Before(t.relative.since(now))
+	case "after", "newer":
+		return !m.Date.Before(t.at)
+	case "before", "older":
+		return m.Date.Before(t.at)
+	}
+	return false
+}
+
+func containsFold(s, substr string) bool {
+	return strings.Contains(strings.ToLower(s), substr)
+}
+
+func anyContainsFold(values []string, substr string) bool {
+	for _, v := range values {
+		if containsFold(v, substr) {
+			return true
+		}
+	}
+	return false
+}
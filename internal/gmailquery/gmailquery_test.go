@@ -0,0 +1,162 @@
+package gmailquery_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	gmail "google.golang.org/api/gmail/v1"
+
+	"gagarin-soft/internal/gmailquery"
+)
+
+var now = time.Date(2025, 6, 15, 12, 0, 0, 0, time.UTC)
+
+func testMessage() *gmailquery.Message {
+	return &gmailquery.Message{
+		From:            "POS Orders <orders@pos.example>",
+		To:              "shop@example.com",
+		Subject:         "Your receipt #1234",
+		ListID:          "<receipts.pos.example>",
+		RFC822MessageID: "<abc@pos.example>",
+		Labels:          []string{"INBOX", "UNREAD", "Label_7", "POS/Receipts"},
+		Filenames:       []string{"receipt-1234.pdf"},
+		HasAttachment:   true,
+		Date:            now.AddDate(0, 0, -3),
+		Text:            "Thank you for your order at Main Street",
+	}
+}
+
+func TestQuery_Match(t *testing.T) {
+	cases := []struct {
+		query string
+		want  bool
+	}{
+		{"from:orders@pos.example", true},
+		{"from:someone@else.example", false},
+		{"FROM:Orders", true},
+		{"to:shop@example.com", true},
+		{"subject:receipt", true},
+		{`subject:"receipt #1234"`, true},
+		{`subject:"receipt #9999"`, false},
+		{"subject:(your receipt)", true},
+		{"subject:(your invoice)", false},
+		{"subject:{invoice receipt}", true},
+		{"label:pos-receipts", true},
+		{"label:POS/Receipts", true},
+		{"label:invoices", false},
+		{"has:attachment", true},
+		{"filename:pdf", true},
+		{"filename:receipt-1234.pdf", true},
+		{"filename:csv", false},
+		{"newer_than:7d", true},
+		{"newer_than:2d", false},
+		{"older_than:1d", true},
+		{"older_than:1m", false},
+		{"after:2025/06/01", true},
+		{"before:2025/06/01", false},
+		{"after:2025-06-13 before:2025-06-14", false},
+		{"is:unread", true},
+		{"is:read", false},
+		{"in:inbox", true},
+		{"in:anywhere", true},
+		{"list:receipts.pos.example", true},
+		{"rfc822msgid:abc@pos.example", true},
+		{`"main street"`, true},
+		{"order", true},
+		{"refund", false},
+		{"refund OR receipt", true},
+		{"refund OR invoice", false},
+		{"from:orders -subject:refund", true},
+		{"from:orders -subject:receipt", false},
+		{"-(subject:refund OR subject:invoice)", true},
+		{"{refund invoice receipt}", true},
+		{"from:orders AND (subject:receipt OR subject:invoice) has:attachment", true},
+		{"from:orders (subject:refund OR subject:invoice)", false},
+		{"https://pos.example/track", false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.query, func(t *testing.T) {
+			q, err := gmailquery.Parse(tc.query)
+			if err != nil {
+				t.Fatalf("Parse(%q): %v", tc.query, err)
+			}
+			if got := q.MatchAt(testMessage(), now); got != tc.want {
+				t.Errorf("Match(%q) = %v, want %v", tc.query, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestParse_Errors(t *testing.T) {
+	cases := []struct {
+		query string
+		pos   int
+	}{
+		{"", 1},
+		{"   ", 1},
+		{"from:", 6},
+		{"sender:bob", 1},
+		{`subject:"unterminated`, 9},
+		{"(from:a", 8},
+		{"from:a)", 7},
+		{"{from:a", 8},
+		{"from:a - to:b", 8},
+		{"a OR", 5},
+		{"OR a", 1},
+		{"newer_than:3w", 12},
+		{"after:yesterday", 7},
+		{"has:drive", 5},
+		{"subject:(from:bob)", 10},
+		{"{}", 1},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.query, func(t *testing.T) {
+			_, err := gmailquery.Parse(tc.query)
+			if err == nil {
+				t.Fatalf("Parse(%q): expected an error", tc.query)
+			}
+			var perr *gmailquery.ParseError
+			if !errors.As(err, &perr) {
+				t.Fatalf("Parse(%q): expected *ParseError, got %T", tc.query, err)
+			}
+			if perr.Pos != tc.pos {
+				t.Errorf("Parse(%q): error %q at position %d, want %d", tc.query, perr.Msg, perr.Pos, tc.pos)
+			}
+		})
+	}
+}
+
+func TestFromGmail(t *testing.T) {
+	msg := &gmail.Message{
+		Id:           "m1",
+		LabelIds:     []string{"INBOX", "Label_7"},
+		Snippet:      "Thank you",
+		InternalDate: now.UnixMilli(),
+		Payload: &gmail.MessagePart{
+			Headers: []*gmail.MessagePartHeader{
+				{Name: "From", Value: "orders@pos.example"},
+				{Name: "Subject", Value: "Receipt"},
+			},
+			Parts: []*gmail.MessagePart{
+				{MimeType: "text/plain", Body: &gmail.MessagePartBody{Data: "dGhhbmtz"}},
+				{MimeType: "application/pdf", Filename: "r.pdf", Body: &gmail.MessagePartBody{AttachmentId: "att1"}},
+			},
+		},
+	}
+
+	m := gmailquery.FromGmail(msg, map[string]string{"Label_7": "POS Receipts"})
+
+	q, err := gmailquery.Parse(`label:"pos receipts" from:orders has:attachment filename:pdf thank`)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if !q.MatchAt(m, now) {
+		t.Errorf("expected message %+v to match %q", m, q)
+	}
+	if !q.Uses("label") || q.Uses("to") {
+		t.Errorf("unexpected Uses result")
+	}
+}
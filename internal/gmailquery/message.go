@@ -0,0 +1,81 @@
+package gmailquery
+
+import (
+	"strings"
+	"time"
+
+	gmail "google.golang.org/api/gmail/v1"
+)
+
+// Message is the view of an email that queries are evaluated against.
+type Message struct {
+	From            string
+	To              string
+	Cc              string
+	Bcc             string
+	Subject         string
+	ListID          string
+	RFC822MessageID string
+	// Labels holds label IDs and, where known, label names.
+	Labels        []string
+	Filenames     []string
+	HasAttachment bool
+	Date          time.Time
+	// Text is searched by free-text terms (snippet and/or decoded body).
+	Text string
+}
+
+// FromGmail builds a Message from a Gmail API message fetched in "full" or
+// "metadata" format. labelNames maps label IDs to display names so that
+// label:Receipts matches a user label whose ID is Label_123; it may be nil.
+func FromGmail(msg *gmail.Message, labelNames map[string]string) *Message {
+	m := &Message{
+		Text: msg.Snippet,
+	}
+	if msg.InternalDate > 0 {
+		m.Date = time.UnixMilli(msg.InternalDate)
+	}
+	for _, id := range msg.LabelIds {
+		m.Labels = append(m.Labels, id)
+		if name, ok := labelNames[id]; ok && name != id {
+			m.Labels = append(m.Labels, name)
+		}
+	}
+
+	if msg.Payload == nil {
+		return m
+	}
+	for _, h := range msg.Payload.Headers {
+		switch strings.ToLower(h.Name) {
+		case "from":
+			m.From = h.Value
+		case "to":
+			m.To = h.Value
+		case "cc":
+			m.Cc = h.Value
+		case "bcc":
+			m.Bcc = h.Value
+		case "subject":
+			m.Subject = h.Value
+		case "list-id":
+			m.ListID = h.Value
+		case "message-id":
+			m.RFC822MessageID = h.Value
+		}
+	}
+
+	var walk func(p *gmail.MessagePart)
+	walk = func(p *gmail.MessagePart) {
+		if p.Filename != "" {
+			m.Filenames = append(m.Filenames, p.Filename)
+			m.HasAttachment = true
+		} else if p.Body != nil && p.Body.AttachmentId != "" {
+			m.HasAttachment = true
+		}
+		for _, child := range p.Parts {
+			walk(child)
+		}
+	}
+	walk(msg.Payload)
+	return m
+}
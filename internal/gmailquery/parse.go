@@ -0,0 +1,479 @@
+// Package gmailquery parses Gmail's search query language and evaluates queries
+// locally against fetched messages, so filters can be applied without another
+// Gmail API call.
+//
+// Supported syntax: bare words and "quoted phrases", from:, to:, cc:, bcc:,
+// subject:, label:, list:, rfc822msgid:, has:attachment, filename:, is:, in:,
+// newer_than:/older_than: (d, m, y), after:/before: (YYYY/MM/DD or Unix seconds),
+// OR, AND, negation with a leading '-', ( ) groups and { } OR-groups. Operators
+// also accept a group value, e.g. subject:(order receipt) or from:{a b}.
+package gmailquery
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// ParseError describes an invalid query. Pos is the 1-based character position
+// in the query where the problem was found.
+type ParseError struct {
+	Pos int
+	Msg string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%s at position %d", e.Msg, e.Pos)
+}
+
+// Query is a parsed Gmail search query.
+type Query struct {
+	raw  string
+	root node
+	ops  map[string]bool
+}
+
+// String returns the original query text.
+func (q *Query) String() string {
+	return q.raw
+}
+
+// Uses reports whether the query contains the given operator (e.g. "label").
+func (q *Query) Uses(op string) bool {
+	return q.ops[op]
+}
+
+// Parse parses a Gmail search query.
+func Parse(query string) (*Query, error) {
+	tokens, err := lex(query)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens, ops: make(map[string]bool)}
+	if p.peek().kind == tokEOF {
+		return nil, &ParseError{Pos: 1, Msg: "query is empty"}
+	}
+	root, err := p.parseOr("")
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, &ParseError{Pos: t.pos, Msg: fmt.Sprintf("unexpected %s", t)}
+	}
+	return &Query{raw: query, root: root, ops: p.ops}, nil
+}
+
+// --- Lexer ---
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokWord
+	tokPhrase
+	tokOperator
+	tokLParen
+	tokRParen
+	tokLBrace
+	tokRBrace
+	tokMinus
+	tokOr
+	tokAnd
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokEOF:
+		return "end of query"
+	case tokPhrase:
+		return fmt.Sprintf("phrase %q", t.text)
+	case tokOperator:
+		return fmt.Sprintf("operator %q", t.text+":")
+	default:
+		return fmt.Sprintf("%q", t.text)
+	}
+}
+
+// operators lists the supported operator names.
+var operators = map[string]bool{
+	"from": true, "to": true, "cc": true, "bcc": true, "subject": true,
+	"label": true, "list": true, "rfc822msgid": true, "has": true, "filename": true,
+	"is": true, "in": true, "newer_than": true, "older_than": true,
+	"after": true, "before": true, "newer": true, "older": true,
+}
+
+func isDelimiter(r rune) bool {
+	return unicode.IsSpace(r) || strings.ContainsRune(`(){}"`, r)
+}
+
+func isIdentifier(rs []rune) bool {
+	if len(rs) == 0 || !unicode.IsLetter(rs[0]) {
+		return false
+	}
+	for _, r := range rs {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_' {
+			return false
+		}
+	}
+	return true
+}
+
+func lex(query string) ([]token, error) {
+	runes := []rune(query)
+	var tokens []token
+	i := 0
+	for i < len(runes) {
+		r := runes[i]
+		pos := i + 1
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(':
+			tokens = append(tokens, token{tokLParen, "(", pos})
+			i++
+		case r == ')':
+			tokens = append(tokens, token{tokRParen, ")", pos})
+			i++
+		case r == '{':
+			tokens = append(tokens, token{tokLBrace, "{", pos})
+			i++
+		case r == '}':
+			tokens = append(tokens, token{tokRBrace, "}", pos})
+			i++
+		case r == '"':
+			end := i + 1
+			for end < len(runes) && runes[end] != '"' {
+				end++
+			}
+			if end >= len(runes) {
+				return nil, &ParseError{Pos: pos, Msg: "unterminated quoted phrase"}
+			}
+			tokens = append(tokens, token{tokPhrase, string(runes[i+1 : end]), pos})
+			i = end + 1
+		case r == '-':
+			if i+1 >= len(runes) || unicode.IsSpace(runes[i+1]) || runes[i+1] == ')' || runes[i+1] == '}' {
+				return nil, &ParseError{Pos: pos, Msg: "'-' must be followed by a term"}
+			}
+			tokens = append(tokens, token{tokMinus, "-", pos})
+			i++
+		default:
+			start := i
+			for i < len(runes) && !isDelimiter(runes[i]) {
+				// "name:" introduces an operator; other colons (10:30, http://) are plain text.
+				if runes[i] == ':' && isIdentifier(runes[start:i]) && (i+1 >= len(runes) || runes[i+1] != '/') {
+					break
+				}
+				i++
+			}
+			if i < len(runes) && runes[i] == ':' {
+				name := strings.ToLower(string(runes[start:i]))
+				if !operators[name] {
+					return nil, &ParseError{Pos: pos, Msg: fmt.Sprintf("unknown operator %q", name+":")}
+				}
+				tokens = append(tokens, token{tokOperator, name, pos})
+				i++
+				continue
+			}
+			word := string(runes[start:i])
+			switch word {
+			case "OR", "|":
+				tokens = append(tokens, token{tokOr, word, pos})
+			case "AND":
+				tokens = append(tokens, token{tokAnd, word, pos})
+			default:
+				tokens = append(tokens, token{tokWord, word, pos})
+			}
+		}
+	}
+	tokens = append(tokens, token{tokEOF, "", len(runes) + 1})
+	return tokens, nil
+}
+
+// --- Parser ---
+
+type parser struct {
+	tokens []token
+	i      int
+	ops    map[string]bool
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.i]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.i]
+	if t.kind != tokEOF {
+		p.i++
+	}
+	return t
+}
+
+// parseOr parses: and ( OR and )*
+func (p *parser) parseOr(field string) (node, error) {
+	first, err := p.parseAnd(field)
+	if err != nil {
+		return nil, err
+	}
+	children := []node{first}
+	for p.peek().kind == tokOr {
+		p.next()
+		n, err := p.parseAnd(field)
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, n)
+	}
+	if len(children) == 1 {
+		return first, nil
+	}
+	return orNode(children), nil
+}
+
+// parseAnd parses a run of implicitly (or explicitly) AND-ed terms.
+func (p *parser) parseAnd(field string) (node, error) {
+	var children []node
+	for {
+		t := p.peek()
+		switch t.kind {
+		case tokEOF, tokOr, tokRParen, tokRBrace:
+			if len(children) == 0 {
+				return nil, &ParseError{Pos: t.pos, Msg: fmt.Sprintf("expected a search term, found %s", t)}
+			}
+			if len(children) == 1 {
+				return children[0], nil
+			}
+			return andNode(children), nil
+		case tokAnd:
+			if len(children) == 0 {
+				return nil, &ParseError{Pos: t.pos, Msg: "AND must follow a search term"}
+			}
+			p.next()
+			if k := p.peek().kind; k == tokEOF || k == tokOr || k == tokAnd || k == tokRParen || k == tokRBrace {
+				return nil, &ParseError{Pos: p.peek().pos, Msg: fmt.Sprintf("expected a search term after AND, found %s", p.peek())}
+			}
+			continue
+		}
+		n, err := p.parseUnary(field)
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, n)
+	}
+}
+
+func (p *parser) parseUnary(field string) (node, error) {
+	if p.peek().kind == tokMinus {
+		p.next()
+		n, err := p.parseUnary(field)
+		if err != nil {
+			return nil, err
+		}
+		return notNode{n}, nil
+	}
+	return p.parsePrimary(field)
+}
+
+func (p *parser) parsePrimary(field string) (node, error) {
+	t := p.next()
+	switch t.kind {
+	case tokLParen:
+		n, err := p.parseOr(field)
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(tokRParen, t); err != nil {
+			return nil, err
+		}
+		return n, nil
+	case tokLBrace:
+		return p.parseBraceGroup(field, t)
+	case tokWord, tokPhrase:
+		return newTerm(field, t)
+	case tokOperator:
+		if field != "" {
+			return nil, &ParseError{Pos: t.pos, Msg: fmt.Sprintf("operator %q cannot be used inside a %s: group", t.text+":", field)}
+		}
+		p.ops[t.text] = true
+		v := p.peek()
+		switch v.kind {
+		case tokWord, tokPhrase:
+			p.next()
+			return newTerm(t.text, v)
+		case tokLParen, tokLBrace:
+			return p.parsePrimary(t.text)
+		default:
+			return nil, &ParseError{Pos: v.pos, Msg: fmt.Sprintf("missing value for operator %q", t.text+":")}
+		}
+	default:
+		return nil, &ParseError{Pos: t.pos, Msg: fmt.Sprintf("unexpected %s", t)}
+	}
+}
+
+// parseBraceGroup parses {a b c}, which matches when any of the terms matches.
+func (p *parser) parseBraceGroup(field string, open token) (node, error) {
+	var children []node
+	for {
+		t := p.peek()
+		if t.kind == tokRBrace {
+			p.next()
+			break
+		}
+		if t.kind == tokEOF {
+			return nil, &ParseError{Pos: t.pos, Msg: fmt.Sprintf("missing '}' for '{' at position %d", open.pos)}
+		}
+		if t.kind == tokOr || t.kind == tokAnd {
+			p.next()
+			continue
+		}
+		n, err := p.parseUnary(field)
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, n)
+	}
+	if len(children) == 0 {
+		return nil, &ParseError{Pos: open.pos, Msg: "empty '{}' group"}
+	}
+	if len(children) == 1 {
+		return children[0], nil
+	}
+	return orNode(children), nil
+}
+
+func (p *parser) expect(kind tokenKind, open token) error {
+	t := p.peek()
+	if t.kind != kind {
+		if t.kind == tokEOF {
+			return &ParseError{Pos: t.pos, Msg: fmt.Sprintf("missing ')' for '(' at position %d", open.pos)}
+		}
+		return &ParseError{Pos: t.pos, Msg: fmt.Sprintf("expected ')', found %s", t)}
+	}
+	p.next()
+	return nil
+}
+
+// newTerm builds a term node, validating operator values up front so that
+// evaluation never fails.
+func newTerm(field string, t token) (node, error) {
+	value := strings.TrimSpace(t.text)
+	if value == "" {
+		return nil, &ParseError{Pos: t.pos, Msg: "empty search term"}
+	}
+	term := &termNode{field: field, value: strings.ToLower(value)}
+
+	switch field {
+	case "has":
+		if term.value != "attachment" {
+			return nil, &ParseError{Pos: t.pos, Msg: fmt.Sprintf("unsupported value %q for has:", value)}
+		}
+	case "is":
+		if term.value == "read" {
+			return notNode{&termNode{field: field, value: isLabels["unread"]}}, nil
+		}
+		label, ok := isLabels[term.value]
+		if !ok {
+			return nil, &ParseError{Pos: t.pos, Msg: fmt.Sprintf("unsupported value %q for is:", value)}
+		}
+		term.value = label
+	case "in":
+		label, ok := inLabels[term.value]
+		if !ok {
+			return nil, &ParseError{Pos: t.pos, Msg: fmt.Sprintf("unsupported value %q for in:", value)}
+		}
+		term.value = label
+	case "newer_than", "older_than":
+		d, err := parseRelative(term.value)
+		if err != nil {
+			return nil, &ParseError{Pos: t.pos, Msg: fmt.Sprintf("invalid %s: value %q (use e.g. 2d, 3m, 1y)", field, value)}
+		}
+		term.relative = d
+	case "after", "before", "newer", "older":
+		at, err := parseDate(value)
+		if err != nil {
+			return nil, &ParseError{Pos: t.pos, Msg: fmt.Sprintf("invalid %s: date %q (use YYYY/MM/DD or Unix seconds)", field, value)}
+		}
+		term.at = at
+	case "label":
+		term.value = normalizeLabel(value)
+	}
+	return term, nil
+}
+
+// isLabels and inLabels map is:/in: values to system label IDs. An empty label matches everything.
+var isLabels = map[string]string{
+	"unread":    "UNREAD",
+	"starred":   "STARRED",
+	"important": "IMPORTANT",
+}
+
+var inLabels = map[string]string{
+	"inbox":    "INBOX",
+	"sent":     "SENT",
+	"draft":    "DRAFT",
+	"drafts":   "DRAFT",
+	"spam":     "SPAM",
+	"trash":    "TRASH",
+	"anywhere": "",
+}
+
+// relative is a newer_than/older_than amount.
+type relative struct {
+	n    int
+	unit byte // 'd', 'm' or 'y'
+}
+
+func parseRelative(v string) (relative, error) {
+	if len(v) < 2 {
+		return relative{}, fmt.Errorf("too short")
+	}
+	unit := v[len(v)-1]
+	if unit != 'd' && unit != 'm' && unit != 'y' {
+		return relative{}, fmt.Errorf("bad unit")
+	}
+	n, err := strconv.Atoi(v[:len(v)-1])
+	if err != nil || n < 0 {
+		return relative{}, fmt.Errorf("bad amount")
+	}
+	return relative{n: n, unit: unit}, nil
+}
+
+// since returns the cut-off time for the relative amount measured back from now.
+func (r relative) since(now time.Time) time.Time {
+	switch r.unit {
+	case 'y':
+		return now.AddDate(-r.n, 0, 0)
+	case 'm':
+		return now.AddDate(0, -r.n, 0)
+	default:
+		return now.AddDate(0, 0, -r.n)
+	}
+}
+
+// parseDate accepts YYYY/MM/DD, YYYY-MM-DD (interpreted in UTC) or Unix seconds.
+func parseDate(v string) (time.Time, error) {
+	if secs, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return time.Unix(secs, 0), nil
+	}
+	for _, layout := range []string{"2006/1/2", "2006-1-2"} {
+		if t, err := time.Parse(layout, v); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognized date")
+}
+
+// normalizeLabel makes label names comparable the way Gmail does: case-insensitive,
+// with spaces, '/' and '-' treated as equivalent.
+func normalizeLabel(v string) string {
+	v = strings.ToLower(strings.TrimSpace(v))
+	return strings.NewReplacer(" ", "-", "/", "-").Replace(v)
+}
@@ -0,0 +1,366 @@
+package gmailtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	gmailapi "google.golang.org/api/gmail/v1"
+
+	"gagarin-soft/internal/gmailquery"
+)
+
+// defaultPageSize is Gmail's default maxResults of history.list and messages.list.
+const defaultPageSize = 100
+
+// apiError is answered in the Google API error format, so the client library turns it into
+// a *googleapi.Error with the same code.
+type apiError struct {
+	Code    int
+	Status  string
+	Reason  string
+	Message string
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("%d %s: %s", e.Code, e.Status, e.Message)
+}
+
+func errNotFound(msg string) error {
+	return &apiError{Code: http.StatusNotFound, Status: "NOT_FOUND", Reason: "notFound", Message: msg}
+}
+
+func errInvalid(msg string) error {
+	return &apiError{Code: http.StatusBadRequest, Status: "INVALID_ARGUMENT", Reason: "invalidArgument", Message: msg}
+}
+
+func errConflict(msg string) error {
+	return &apiError{Code: http.StatusConflict, Status: "ALREADY_EXISTS", Reason: "duplicate", Message: msg}
+}
+
+// ServeHTTP serves the Gmail API under /gmail/v1/users/{userId}/, where userId is "me" or
+// the mailbox address, and the control endpoints of Control under /fake/.
+func (m *Mailbox) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.muxOnce.Do(func() { m.mux = m.handler() })
+	m.mux.ServeHTTP(w, r)
+}
+
+func (m *Mailbox) handler() http.Handler {
+	mux := http.NewServeMux()
+	api := func(pattern string, fn func(r *http.Request) (any, error)) {
+		method, path, _ := strings.Cut(pattern, " ")
+		mux.HandleFunc(method+" /gmail/v1/users/{userId}"+path, func(w http.ResponseWriter, r *http.Request) {
+			m.mu.Lock()
+			m.requests = append(m.requests, r.Method+" "+r.URL.Path)
+			var resp any
+			var err error
+			if user := r.PathValue("userId"); user != "me" && !strings.EqualFold(user, m.email) {
+				err = &apiError{Code: http.StatusForbidden, Status: "PERMISSION_DENIED", Reason: "forbidden", Message: "Delegation denied for " + user}
+			} else {
+				resp, err = fn(r)
+			}
+			// Encode under the lock: responses point into the mailbox state.
+			body, merr := json.Marshal(resp)
+			m.mu.Unlock()
+			if err == nil && merr != nil {
+				err = merr
+			}
+			writeJSON(w, body, err)
+		})
+	}
+
+	api("GET /profile", m.getProfile)
+	api("POST /watch", m.watch)
+	api("POST /stop", func(*http.Request) (any, error) { return struct{}{}, nil })
+	api("GET /history", m.listHistory)
+	api("GET /messages", m.listMessages)
+	api("GET /messages/{id}", m.getMessage)
+	api("POST /messages/{id}/modify", m.modifyMessage)
+	api("GET /messages/{id}/attachments/{attachmentId}", m.getAttachment)
+	api("GET /labels", m.listLabels)
+	api("POST /labels", m.createLabelRequest)
+	api("GET /labels/{id}", m.getLabel)
+
+	mux.Handle("/fake/", m.Control())
+	return mux
+}
+
+func writeJSON(w http.ResponseWriter, body []byte, err error) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	if err == nil {
+		w.Write(body)
+		return
+	}
+	e, ok := err.(*apiError)
+	if !ok {
+		e = &apiError{Code: http.StatusInternalServerError, Status: "INTERNAL", Reason: "backendError", Message: err.Error()}
+	}
+	w.WriteHeader(e.Code)
+	json.NewEncoder(w).Encode(map[string]any{
+		"error": map[string]any{
+			"code":    e.Code,
+			"message": e.Message,
+			"status":  e.Status,
+			"errors":  []map[string]string{{"message": e.Message, "domain": "global", "reason": e.Reason}},
+		},
+	})
+}
+
+func (m *Mailbox) getProfile(*http.Request) (any, error) {
+	return &gmailapi.Profile{
+		EmailAddress:  m.email,
+		HistoryId:     m.historyID,
+		MessagesTotal: int64(len(m.messages)),
+		ThreadsTotal:  int64(len(m.messages)),
+	}, nil
+}
+
+func (m *Mailbox) watch(r *http.Request) (any, error) {
+	var req gmailapi.WatchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, errInvalid("Invalid JSON payload")
+	}
+	if req.TopicName == "" {
+		return nil, errInvalid("Invalid topicName")
+	}
+	for _, l := range req.LabelIds {
+		if _, ok := m.labels[l]; !ok {
+			return nil, errInvalid("Invalid label: " + l)
+		}
+	}
+	m.watches = append(m.watches, req)
+	return &gmailapi.WatchResponse{
+		HistoryId:  m.historyID,
+		Expiration: time.Now().Add(7 * 24 * time.Hour).UnixMilli(),
+	}, nil
+}
+
+// pageSize returns the page size for a list call: maxResults, capped by PageSize.
+func (m *Mailbox) pageSize(r *http.Request) int {
+	size := defaultPageSize
+	if n, err := strconv.Atoi(r.URL.Query().Get("maxResults")); err == nil && n > 0 && n <= 500 {
+		size = n
+	}
+	if m.PageSize > 0 && m.PageSize < size {
+		size = m.PageSize
+	}
+	return size
+}
+
+// page cuts items at the offset carried by pageToken and returns the next token.
+func page[T any](r *http.Request, items []T, size int) ([]T, string, error) {
+	offset := 0
+	if token := r.URL.Query().Get("pageToken"); token != "" {
+		n, err := strconv.Atoi(token)
+		if err != nil || n < 0 || n > len(items) {
+			return nil, "", errInvalid("Invalid pageToken")
+		}
+		offset = n
+	}
+	end := min(offset+size, len(items))
+	next := ""
+	if end < len(items) {
+		next = strconv.Itoa(end)
+	}
+	return items[offset:end], next, nil
+}
+
+func (m *Mailbox) listHistory(r *http.Request) (any, error) {
+	q := r.URL.Query()
+	start, err := strconv.ParseUint(q.Get("startHistoryId"), 10, 64)
+	if err != nil || start == 0 {
+		return nil, errInvalid("Invalid startHistoryId")
+	}
+	if start < m.oldestKept {
+		return nil, errNotFound("Requested entity was not found.")
+	}
+	types := q["historyTypes"]
+	labelID := q.Get("labelId")
+
+	var records []*gmailapi.History
+	for _, h := range m.history {
+		if h.Id <= start {
+			continue
+		}
+		if h = filterHistory(h, types, labelID); h != nil {
+			records = append(records, h)
+		}
+	}
+	records, next, err := page(r, records, m.pageSize(r))
+	if err != nil {
+		return nil, err
+	}
+	return &gmailapi.ListHistoryResponse{History: records, NextPageToken: next, HistoryId: m.historyID}, nil
+}
+
+// filterHistory keeps the parts of a record of the requested types and label, or returns
+// nil when nothing is left.
+func filterHistory(h *gmailapi.History, types []string, labelID string) *gmailapi.History {
+	want := func(t string) bool { return len(types) == 0 || slices.Contains(types, t) }
+	hasLabel := func(msg *gmailapi.Message, changed []string) bool {
+		return labelID == "" || slices.Contains(msg.LabelIds, labelID) || slices.Contains(changed, labelID)
+	}
+
+	out := &gmailapi.History{Id: h.Id, Messages: h.Messages}
+	if want("messageAdded") {
+		for _, a := range h.MessagesAdded {
+			if hasLabel(a.Message, nil) {
+				out.MessagesAdded = append(out.MessagesAdded, a)
+			}
+		}
+	}
+	if want("labelAdded") {
+		for _, a := range h.LabelsAdded {
+			if hasLabel(a.Message, a.LabelIds) {
+				out.LabelsAdded = append(out.LabelsAdded, a)
+			}
+		}
+	}
+	if want("labelRemoved") {
+		for _, a := range h.LabelsRemoved {
+			if hasLabel(a.Message, a.LabelIds) {
+				out.LabelsRemoved = append(out.LabelsRemoved, a)
+			}
+		}
+	}
+	if len(out.MessagesAdded) == 0 && len(out.LabelsAdded) == 0 && len(out.LabelsRemoved) == 0 {
+		return nil
+	}
+	return out
+}
+
+// listMessages supports q (evaluated with gmailquery), labelIds and includeSpamTrash. Messages
+// are returned newest first.
+func (m *Mailbox) listMessages(r *http.Request) (any, error) {
+	q := r.URL.Query()
+	var query *gmailquery.Query
+	if s := q.Get("q"); s != "" {
+		var err error
+		if query, err = gmailquery.Parse(s); err != nil {
+			return nil, errInvalid("Invalid query: " + err.Error())
+		}
+	}
+	labelIDs := q["labelIds"]
+	includeSpamTrash := q.Get("includeSpamTrash") == "true"
+	names := m.labelNames()
+
+	var matched []*gmailapi.Message
+	for _, id := range m.order {
+		msg := m.messages[id]
+		if !includeSpamTrash && (slices.Contains(msg.LabelIds, "SPAM") || slices.Contains(msg.LabelIds, "TRASH")) {
+			continue
+		}
+		if !containsAll(msg.LabelIds, labelIDs) {
+			continue
+		}
+		if query != nil && !query.Match(gmailquery.FromGmail(msg, names)) {
+			continue
+		}
+		matched = append(matched, &gmailapi.Message{Id: msg.Id, ThreadId: msg.ThreadId})
+	}
+	slices.Reverse(matched)
+	slices.SortStableFunc(matched, func(a, b *gmailapi.Message) int {
+		return compareDesc(m.messages[a.Id].InternalDate, m.messages[b.Id].InternalDate)
+	})
+
+	total := len(matched)
+	matched, next, err := page(r, matched, m.pageSize(r))
+	if err != nil {
+		return nil, err
+	}
+	return &gmailapi.ListMessagesResponse{Messages: matched, NextPageToken: next, ResultSizeEstimate: int64(total)}, nil
+}
+
+func compareDesc(a, b int64) int {
+	switch {
+	case a > b:
+		return -1
+	case a < b:
+		return 1
+	}
+	return 0
+}
+
+func containsAll(have, want []string) bool {
+	for _, w := range want {
+		if !slices.Contains(have, w) {
+			return false
+		}
+	}
+	return true
+}
+
+func (m *Mailbox) labelNames() map[string]string {
+	names := make(map[string]string, len(m.labels))
+	for id, l := range m.labels {
+		names[id] = l.Name
+	}
+	return names
+}
+
+// getMessage supports the minimal, metadata and full formats.
+func (m *Mailbox) getMessage(r *http.Request) (any, error) {
+	msg, ok := m.messages[r.PathValue("id")]
+	if !ok {
+		return nil, errNotFound("Requested entity was not found.")
+	}
+	switch format := r.URL.Query().Get("format"); format {
+	case "", "full":
+		return msg, nil
+	case "minimal":
+		c := clone(msg)
+		c.Payload = nil
+		return c, nil
+	case "metadata":
+		c := clone(msg)
+		c.Payload = &gmailapi.MessagePart{MimeType: msg.Payload.MimeType, Headers: msg.Payload.Headers}
+		return c, nil
+	default:
+		return nil, errInvalid("Unsupported format: " + format)
+	}
+}
+
+func (m *Mailbox) modifyMessage(r *http.Request) (any, error) {
+	var req gmailapi.ModifyMessageRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, errInvalid("Invalid JSON payload")
+	}
+	return m.modifyLabels(r.PathValue("id"), req.AddLabelIds, req.RemoveLabelIds)
+}
+
+func (m *Mailbox) getAttachment(r *http.Request) (any, error) {
+	data, ok := m.attachments[r.PathValue("id")+"/"+r.PathValue("attachmentId")]
+	if !ok {
+		return nil, errNotFound("Requested entity was not found.")
+	}
+	return &gmailapi.MessagePartBody{AttachmentId: r.PathValue("attachmentId"), Size: int64(len(data)), Data: encode(data)}, nil
+}
+
+func (m *Mailbox) listLabels(*http.Request) (any, error) {
+	labels := make([]*gmailapi.Label, 0, len(m.labels))
+	for _, l := range m.labels {
+		labels = append(labels, l)
+	}
+	slices.SortFunc(labels, func(a, b *gmailapi.Label) int { return strings.Compare(a.Id, b.Id) })
+	return &gmailapi.ListLabelsResponse{Labels: labels}, nil
+}
+
+func (m *Mailbox) createLabelRequest(r *http.Request) (any, error) {
+	var l gmailapi.Label
+	if err := json.NewDecoder(r.Body).Decode(&l); err != nil {
+		return nil, errInvalid("Invalid JSON payload")
+	}
+	return m.createLabel(&l)
+}
+
+func (m *Mailbox) getLabel(r *http.Request) (any, error) {
+	l, ok := m.labels[r.PathValue("id")]
+	if !ok {
+		return nil, errNotFound("Requested entity was not found.")
+	}
+	return l, nil
+}
@@ -0,0 +1,170 @@
+package gmailtest_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"google.golang.org/api/option"
+
+	"gagarin-soft/internal/gmail"
+	"gagarin-soft/internal/gmailtest"
+)
+
+func newClient(t *testing.T, srv *gmailtest.Server) *gmail.Client {
+	t.Helper()
+	client, err := gmail.NewClient(context.Background(), srv.Client())
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	return client
+}
+
+func TestServer_HistoryFollowsChanges(t *testing.T) {
+	srv := gmailtest.NewServer("shop@example.com")
+	defer srv.Close()
+	srv.PageSize = 1
+	client := newClient(t, srv)
+
+	start := srv.HistoryID()
+	m1 := srv.AddMessage(gmailtest.Message{Subject: "first"})
+	m2 := srv.AddMessage(gmailtest.Message{Subject: "second"})
+	if _, err := srv.ModifyLabels(m1.Id, []string{"STARRED"}, []string{"UNREAD"}); err != nil {
+		t.Fatalf("ModifyLabels: %v", err)
+	}
+
+	got, err := client.ListMessageIDs(start)
+	if err != nil {
+		t.Fatalf("ListMessageIDs: %v", err)
+	}
+	want := []gmail.HistoryMessage{
+		{ID: m1.Id, Types: []gmail.HistoryType{gmail.HistoryMessageAdded, gmail.HistoryLabelAdded, gmail.HistoryLabelRemoved}, LabelIDs: []string{"STARRED", "UNREAD"}},
+		{ID: m2.Id, Types: []gmail.HistoryType{gmail.HistoryMessageAdded}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected history:\n got %+v\nwant %+v", got, want)
+	}
+
+	historyCalls := 0
+	for _, r := range srv.Requests() {
+		if r == "GET /gmail/v1/users/me/history" {
+			historyCalls++
+		}
+	}
+	if historyCalls != 3 {
+		t.Errorf("Expected three history pages, got %d", historyCalls)
+	}
+
+	// Nothing changed since the latest ID.
+	if got, err := client.ListMessageIDs(srv.HistoryID()); err != nil || len(got) != 0 {
+		t.Errorf("Expected no changes, got %+v, %v", got, err)
+	}
+}
+
+func TestServer_ExpiredHistoryIsTooOld(t *testing.T) {
+	srv := gmailtest.NewServer("shop@example.com")
+	defer srv.Close()
+	client := newClient(t, srv)
+
+	start := srv.HistoryID()
+	srv.AddMessage(gmailtest.Message{})
+	srv.ExpireHistory()
+
+	if _, err := client.ListMessageIDs(start); !errors.Is(err, gmail.ErrHistoryTooOld) {
+		t.Errorf("Expected ErrHistoryTooOld, got %v", err)
+	}
+	if _, err := client.ListMessageIDs(srv.HistoryID()); err != nil {
+		t.Errorf("Expected the current history ID to be accepted, got %v", err)
+	}
+}
+
+func TestServer_MessagesAndAttachments(t *testing.T) {
+	srv := gmailtest.NewServer("shop@example.com")
+	defer srv.Close()
+	// Through the endpoint option rather than the redirecting client.
+	client, err := gmail.NewClient(context.Background(), nil, option.WithEndpoint(srv.Endpoint()), option.WithoutAuthentication())
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+
+	old := srv.AddMessage(gmailtest.Message{From: "news@example.com", Subject: "Old news", Date: time.Now().Add(-48 * time.Hour)})
+	receipt := srv.AddMessage(gmailtest.Message{
+		From:    "Shop <receipts@shop.example>",
+		Subject: "Your receipt",
+		Body:    "Thanks for your order",
+		Attachments: []gmailtest.Attachment{
+			{Filename: "receipt.pdf", MimeType: "application/pdf", Data: []byte("%PDF-1.4")},
+		},
+	})
+
+	ids, err := client.ListMessages("from:receipts@shop.example", []string{"INBOX"}, time.Time{}, 0)
+	if err != nil || !reflect.DeepEqual(ids, []string{receipt.Id}) {
+		t.Errorf("Expected only the receipt to match, got %v, %v", ids, err)
+	}
+	ids, err = client.ListMessages("", nil, time.Now().Add(-24*time.Hour), 0)
+	if err != nil || !reflect.DeepEqual(ids, []string{receipt.Id}) {
+		t.Errorf("Expected only the recent message, got %v, %v", ids, err)
+	}
+	if ids, _ := client.ListMessages("", nil, time.Time{}, 0); !reflect.DeepEqual(ids, []string{receipt.Id, old.Id}) {
+		t.Errorf("Expected newest first, got %v", ids)
+	}
+
+	msg, err := client.GetDecodedMessage(receipt.Id)
+	if err != nil {
+		t.Fatalf("GetDecodedMessage: %v", err)
+	}
+	if msg.Headers.Subject != "Your receipt" || msg.TextBody != "Thanks for your order" || len(msg.Attachments) != 1 {
+		t.Fatalf("Unexpected decoded message %+v", msg)
+	}
+	data, err := client.AttachmentContent(receipt.Id, msg.Attachments[0])
+	if err != nil || string(data) != "%PDF-1.4" {
+		t.Errorf("Expected the attachment content, got %q, %v", data, err)
+	}
+
+	if _, err := client.GetMessage("missing"); !gmail.IsNotFound(err) {
+		t.Errorf("Expected 404 for a missing message, got %v", err)
+	}
+}
+
+func TestServer_Labels(t *testing.T) {
+	srv := gmailtest.NewServer("shop@example.com")
+	defer srv.Close()
+	client := newClient(t, srv)
+
+	existing, err := srv.CreateLabel("POS/Error")
+	if err != nil {
+		t.Fatalf("CreateLabel: %v", err)
+	}
+	if _, err := srv.CreateLabel("pos/error"); err == nil {
+		t.Error("Expected a conflict for a duplicate label name")
+	}
+
+	ids, err := client.EnsureLabels([]string{"pos/error", "pos/processed"})
+	if err != nil {
+		t.Fatalf("EnsureLabels: %v", err)
+	}
+	if ids["pos/error"] != existing || ids["pos/processed"] == "" {
+		t.Errorf("Expected the existing label to be reused and one created, got %v", ids)
+	}
+
+	m := srv.AddMessage(gmailtest.Message{})
+	if err := client.ModifyLabels(m.Id, []string{ids["pos/processed"]}, []string{"UNREAD"}); err != nil {
+		t.Fatalf("ModifyLabels: %v", err)
+	}
+	if got := srv.Message(m.Id).LabelIds; !reflect.DeepEqual(got, []string{"INBOX", ids["pos/processed"]}) {
+		t.Errorf("Unexpected labels after modify: %v", got)
+	}
+	if err := client.ModifyLabels(m.Id, []string{"Label_missing"}, nil); err == nil {
+		t.Error("Expected an error for an unknown label")
+	}
+
+	resp, err := client.RenewWatch(gmail.WatchRequest{TopicName: "projects/p/topics/t"})
+	if err != nil {
+		t.Fatalf("RenewWatch: %v", err)
+	}
+	if resp.HistoryId != srv.HistoryID() || len(srv.Watches()) != 1 || srv.Watches()[0].LabelIds[0] != "INBOX" {
+		t.Errorf("Unexpected watch %+v, requests %+v", resp, srv.Watches())
+	}
+}
@@ -0,0 +1,331 @@
+// Package gmailtest is an in-process fake of the parts of the Gmail API the worker uses:
+// users.watch, users.getProfile, users.history.list, users.messages get/list/modify, labels
+// and attachments. A Mailbox holds the scripted state; adding messages and changing labels
+// advances its history like Gmail does, so pushes can be followed by incremental syncs.
+//
+// Tests use NewServer and hand Server.Client to the code under test; cmd/fakegmail serves
+// the same API for local development.
+package gmailtest
+
+import (
+	"encoding/base64"
+	"fmt"
+	"mime"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	gmailapi "google.golang.org/api/gmail/v1"
+)
+
+// StartHistoryID is the history ID of a new mailbox.
+const StartHistoryID uint64 = 1000
+
+// systemLabels exist in every mailbox; their IDs double as their names.
+var systemLabels = []string{
+	"INBOX", "SENT", "DRAFT", "SPAM", "TRASH", "UNREAD", "STARRED", "IMPORTANT", "CHAT",
+	"CATEGORY_PERSONAL", "CATEGORY_SOCIAL", "CATEGORY_PROMOTIONS", "CATEGORY_UPDATES", "CATEGORY_FORUMS",
+}
+
+// Message describes a message to add to the mailbox.
+type Message struct {
+	ID          string       `json:"id"`       // generated when empty
+	ThreadID    string       `json:"threadId"` // defaults to the message ID
+	LabelIDs    []string     `json:"labelIds"` // INBOX and UNREAD when nil; not checked against the labels
+	From        string       `json:"from"`
+	To          string       `json:"to"`
+	Subject     string       `json:"subject"`
+	Date        time.Time    `json:"date"` // now when zero
+	Body        string       `json:"body"` // text/plain
+	Attachments []Attachment `json:"attachments"`
+}
+
+// Attachment is a file attached to a Message.
+type Attachment struct {
+	Filename string `json:"filename"`
+	MimeType string `json:"mimeType"`
+	Data     []byte `json:"data"`
+}
+
+// Mailbox is the state of one fake Gmail account. It is safe for concurrent use.
+type Mailbox struct {
+	// PageSize caps the page size of history.list and messages.list, so tests can exercise
+	// pagination with a few records. Zero uses Gmail's default of 100.
+	PageSize int
+
+	mu          sync.Mutex
+	email       string
+	historyID   uint64
+	oldestKept  uint64 // history before this ID has expired
+	history     []*gmailapi.History
+	messages    map[string]*gmailapi.Message
+	order       []string // message IDs in the order they were added
+	attachments map[string][]byte
+	labels      map[string]*gmailapi.Label
+	nextID      int
+	watches     []gmailapi.WatchRequest
+	requests    []string
+
+	muxOnce sync.Once
+	mux     http.Handler
+}
+
+// NewMailbox returns an empty mailbox for the address with only the system labels.
+func NewMailbox(email string) *Mailbox {
+	m := &Mailbox{
+		email:       email,
+		historyID:   StartHistoryID,
+		messages:    make(map[string]*gmailapi.Message),
+		attachments: make(map[string][]byte),
+		labels:      make(map[string]*gmailapi.Label),
+	}
+	for _, id := range systemLabels {
+		m.labels[id] = &gmailapi.Label{Id: id, Name: id, Type: "system"}
+	}
+	return m
+}
+
+// EmailAddress returns the address of the mailbox.
+func (m *Mailbox) EmailAddress() string {
+	return m.email
+}
+
+// HistoryID returns the current history ID.
+func (m *Mailbox) HistoryID() uint64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.historyID
+}
+
+// AddMessage delivers a message and records a messageAdded history record. It returns the
+// message as the API returns it.
+func (m *Mailbox) AddMessage(msg Message) *gmailapi.Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.nextID++
+	id := msg.ID
+	if id == "" {
+		id = fmt.Sprintf("msg%05d", m.nextID)
+	}
+	threadID := msg.ThreadID
+	if threadID == "" {
+		threadID = id
+	}
+	labelIDs := msg.LabelIDs
+	if labelIDs == nil {
+		labelIDs = []string{"INBOX", "UNREAD"}
+	}
+	date := msg.Date
+	if date.IsZero() {
+		date = time.Now()
+	}
+
+	var headers []*gmailapi.MessagePartHeader
+	for _, h := range [][2]string{
+		{"From", msg.From},
+		{"To", msg.To},
+		{"Subject", msg.Subject},
+		{"Date", date.Format(time.RFC1123Z)},
+		{"Message-ID", fmt.Sprintf("<%s@gmailtest>", id)},
+	} {
+		if h[1] != "" {
+			headers = append(headers, &gmailapi.MessagePartHeader{Name: h[0], Value: h[1]})
+		}
+	}
+	text := &gmailapi.MessagePart{
+		MimeType: "text/plain",
+		Headers:  []*gmailapi.MessagePartHeader{{Name: "Content-Type", Value: "text/plain; charset=UTF-8"}},
+		Body:     &gmailapi.MessagePartBody{Data: encode([]byte(msg.Body)), Size: int64(len(msg.Body))},
+	}
+	size := int64(len(msg.Body))
+
+	payload := text
+	if len(msg.Attachments) == 0 {
+		text.Headers = append(headers, text.Headers...)
+	} else {
+		text.PartId = "0"
+		payload = &gmailapi.MessagePart{
+			MimeType: "multipart/mixed",
+			Headers:  append(headers, &gmailapi.MessagePartHeader{Name: "Content-Type", Value: "multipart/mixed"}),
+			Body:     &gmailapi.MessagePartBody{},
+			Parts:    []*gmailapi.MessagePart{text},
+		}
+		for i, a := range msg.Attachments {
+			attachmentID := fmt.Sprintf("att-%s-%d", id, i+1)
+			m.attachments[id+"/"+attachmentID] = a.Data
+			disposition := mime.FormatMediaType("attachment", map[string]string{"filename": a.Filename})
+			payload.Parts = append(payload.Parts, &gmailapi.MessagePart{
+				PartId:   fmt.Sprint(i + 1),
+				MimeType: a.MimeType,
+				Filename: a.Filename,
+				Headers: []*gmailapi.MessagePartHeader{
+					{Name: "Content-Type", Value: a.MimeType},
+					{Name: "Content-Disposition", Value: disposition},
+				},
+				Body: &gmailapi.MessagePartBody{AttachmentId: attachmentID, Size: int64(len(a.Data))},
+			})
+			size += int64(len(a.Data))
+		}
+	}
+
+	m.historyID++
+	stored := &gmailapi.Message{
+		Id:           id,
+		ThreadId:     threadID,
+		LabelIds:     slices.Clone(labelIDs),
+		Snippet:      snippet(msg.Body),
+		HistoryId:    m.historyID,
+		InternalDate: date.UnixMilli(),
+		SizeEstimate: size,
+		Payload:      payload,
+	}
+	if _, ok := m.messages[id]; !ok {
+		m.order = append(m.order, id)
+	}
+	m.messages[id] = stored
+	m.history = append(m.history, &gmailapi.History{
+		Id:            m.historyID,
+		Messages:      []*gmailapi.Message{ref(stored)},
+		MessagesAdded: []*gmailapi.HistoryMessageAdded{{Message: ref(stored)}},
+	})
+	return clone(stored)
+}
+
+// ModifyLabels adds and removes labels of a message like messages.modify, recording the
+// changes in the history. Labels the message already has (or lacks) are not recorded.
+func (m *Mailbox) ModifyLabels(id string, add, remove []string) (*gmailapi.Message, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.modifyLabels(id, add, remove)
+}
+
+func (m *Mailbox) modifyLabels(id string, add, remove []string) (*gmailapi.Message, error) {
+	msg, ok := m.messages[id]
+	if !ok {
+		return nil, errNotFound("Requested entity was not found.")
+	}
+	for _, l := range append(slices.Clone(add), remove...) {
+		if _, ok := m.labels[l]; !ok {
+			return nil, errInvalid("Invalid label: " + l)
+		}
+	}
+
+	var added, removed []string
+	for _, l := range add {
+		if !slices.Contains(msg.LabelIds, l) && !slices.Contains(added, l) {
+			added = append(added, l)
+		}
+	}
+	for _, l := range remove {
+		if slices.Contains(msg.LabelIds, l) && !slices.Contains(added, l) && !slices.Contains(removed, l) {
+			removed = append(removed, l)
+		}
+	}
+	if len(added) == 0 && len(removed) == 0 {
+		return clone(msg), nil
+	}
+
+	msg.LabelIds = append(slices.DeleteFunc(msg.LabelIds, func(l string) bool { return slices.Contains(removed, l) }), added...)
+	m.historyID++
+	msg.HistoryId = m.historyID
+	h := &gmailapi.History{Id: m.historyID, Messages: []*gmailapi.Message{ref(msg)}}
+	if len(added) > 0 {
+		h.LabelsAdded = []*gmailapi.HistoryLabelAdded{{Message: ref(msg), LabelIds: added}}
+	}
+	if len(removed) > 0 {
+		h.LabelsRemoved = []*gmailapi.HistoryLabelRemoved{{Message: ref(msg), LabelIds: removed}}
+	}
+	m.history = append(m.history, h)
+	return clone(msg), nil
+}
+
+// CreateLabel adds a user label and returns its ID. Names are unique case-insensitively.
+func (m *Mailbox) CreateLabel(name string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	l, err := m.createLabel(&gmailapi.Label{Name: name})
+	if err != nil {
+		return "", err
+	}
+	return l.Id, nil
+}
+
+func (m *Mailbox) createLabel(l *gmailapi.Label) (*gmailapi.Label, error) {
+	if strings.TrimSpace(l.Name) == "" {
+		return nil, errInvalid("Invalid label name")
+	}
+	for _, existing := range m.labels {
+		if strings.EqualFold(existing.Name, l.Name) {
+			return nil, errConflict("Label name exists or conflicts")
+		}
+	}
+	m.nextID++
+	created := &gmailapi.Label{
+		Id:                    fmt.Sprintf("Label_%d", m.nextID),
+		Name:                  l.Name,
+		Type:                  "user",
+		LabelListVisibility:   l.LabelListVisibility,
+		MessageListVisibility: l.MessageListVisibility,
+	}
+	m.labels[created.Id] = created
+	return created, nil
+}
+
+// ExpireHistory forgets the history recorded so far, like Gmail does after about a week:
+// history.list from an older start ID then fails with 404.
+func (m *Mailbox) ExpireHistory() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.history = nil
+	m.oldestKept = m.historyID
+}
+
+// Message returns a copy of the stored message, or nil.
+func (m *Mailbox) Message(id string) *gmailapi.Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if msg, ok := m.messages[id]; ok {
+		return clone(msg)
+	}
+	return nil
+}
+
+// Watches returns the watch requests received so far.
+func (m *Mailbox) Watches() []gmailapi.WatchRequest {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return slices.Clone(m.watches)
+}
+
+// Requests returns the API calls received so far as "METHOD /path".
+func (m *Mailbox) Requests() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return slices.Clone(m.requests)
+}
+
+// ref is the short form of a message used inside history records.
+func ref(msg *gmailapi.Message) *gmailapi.Message {
+	return &gmailapi.Message{Id: msg.Id, ThreadId: msg.ThreadId, LabelIds: slices.Clone(msg.LabelIds)}
+}
+
+func clone(msg *gmailapi.Message) *gmailapi.Message {
+	c := *msg
+	c.LabelIds = slices.Clone(msg.LabelIds)
+	return &c
+}
+
+func snippet(body string) string {
+	s := strings.Join(strings.Fields(body), " ")
+	if r := []rune(s); len(r) > 100 {
+		return string(r[:100])
+	}
+	return s
+}
+
+func encode(data []byte) string {
+	return base64.URLEncoding.EncodeToString(data)
+}
@@ -0,0 +1,117 @@
+package gmailtest
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+)
+
+// Server is a Mailbox served on a local httptest server.
+type Server struct {
+	*Mailbox
+	URL string
+
+	srv *httptest.Server
+}
+
+// NewServer starts a server for a new mailbox of the address. Close it when done.
+func NewServer(email string) *Server {
+	mb := NewMailbox(email)
+	srv := httptest.NewServer(mb)
+	return &Server{Mailbox: mb, URL: srv.URL, srv: srv}
+}
+
+// Close shuts the server down.
+func (s *Server) Close() {
+	s.srv.Close()
+}
+
+// Endpoint is the base URL to pass to option.WithEndpoint.
+func (s *Server) Endpoint() string {
+	return s.URL + "/"
+}
+
+// Client returns an HTTP client that sends every request to the server, whatever its host,
+// so a Gmail client built with it needs no endpoint override.
+func (s *Server) Client() *http.Client {
+	target, _ := url.Parse(s.URL)
+	return &http.Client{Transport: &redirectTransport{target: target, base: s.srv.Client().Transport}}
+}
+
+type redirectTransport struct {
+	target *url.URL
+	base   http.RoundTripper
+}
+
+func (t *redirectTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	r := req.Clone(req.Context())
+	r.URL.Scheme = t.target.Scheme
+	r.URL.Host = t.target.Host
+	r.Host = ""
+	return t.base.RoundTrip(r)
+}
+
+// Control returns the handler of the scripting endpoints, for driving a standalone server:
+//
+//	POST /fake/messages               add a Message (JSON), answers the API message
+//	POST /fake/messages/{id}/modify   {"addLabelIds": [...], "removeLabelIds": [...]}
+//	POST /fake/labels                 {"name": "..."}, answers {"id": "..."}
+//	POST /fake/expire-history         forget the history so far
+//	GET  /fake/requests               API calls received so far
+func (m *Mailbox) Control() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /fake/messages", func(w http.ResponseWriter, r *http.Request) {
+		var msg Message
+		if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+			http.Error(w, "Invalid body", http.StatusBadRequest)
+			return
+		}
+		writeControl(w, m.AddMessage(msg), nil)
+	})
+	mux.HandleFunc("POST /fake/messages/{id}/modify", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			AddLabelIDs    []string `json:"addLabelIds"`
+			RemoveLabelIDs []string `json:"removeLabelIds"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid body", http.StatusBadRequest)
+			return
+		}
+		msg, err := m.ModifyLabels(r.PathValue("id"), req.AddLabelIDs, req.RemoveLabelIDs)
+		writeControl(w, msg, err)
+	})
+	mux.HandleFunc("POST /fake/labels", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Name string `json:"name"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid body", http.StatusBadRequest)
+			return
+		}
+		id, err := m.CreateLabel(req.Name)
+		writeControl(w, map[string]string{"id": id}, err)
+	})
+	mux.HandleFunc("POST /fake/expire-history", func(w http.ResponseWriter, r *http.Request) {
+		m.ExpireHistory()
+		writeControl(w, map[string]uint64{"historyId": m.HistoryID()}, nil)
+	})
+	mux.HandleFunc("GET /fake/requests", func(w http.ResponseWriter, r *http.Request) {
+		writeControl(w, m.Requests(), nil)
+	})
+	return mux
+}
+
+func writeControl(w http.ResponseWriter, v any, err error) {
+	var apiErr *apiError
+	switch {
+	case errors.As(err, &apiErr):
+		http.Error(w, apiErr.Message, apiErr.Code)
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	default:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(v)
+	}
+}
@@ -0,0 +1,39 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"gagarin-soft/internal/oidc"
+)
+
+// RequireOIDC admits only requests whose bearer token passes v, for the routes the admin
+// service calls with ID tokens.
+func RequireOIDC(v *oidc.Verifier, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if status, err := verifyBearer(r, v); err != nil {
+			log.Printf("Rejected %s %s: %v", r.Method, r.URL.Path, err)
+			http.Error(w, http.StatusText(status), status)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// verifyBearer checks the request's bearer token against v. It returns the status to reject
+// the request with when the token is missing or does not pass.
+func verifyBearer(r *http.Request, v *oidc.Verifier) (int, error) {
+	bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || bearer == "" {
+		return http.StatusUnauthorized, errors.New("missing bearer token")
+	}
+	if _, err := v.Verify(r.Context(), bearer); err != nil {
+		if errors.Is(err, oidc.ErrForbidden) {
+			return http.StatusForbidden, err
+		}
+		return http.StatusUnauthorized, err
+	}
+	return http.StatusOK, nil
+}
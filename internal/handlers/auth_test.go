@@ -0,0 +1,41 @@
+package handlers_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"gagarin-soft/internal/handlers"
+)
+
+func TestRequireOIDC(t *testing.T) {
+	const audience = "https://worker.example.com"
+	const admin = "admin@project.iam.gserviceaccount.com"
+	verifier, sign := newTestVerifier(t, audience, admin)
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusAccepted) })
+
+	tests := []struct {
+		name   string
+		bearer string
+		want   int
+	}{
+		{name: "admin token", bearer: sign(audience, admin), want: http.StatusAccepted},
+		{name: "no token", want: http.StatusUnauthorized},
+		{name: "garbage token", bearer: "not-a-jwt", want: http.StatusUnauthorized},
+		{name: "wrong audience", bearer: sign(pushAudience, admin), want: http.StatusForbidden},
+		{name: "other service account", bearer: sign(audience, "intruder@example.com"), want: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/resync", nil)
+			if tt.bearer != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.bearer)
+			}
+			w := httptest.NewRecorder()
+			handlers.RequireOIDC(verifier, ok).ServeHTTP(w, req)
+			if w.Code != tt.want {
+				t.Errorf("Expected status %d, got %d", tt.want, w.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"gagarin-soft/internal/services"
+	"gagarin-soft/internal/storage"
+	"gagarin-soft/internal/worker"
+)
+
+// JobIDHeader is set by the admin service on actions it tracks as jobs.
+const JobIDHeader = "X-Job-ID"
+
+// JobAccepted is the answer to an action accepted as a job. Status is the job's status
+// when the request is answered: queued, or succeeded or failed for a job run inline.
+type JobAccepted struct {
+	JobID  string `json:"jobId"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// serveJob runs fn as the job named by the request's X-Job-ID header and answers 202; the
+// outcome is reported on the job rather than in the response. The job holds the worker
+// pool keys of the mailboxes it touches, which keys returns, and has the configured admin
+// job timeout instead of the one meant for pushes. serveJob returns false when
+// the request carries no job ID and should be served synchronously. Without a queue the
+// job runs before the request is answered, which then carries its outcome.
+func serveJob(w http.ResponseWriter, r *http.Request, svc *services.GmailWatchService, queue *worker.Pool, keys func(ctx context.Context) []string, fn func(ctx context.Context) ([]byte, error)) bool {
+	jobID := r.Header.Get(JobIDHeader)
+	if jobID == "" {
+		return false
+	}
+
+	job := worker.Job{
+		Name: fmt.Sprintf("job %s", jobID),
+		Run: func(ctx context.Context) error {
+			return svc.RunJob(ctx, jobID, fn)
+		},
+	}
+	accepted := JobAccepted{JobID: jobID, Status: storage.JobQueued}
+	if queue == nil {
+		accepted.Status = storage.JobSucceeded
+		if err := job.Run(r.Context()); err != nil {
+			accepted.Status, accepted.Error = storage.JobFailed, err.Error()
+		}
+	} else {
+		job.Keys = keys(r.Context())
+		job.Timeout = svc.Config.AdminJobTimeout
+		if job.Timeout == 0 {
+			job.Timeout = -1
+		}
+		if err := queue.Submit(job); err != nil {
+			log.Printf("Failed to enqueue job %s: %v", jobID, err)
+			http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+			return true
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(accepted)
+	return true
+}
@@ -1,23 +1,45 @@
 package handlers
 
 import (
+	"context"
+	"crypto/subtle"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"log"
 	"net/http"
 
+	"gagarin-soft/internal/oidc"
 	"gagarin-soft/internal/services"
+	"gagarin-soft/internal/worker"
 )
 
 type PushHandler struct {
 	Service *services.GmailWatchService
+	// Verifier, when set, checks the OIDC token Pub/Sub attaches to push requests.
+	Verifier *oidc.Verifier
+	// SharedToken, when set, is accepted as the legacy ?token= query parameter.
+	SharedToken string
+	// Queue, when set, processes pushes in the background. Without it the push is processed
+	// before the request is acknowledged.
+	Queue *worker.Pool
 }
 
+// PubSubMessage is the body of a Pub/Sub push request.
 type PubSubMessage struct {
-	Message struct {
-		Data string `json:"data"`
-	} `json:"message"`
+	Message      PubSubPayload `json:"message"`
+	Subscription string        `json:"subscription,omitempty"`
+}
+
+// PubSubPayload is the message wrapped in a push. Data is the base64 encoded GmailPushData
+// and PublishTime is RFC 3339.
+type PubSubPayload struct {
+	Data        string            `json:"data"`
+	MessageID   string            `json:"messageId"`
+	PublishTime string            `json:"publishTime,omitempty"`
+	Attributes  map[string]string `json:"attributes,omitempty"`
 }
 
 type GmailPushData struct {
@@ -26,6 +48,12 @@ type GmailPushData struct {
 }
 
 func (h *PushHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if status, err := h.authorize(r); err != nil {
+		log.Printf("Rejected push request: %v", err)
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+
 	var req PubSubMessage
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
@@ -50,12 +78,75 @@ func (h *PushHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK) // Acknowledge to prevent retry loop
 		return
 	}
+	if pushData.EmailAddress == "" || pushData.HistoryID == 0 {
+		log.Println("Ignoring push without emailAddress or historyId")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	// Pub/Sub delivers at least once; a redelivered message ID has already been accepted.
+	// Only well-formed pushes are claimed, so a malformed one doesn't use up its ID.
+	deliveryID := req.Message.MessageID
+	claimed, err := h.Service.ClaimDelivery(r.Context(), deliveryID)
+	if err != nil {
+		// Processing is idempotent, so a failed dedup check only costs duplicate work.
+		log.Printf("Failed to check delivery %s: %v", deliveryID, err)
+	} else if !claimed {
+		log.Printf("Ignoring redelivered push %s", deliveryID)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
 
-	log.Printf("Received push for historyId: %d", pushData.HistoryID)
-	if err := h.Service.ProcessPushNotification(r.Context(), pushData.HistoryID); err != nil {
-		log.Printf("Error processing push: %v", err)
-		// Return 200 to acknowledge Pub/Sub, but log error
+	log.Printf("Received push for %s, historyId: %d", pushData.EmailAddress, pushData.HistoryID)
+	if h.Queue == nil {
+		if err := h.Service.ProcessPushNotification(r.Context(), pushData.EmailAddress, pushData.HistoryID); err != nil {
+			log.Printf("Error processing push: %v", err)
+			// Return 200 to acknowledge Pub/Sub, but log error
+		}
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	// Pushes of one mailbox share a key so they sync one after another against its cursor.
+	job := worker.Job{
+		Key:  h.Service.MailboxKey(r.Context(), pushData.EmailAddress),
+		Name: fmt.Sprintf("push %s@%d", pushData.EmailAddress, pushData.HistoryID),
+		Run: func(ctx context.Context) error {
+			return h.Service.ProcessPushNotification(ctx, pushData.EmailAddress, pushData.HistoryID)
+		},
+	}
+	if err := h.Queue.Submit(job); err != nil {
+		log.Printf("Failed to enqueue push for %s: %v", pushData.EmailAddress, err)
+		if err := h.Service.ReleaseDelivery(r.Context(), deliveryID); err != nil {
+			log.Printf("Failed to release delivery %s: %v", deliveryID, err)
+		}
+		// Not acknowledged: Pub/Sub redelivers with backoff once the queue has room.
+		http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+		return
 	}
 
 	w.WriteHeader(http.StatusOK)
 }
+
+// authorize accepts the request when no authentication is configured, when it carries the
+// shared token, or when its bearer token passes the OIDC verifier. It returns the status
+// to reject the request with otherwise.
+func (h *PushHandler) authorize(r *http.Request) (int, error) {
+	if h.Verifier == nil && h.SharedToken == "" {
+		return http.StatusOK, nil
+	}
+
+	if token := r.URL.Query().Get("token"); token != "" && h.SharedToken != "" {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(h.SharedToken)) == 1 {
+			return http.StatusOK, nil
+		}
+		if h.Verifier == nil {
+			return http.StatusForbidden, errors.New("shared token mismatch")
+		}
+	}
+
+	if h.Verifier == nil {
+		return http.StatusUnauthorized, errors.New("missing shared token")
+	}
+	return verifyBearer(r, h.Verifier)
+}
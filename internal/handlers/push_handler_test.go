@@ -0,0 +1,192 @@
+package handlers_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gagarin-soft/internal/config"
+	"gagarin-soft/internal/handlers"
+	"gagarin-soft/internal/oidc"
+	"gagarin-soft/internal/services"
+	"gagarin-soft/internal/storage/mocks"
+	"gagarin-soft/internal/worker"
+)
+
+const pushAudience = "https://worker.example.com/gmail/push"
+
+// newTestVerifier returns a verifier for audience and a function signing tokens it accepts
+// when they are for audience and issued to email.
+func newTestVerifier(t *testing.T, audience, email string) (*oidc.Verifier, func(aud, email string) string) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwks, _ := oidc.MarshalJWKS(map[string]*rsa.PublicKey{"k1": &key.PublicKey})
+	jwksPath := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(jwksPath, jwks, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	verifier, err := oidc.NewVerifier(oidc.Config{JWKS: jwksPath, Audience: audience, Email: email})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sign := func(aud, email string) string {
+		token, err := oidc.Sign(key, "k1", oidc.Claims{
+			Issuer:        "https://accounts.google.com",
+			Audience:      oidc.Audience{aud},
+			Email:         email,
+			EmailVerified: email != "",
+			IssuedAt:      time.Now().Unix(),
+			Expiry:        time.Now().Add(time.Hour).Unix(),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	return verifier, sign
+}
+
+func TestPushHandler_Authentication(t *testing.T) {
+	verifier, signAs := newTestVerifier(t, pushAudience, "")
+	sign := func(aud string) string { return signAs(aud, "") }
+
+	svc := services.NewGmailWatchService(&config.Config{}, &MockAuthManager{}, mocks.NewMockHistoryRepository())
+
+	tests := []struct {
+		name        string
+		verifier    *oidc.Verifier
+		sharedToken string
+		target      string
+		bearer      string
+		want        int
+	}{
+		{name: "auth disabled", target: "/gmail/push", want: http.StatusOK},
+		{name: "valid oidc token", verifier: verifier, target: "/gmail/push", bearer: sign(pushAudience), want: http.StatusOK},
+		{name: "missing oidc token", verifier: verifier, target: "/gmail/push", want: http.StatusUnauthorized},
+		{name: "garbage oidc token", verifier: verifier, target: "/gmail/push", bearer: "not-a-jwt", want: http.StatusUnauthorized},
+		{name: "wrong audience", verifier: verifier, target: "/gmail/push", bearer: sign("https://other"), want: http.StatusForbidden},
+		{name: "shared token", sharedToken: "s3cret", target: "/gmail/push?token=s3cret", want: http.StatusOK},
+		{name: "wrong shared token", sharedToken: "s3cret", target: "/gmail/push?token=nope", want: http.StatusForbidden},
+		{name: "missing shared token", sharedToken: "s3cret", target: "/gmail/push", want: http.StatusUnauthorized},
+		{name: "shared token alongside oidc", verifier: verifier, sharedToken: "s3cret", target: "/gmail/push?token=s3cret", want: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := &handlers.PushHandler{Service: svc, Verifier: tt.verifier, SharedToken: tt.sharedToken}
+
+			// The payload does not decode to push data, so an authorized request is acked
+			// without touching Gmail.
+			data := base64.StdEncoding.EncodeToString([]byte("not json"))
+			req := httptest.NewRequest("POST", tt.target, bytes.NewBufferString(`{"message": {"data": "`+data+`"}}`))
+			if tt.bearer != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.bearer)
+			}
+			w := httptest.NewRecorder()
+
+			handler.ServeHTTP(w, req)
+
+			if w.Code != tt.want {
+				t.Errorf("Expected status %d, got %d", tt.want, w.Code)
+			}
+		})
+	}
+}
+
+func TestPushHandler_QueuesPush(t *testing.T) {
+	repo := mocks.NewMockHistoryRepository()
+	transport := &MockTransport{
+		RoundTripFunc: func(req *http.Request) (*http.Response, error) {
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(bytes.NewBufferString(`{"historyId": "150"}`)),
+				Header:     make(http.Header),
+			}, nil
+		},
+	}
+	svc := services.NewGmailWatchService(&config.Config{}, &MockAuthManager{Client: &http.Client{Transport: transport}}, repo)
+	pool := worker.NewPool(1, 10, time.Minute)
+	handler := &handlers.PushHandler{Service: svc, Queue: pool}
+
+	data := base64.StdEncoding.EncodeToString([]byte(`{"emailAddress": "shop@example.com", "historyId": 150}`))
+	req := httptest.NewRequest("POST", "/gmail/push", bytes.NewBufferString(`{"message": {"data": "`+data+`"}}`))
+	w := httptest.NewRecorder()
+
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+	if err := pool.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+	if stats := pool.Stats(); stats.Processed != 1 {
+		t.Errorf("Expected the push to be processed in the background, got %+v", stats)
+	}
+	if got := repo.Cursors["shop@example.com"]; got != 150 {
+		t.Errorf("Expected cursor 150, got %d", got)
+	}
+}
+
+func TestPushHandler_IgnoresRedelivery(t *testing.T) {
+	repo := mocks.NewMockHistoryRepository()
+	historyCalls := 0
+	transport := &MockTransport{
+		RoundTripFunc: func(req *http.Request) (*http.Response, error) {
+			historyCalls++
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(bytes.NewBufferString(`{"historyId": "150"}`)),
+				Header:     make(http.Header),
+			}, nil
+		},
+	}
+	svc := services.NewGmailWatchService(&config.Config{}, &MockAuthManager{Client: &http.Client{Transport: transport}}, repo)
+	handler := &handlers.PushHandler{Service: svc}
+
+	data := base64.StdEncoding.EncodeToString([]byte(`{"emailAddress": "shop@example.com", "historyId": 150}`))
+	body := `{"message": {"data": "` + data + `", "messageId": "1234"}}`
+	for i := 0; i < 2; i++ {
+		// Rewind the cursor so only dedup can stop the second delivery.
+		repo.Cursors["shop@example.com"] = 0
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("POST", "/gmail/push", bytes.NewBufferString(body)))
+		if w.Code != http.StatusOK {
+			t.Fatalf("Delivery %d: expected status 200, got %d", i+1, w.Code)
+		}
+	}
+
+	if historyCalls != 1 {
+		t.Errorf("Expected the push to be processed once, got %d Gmail calls", historyCalls)
+	}
+}
+
+func TestPushHandler_ClaimsOnlyWellFormedPushes(t *testing.T) {
+	repo := mocks.NewMockHistoryRepository()
+	svc := services.NewGmailWatchService(&config.Config{}, &MockAuthManager{}, repo)
+	handler := &handlers.PushHandler{Service: svc}
+
+	for name, data := range map[string]string{
+		"invalid base64": "%%%",
+		"invalid json":   base64.StdEncoding.EncodeToString([]byte("not json")),
+		"no history id":  base64.StdEncoding.EncodeToString([]byte(`{"emailAddress": "shop@example.com"}`)),
+	} {
+		body := `{"message": {"data": "` + data + `", "messageId": "1234"}}`
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/gmail/push", bytes.NewBufferString(body)))
+		if len(repo.Deliveries) != 0 {
+			t.Errorf("%s: expected the delivery not to be claimed, got %v", name, repo.Deliveries)
+		}
+	}
+}
@@ -1,21 +1,56 @@
 package handlers
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
 	"net/http"
 
+	"gagarin-soft/internal/gmail"
 	"gagarin-soft/internal/services"
+	"gagarin-soft/internal/worker"
 )
 
 type RenewWatchHandler struct {
 	Service *services.GmailWatchService
+	// Queue runs renewals requested as admin jobs in the background.
+	Queue *worker.Pool
 }
 
 func (h *RenewWatchHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
-	result, err := h.Service.Renew(ctx)
+	// The body is optional; its fields override the configured watch settings.
+	var opts services.RenewOptions
+	if err := json.NewDecoder(r.Body).Decode(&opts); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "Invalid Body", http.StatusBadRequest)
+		return
+	}
+	switch opts.LabelFilterBehavior {
+	case "", gmail.LabelFilterInclude, gmail.LabelFilterExclude:
+	default:
+		http.Error(w, "labelFilterBehavior must be include or exclude", http.StatusBadRequest)
+		return
+	}
+
+	renew := func(ctx context.Context) ([]byte, error) {
+		return h.Service.Renew(ctx, opts)
+	}
+	keys := func(ctx context.Context) []string {
+		return h.Service.RenewKeys(ctx, opts)
+	}
+	if serveJob(w, r, h.Service, h.Queue, keys, renew) {
+		return
+	}
+
+	result, err := renew(ctx)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		status := http.StatusInternalServerError
+		if errors.Is(err, services.ErrUnknownMailbox) || errors.Is(err, services.ErrMailboxDisabled) {
+			status = http.StatusNotFound
+		}
+		http.Error(w, err.Error(), status)
 		return
 	}
 
@@ -8,11 +8,14 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"gagarin-soft/internal/config"
 	"gagarin-soft/internal/handlers"
 	"gagarin-soft/internal/services"
+	"gagarin-soft/internal/storage"
 	"gagarin-soft/internal/storage/mocks"
+	"gagarin-soft/internal/worker"
 )
 
 // Reusing MockTokenManager and MockTransport from services test would be ideal,
@@ -88,3 +91,120 @@ func TestRenewWatchHandler_ServeHTTP(t *testing.T) {
 		t.Errorf("Expected historyId 999, got %v", respMap["historyId"])
 	}
 }
+
+func TestRenewWatchHandler_AppliesOverrideBody(t *testing.T) {
+	var watch struct {
+		TopicName           string   `json:"topicName"`
+		LabelIds            []string `json:"labelIds"`
+		LabelFilterBehavior string   `json:"labelFilterBehavior"`
+	}
+	mockTransport := &MockTransport{
+		RoundTripFunc: func(req *http.Request) (*http.Response, error) {
+			json.NewDecoder(req.Body).Decode(&watch)
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(bytes.NewBufferString(`{"historyId": "999", "expiration": "88888888"}`)),
+				Header:     make(http.Header),
+			}, nil
+		},
+	}
+	cfg := &config.Config{ProjectID: "test-proj", WatchLabels: []string{"INBOX"}}
+	svc := services.NewGmailWatchService(cfg, &MockAuthManager{Client: &http.Client{Transport: mockTransport}}, mocks.NewMockHistoryRepository())
+	handler := &handlers.RenewWatchHandler{Service: svc}
+
+	body := `{"topicName": "projects/custom/topics/my-topic", "labels": ["SPAM", "TRASH"], "labelFilterBehavior": "exclude"}`
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "/renew-watch", bytes.NewBufferString(body)))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200 OK, got %d: %s", w.Code, w.Body.String())
+	}
+	if watch.TopicName != "projects/custom/topics/my-topic" {
+		t.Errorf("Expected the overridden topic, got %q", watch.TopicName)
+	}
+	if len(watch.LabelIds) != 2 || watch.LabelIds[0] != "SPAM" || watch.LabelFilterBehavior != "exclude" {
+		t.Errorf("Expected exclude watch on SPAM and TRASH, got %s %v", watch.LabelFilterBehavior, watch.LabelIds)
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "/renew-watch", bytes.NewBufferString(`{"labelFilterBehavior": "maybe"}`)))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an invalid behavior, got %d", w.Code)
+	}
+}
+
+func TestRenewWatchHandler_RunsJobInlineWithoutQueue(t *testing.T) {
+	mockTransport := &MockTransport{
+		RoundTripFunc: func(req *http.Request) (*http.Response, error) {
+			return &http.Response{
+				StatusCode: http.StatusInternalServerError,
+				Body:       io.NopCloser(bytes.NewBufferString(`{}`)),
+				Header:     make(http.Header),
+			}, nil
+		},
+	}
+	repo := mocks.NewMockHistoryRepository()
+	svc := services.NewGmailWatchService(&config.Config{ProjectID: "test-proj"}, &MockAuthManager{Client: &http.Client{Transport: mockTransport}}, repo)
+	handler := &handlers.RenewWatchHandler{Service: svc}
+
+	req := httptest.NewRequest("POST", "/renew-watch", nil)
+	req.Header.Set(handlers.JobIDHeader, "job-failed")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("Expected 202 Accepted, got %d: %s", w.Code, w.Body.String())
+	}
+	var accepted handlers.JobAccepted
+	json.NewDecoder(w.Body).Decode(&accepted)
+	if accepted.Status != storage.JobFailed || accepted.Error == "" {
+		t.Errorf("Expected the answer to report the failed job, got %+v", accepted)
+	}
+	if job := repo.Jobs["job-failed"]; job == nil || job.Status != storage.JobFailed {
+		t.Errorf("Expected job-failed to fail, got %+v", job)
+	}
+}
+
+func TestRenewWatchHandler_RunsAsJob(t *testing.T) {
+	status := http.StatusOK
+	mockTransport := &MockTransport{
+		RoundTripFunc: func(req *http.Request) (*http.Response, error) {
+			return &http.Response{
+				StatusCode: status,
+				Body:       io.NopCloser(bytes.NewBufferString(`{"historyId": "999", "expiration": "88888888"}`)),
+				Header:     make(http.Header),
+			}, nil
+		},
+	}
+	repo := mocks.NewMockHistoryRepository()
+	svc := services.NewGmailWatchService(&config.Config{ProjectID: "test-proj"}, &MockAuthManager{Client: &http.Client{Transport: mockTransport}}, repo)
+	pool := worker.NewPool(1, 10, time.Minute)
+	handler := &handlers.RenewWatchHandler{Service: svc, Queue: pool}
+
+	for i, jobID := range []string{"job-ok", "job-failed"} {
+		req := httptest.NewRequest("POST", "/renew-watch", nil)
+		req.Header.Set(handlers.JobIDHeader, jobID)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if w.Code != http.StatusAccepted {
+			t.Fatalf("Expected 202 Accepted, got %d: %s", w.Code, w.Body.String())
+		}
+		var accepted handlers.JobAccepted
+		json.NewDecoder(w.Body).Decode(&accepted)
+		if accepted.JobID != jobID || accepted.Status != "queued" {
+			t.Errorf("Unexpected answer %+v", accepted)
+		}
+		// Wait for the job before changing the Gmail answer.
+		for stats := pool.Stats(); stats.Processed+stats.Failed <= uint64(i); stats = pool.Stats() {
+			time.Sleep(time.Millisecond)
+		}
+		status = http.StatusInternalServerError
+	}
+	pool.Shutdown(context.Background())
+
+	if job := repo.Jobs["job-ok"]; job == nil || job.Status != storage.JobSucceeded || !bytes.Contains(job.Result, []byte(`"999"`)) {
+		t.Errorf("Expected job-ok to succeed with the watch response, got %+v", job)
+	}
+	if job := repo.Jobs["job-failed"]; job == nil || job.Status != storage.JobFailed || job.Error == "" || job.FinishedAt == nil {
+		t.Errorf("Expected job-failed to fail with an error, got %+v", job)
+	}
+}
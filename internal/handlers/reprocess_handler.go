@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"gagarin-soft/internal/services"
+	"gagarin-soft/internal/worker"
+)
+
+type ReprocessHandler struct {
+	Service *services.GmailWatchService
+	// Queue runs reprocessing requested as admin jobs in the background.
+	Queue *worker.Pool
+}
+
+func (h *ReprocessHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req services.ReprocessRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid Body", http.StatusBadRequest)
+		return
+	}
+	// Checked up front so that a request run as a job is refused rather than failing later.
+	if err := req.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	reprocess := func(ctx context.Context) ([]byte, error) {
+		return h.Service.Reprocess(ctx, req)
+	}
+	// Messages may span mailboxes; the job holds the key of each of them.
+	keys := func(ctx context.Context) []string {
+		return h.Service.ReprocessKeys(ctx, req)
+	}
+	if serveJob(w, r, h.Service, h.Queue, keys, reprocess) {
+		return
+	}
+
+	result, err := reprocess(r.Context())
+	if err != nil {
+		status := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, services.ErrInvalidReprocess):
+			status = http.StatusBadRequest
+		case errors.Is(err, services.ErrUnknownMailbox) || errors.Is(err, services.ErrMailboxDisabled):
+			status = http.StatusNotFound
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(result)
+}
@@ -0,0 +1,36 @@
+package handlers_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"gagarin-soft/internal/config"
+	"gagarin-soft/internal/handlers"
+	"gagarin-soft/internal/services"
+	"gagarin-soft/internal/storage/mocks"
+	"gagarin-soft/internal/worker"
+)
+
+func TestReprocessHandler_RejectsInvalidEventIDs(t *testing.T) {
+	repo := mocks.NewMockHistoryRepository()
+	svc := services.NewGmailWatchService(&config.Config{}, &MockAuthManager{}, repo)
+	pool := worker.NewPool(1, 10, time.Minute)
+	defer pool.Shutdown(context.Background())
+	handler := &handlers.ReprocessHandler{Service: svc, Queue: pool}
+
+	req := httptest.NewRequest("POST", "/reprocess", strings.NewReader(`{"eventIds": ["ev-1"]}`))
+	req.Header.Set(handlers.JobIDHeader, "job-1")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "not a UUID") {
+		t.Errorf("Expected 400 for an event ID that is not a UUID, got %d: %s", w.Code, w.Body.String())
+	}
+	if stats := pool.Stats(); stats.QueueDepth+stats.InFlight != 0 || stats.Processed+stats.Failed != 0 {
+		t.Errorf("Expected no job to be queued, got %+v", stats)
+	}
+}
@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"gagarin-soft/internal/services"
+	"gagarin-soft/internal/worker"
+)
+
+type ResyncHandler struct {
+	Service *services.GmailWatchService
+	// Queue runs resyncs requested as admin jobs in the background, serialized with the
+	// mailbox's pushes.
+	Queue *worker.Pool
+}
+
+// ResyncRequest optionally names the mailbox to resync. The body may be empty when only
+// one mailbox is active.
+type ResyncRequest struct {
+	EmailAddress string `json:"emailAddress"`
+}
+
+func (h *ResyncHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req ResyncRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "Invalid Body", http.StatusBadRequest)
+		return
+	}
+
+	resync := func(ctx context.Context) ([]byte, error) {
+		return h.Service.Resync(ctx, req.EmailAddress)
+	}
+	// The job shares the mailbox's key with its pushes, which move the same sync cursor.
+	keys := func(ctx context.Context) []string {
+		return []string{h.Service.MailboxKey(ctx, req.EmailAddress)}
+	}
+	if serveJob(w, r, h.Service, h.Queue, keys, resync) {
+		return
+	}
+
+	result, err := resync(r.Context())
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, services.ErrUnknownMailbox) || errors.Is(err, services.ErrMailboxDisabled) {
+			status = http.StatusNotFound
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(result)
+}
@@ -0,0 +1,18 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"gagarin-soft/internal/worker"
+)
+
+// WorkerStatsHandler reports the push queue depth and in-flight jobs.
+type WorkerStatsHandler struct {
+	Pool *worker.Pool
+}
+
+func (h *WorkerStatsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.Pool.Stats())
+}
@@ -0,0 +1,63 @@
+package migrate_test
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"gagarin-soft/internal/migrate"
+	"gagarin-soft/migrations"
+)
+
+func TestLoad_OrdersAndPairsFiles(t *testing.T) {
+	fsys := fstest.MapFS{
+		"010_later.up.sql":    {Data: []byte("SELECT 10;")},
+		"002_second.up.sql":   {Data: []byte("SELECT 2;")},
+		"002_second.down.sql": {Data: []byte("SELECT -2;")},
+		"001_first.up.sql":    {Data: []byte("SELECT 1;")},
+		"README.md":           {Data: []byte("not a migration")},
+	}
+
+	got, err := migrate.Load(fsys)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if len(got) != 3 || got[0].Version != 1 || got[1].Version != 2 || got[2].Version != 10 {
+		t.Fatalf("Expected versions 1, 2, 10, got %+v", got)
+	}
+	if got[1].Name != "second" || got[1].Up != "SELECT 2;" || got[1].Down != "SELECT -2;" {
+		t.Errorf("Expected up and down of 002_second, got %+v", got[1])
+	}
+	if got[0].Down != "" {
+		t.Errorf("Expected 001_first to have no down, got %q", got[0].Down)
+	}
+}
+
+func TestLoad_RejectsInconsistentFiles(t *testing.T) {
+	for name, fsys := range map[string]fstest.MapFS{
+		"down only": {"001_first.down.sql": {Data: []byte("SELECT 1;")}},
+		"two names": {
+			"001_first.up.sql": {Data: []byte("SELECT 1;")},
+			"001_other.up.sql": {Data: []byte("SELECT 1;")},
+		},
+	} {
+		if _, err := migrate.Load(fsys); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestLoad_EmbeddedMigrations(t *testing.T) {
+	got, err := migrate.Load(migrations.FS)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	for i, m := range got {
+		if m.Version != int64(i+1) {
+			t.Errorf("Expected consecutive versions, got %d at position %d", m.Version, i)
+		}
+		if strings.TrimSpace(m.Down) == "" {
+			t.Errorf("Migration %d_%s has no down", m.Version, m.Name)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// jwk is a single RSA key from a JSON Web Key Set.
+type jwk struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+type jwkSet struct {
+	Keys []jwk `json:"keys"`
+}
+
+// keySet loads RSA public keys from a JWKS file or URL. URL key sets are cached and
+// refetched when a token names an unknown key ID, which is how Google rotates keys. The
+// set is downloaded without holding the lock, so tokens signed with a cached key are
+// verified while a refresh is in flight.
+type keySet struct {
+	source     string
+	httpClient *http.Client
+
+	mu        sync.Mutex
+	keys      map[string]*rsa.PublicKey
+	fetchedAt time.Time
+	fetching  chan struct{} // closed when the download in flight ends, nil without one
+}
+
+// minRefetchInterval limits how often an unknown kid can trigger a JWKS download.
+const minRefetchInterval = time.Minute
+
+func newKeySet(source string, httpClient *http.Client) *keySet {
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &keySet{source: source, httpClient: httpClient}
+}
+
+func (s *keySet) isURL() bool {
+	return strings.HasPrefix(s.source, "https://") || strings.HasPrefix(s.source, "http://")
+}
+
+// key returns the public key for kid, loading or refreshing the set when needed. Callers
+// missing a key while the set is being downloaded wait for that download instead of
+// starting another.
+func (s *keySet) key(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	s.mu.Lock()
+	for s.fetching != nil {
+		if k, ok := s.keys[kid]; ok {
+			s.mu.Unlock()
+			return k, nil
+		}
+		fetching := s.fetching
+		s.mu.Unlock()
+		select {
+		case <-fetching:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		s.mu.Lock()
+	}
+
+	if k, ok := s.keys[kid]; ok {
+		s.mu.Unlock()
+		return k, nil
+	}
+	if s.keys != nil && (!s.isURL() || time.Since(s.fetchedAt) < minRefetchInterval) {
+		s.mu.Unlock()
+		return nil, fmt.Errorf("unknown key id %q", kid)
+	}
+	fetching := make(chan struct{})
+	s.fetching = fetching
+	s.mu.Unlock()
+
+	keys, err := s.load(ctx)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fetching = nil
+	close(fetching)
+	if err != nil {
+		return nil, err
+	}
+	s.keys = keys
+	s.fetchedAt = time.Now()
+
+	if k, ok := s.keys[kid]; ok {
+		return k, nil
+	}
+	return nil, fmt.Errorf("unknown key id %q", kid)
+}
+
+func (s *keySet) load(ctx context.Context) (map[string]*rsa.PublicKey, error) {
+	var data []byte
+	if s.isURL() {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.source, nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := s.httpClient.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch JWKS: %w", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("failed to fetch JWKS: status %d", resp.StatusCode)
+		}
+		if data, err = io.ReadAll(resp.Body); err != nil {
+			return nil, fmt.Errorf("failed to read JWKS: %w", err)
+		}
+	} else {
+		var err error
+		if data, err = os.ReadFile(s.source); err != nil {
+			return nil, fmt.Errorf("failed to read JWKS: %w", err)
+		}
+	}
+	return ParseJWKS(data)
+}
+
+// ParseJWKS parses the RSA keys of a JSON Web Key Set, indexed by key ID. Keys of other
+// types are ignored.
+func ParseJWKS(data []byte) (map[string]*rsa.PublicKey, error) {
+	var set jwkSet
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("invalid JWKS: %w", err)
+	}
+
+	keys := make(map[string]*rsa.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid modulus of key %q: %w", k.Kid, err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exponent of key %q: %w", k.Kid, err)
+		}
+		keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("JWKS contains no RSA signing keys")
+	}
+	return keys, nil
+}
+
+// MarshalJWKS encodes public keys as a JSON Web Key Set, e.g. to serve a local key to
+// tests or development tools.
+func MarshalJWKS(keys map[string]*rsa.PublicKey) ([]byte, error) {
+	var set jwkSet
+	for kid, k := range keys {
+		set.Keys = append(set.Keys, jwk{
+			Kid: kid,
+			Kty: "RSA",
+			Alg: "RS256",
+			Use: "sig",
+			N:   base64.RawURLEncoding.EncodeToString(k.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.E)).Bytes()),
+		})
+	}
+	return json.Marshal(set)
+}
@@ -0,0 +1,71 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestKeySet_RefreshDoesNotBlockCachedKeys(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := MarshalJWKS(map[string]*rsa.PublicKey{"cached": &key.PublicKey, "rotated": &key.PublicKey})
+
+	release := make(chan struct{})
+	var fetches atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		<-release
+		w.Write(data)
+	}))
+	defer srv.Close()
+	unblock := sync.OnceFunc(func() { close(release) })
+	defer unblock()
+
+	s := newKeySet(srv.URL, nil)
+	s.keys = map[string]*rsa.PublicKey{"cached": &key.PublicKey}
+	s.fetchedAt = time.Now().Add(-2 * minRefetchInterval)
+
+	// Verifications naming the rotated key all wait for a single download.
+	var wg sync.WaitGroup
+	for range 3 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := s.key(context.Background(), "rotated"); err != nil {
+				t.Errorf("Expected the rotated key after the refresh, got %v", err)
+			}
+		}()
+	}
+	for fetches.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := s.key(context.Background(), "cached")
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Expected the cached key, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the cached key not to wait for the refresh")
+	}
+
+	unblock()
+	wg.Wait()
+	if n := fetches.Load(); n != 1 {
+		t.Errorf("Expected 1 JWKS fetch, got %d", n)
+	}
+}
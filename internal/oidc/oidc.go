@@ -0,0 +1,198 @@
+// Package oidc verifies (and, for tests and local tooling, signs) the RS256 OIDC ID tokens
+// Google attaches to Pub/Sub push requests and service-to-service calls.
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// GoogleJWKSURL is the key set Google signs its ID tokens with.
+const GoogleJWKSURL = "https://www.googleapis.com/oauth2/v3/certs"
+
+// GoogleIssuers are the issuer values found in Google-signed ID tokens.
+var GoogleIssuers = []string{"https://accounts.google.com", "accounts.google.com"}
+
+var (
+	// ErrInvalidToken means the token is missing, malformed, expired or not signed by a
+	// trusted key. Callers should answer 401.
+	ErrInvalidToken = errors.New("invalid token")
+	// ErrForbidden means the token is authentic but was issued to someone else (audience,
+	// issuer or email mismatch). Callers should answer 403.
+	ErrForbidden = errors.New("token not accepted")
+)
+
+// clockSkew is tolerated on exp, nbf and iat.
+const clockSkew = time.Minute
+
+// Audience holds the aud claim, which may be a single string or a list.
+type Audience []string
+
+func (a *Audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = Audience{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*a = list
+	return nil
+}
+
+func (a Audience) MarshalJSON() ([]byte, error) {
+	if len(a) == 1 {
+		return json.Marshal(a[0])
+	}
+	return json.Marshal([]string(a))
+}
+
+// Claims are the ID token claims this package understands.
+type Claims struct {
+	Issuer        string   `json:"iss"`
+	Audience      Audience `json:"aud"`
+	Subject       string   `json:"sub,omitempty"`
+	Email         string   `json:"email,omitempty"`
+	EmailVerified bool     `json:"email_verified,omitempty"`
+	IssuedAt      int64    `json:"iat"`
+	NotBefore     int64    `json:"nbf,omitempty"`
+	Expiry        int64    `json:"exp"`
+}
+
+type header struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	Typ string `json:"typ,omitempty"`
+}
+
+// Config configures a Verifier.
+type Config struct {
+	// JWKS is the URL or file path of the key set. Defaults to GoogleJWKSURL.
+	JWKS string
+	// Audience is the expected aud claim. Required.
+	Audience string
+	// Issuers are the accepted iss values. Defaults to GoogleIssuers.
+	Issuers []string
+	// Email, when set, is the service account the token must be issued to.
+	Email string
+	// HTTPClient fetches URL key sets.
+	HTTPClient *http.Client
+}
+
+// Verifier checks RS256 ID tokens against a key set and the configured claims.
+type Verifier struct {
+	cfg  Config
+	keys *keySet
+	now  func() time.Time
+}
+
+func NewVerifier(cfg Config) (*Verifier, error) {
+	if cfg.Audience == "" {
+		return nil, errors.New("oidc: audience is required")
+	}
+	if cfg.JWKS == "" {
+		cfg.JWKS = GoogleJWKSURL
+	}
+	if len(cfg.Issuers) == 0 {
+		cfg.Issuers = GoogleIssuers
+	}
+	return &Verifier{cfg: cfg, keys: newKeySet(cfg.JWKS, cfg.HTTPClient), now: time.Now}, nil
+}
+
+// Verify checks the token's signature, lifetime, issuer, audience and email and returns
+// its claims. Errors wrap ErrInvalidToken or ErrForbidden.
+func (v *Verifier) Verify(ctx context.Context, token string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: malformed token", ErrInvalidToken)
+	}
+
+	var h header
+	if err := decodeSegment(parts[0], &h); err != nil {
+		return nil, fmt.Errorf("%w: header: %v", ErrInvalidToken, err)
+	}
+	if h.Alg != "RS256" {
+		return nil, fmt.Errorf("%w: unsupported alg %q", ErrInvalidToken, h.Alg)
+	}
+
+	key, err := v.keys.key(ctx, h.Kid)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: signature: %v", ErrInvalidToken, err)
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig); err != nil {
+		return nil, fmt.Errorf("%w: bad signature", ErrInvalidToken)
+	}
+
+	var c Claims
+	if err := decodeSegment(parts[1], &c); err != nil {
+		return nil, fmt.Errorf("%w: claims: %v", ErrInvalidToken, err)
+	}
+
+	now := v.now()
+	if c.Expiry == 0 || now.After(time.Unix(c.Expiry, 0).Add(clockSkew)) {
+		return nil, fmt.Errorf("%w: token expired", ErrInvalidToken)
+	}
+	if c.NotBefore != 0 && now.Add(clockSkew).Before(time.Unix(c.NotBefore, 0)) {
+		return nil, fmt.Errorf("%w: token not valid yet", ErrInvalidToken)
+	}
+	if c.IssuedAt != 0 && now.Add(clockSkew).Before(time.Unix(c.IssuedAt, 0)) {
+		return nil, fmt.Errorf("%w: token issued in the future", ErrInvalidToken)
+	}
+
+	if !slices.Contains(v.cfg.Issuers, c.Issuer) {
+		return nil, fmt.Errorf("%w: unexpected issuer %q", ErrForbidden, c.Issuer)
+	}
+	if !slices.Contains(c.Audience, v.cfg.Audience) {
+		return nil, fmt.Errorf("%w: unexpected audience %v", ErrForbidden, []string(c.Audience))
+	}
+	if v.cfg.Email != "" && (!strings.EqualFold(c.Email, v.cfg.Email) || !c.EmailVerified) {
+		return nil, fmt.Errorf("%w: unexpected email %q", ErrForbidden, c.Email)
+	}
+	return &c, nil
+}
+
+// Sign creates an RS256 token for claims. Production tokens come from Google; this is for
+// tests and local tools that sign with a key published through a local JWKS.
+func Sign(key *rsa.PrivateKey, kid string, c Claims) (string, error) {
+	h, err := json.Marshal(header{Alg: "RS256", Kid: kid, Typ: "JWT"})
+	if err != nil {
+		return "", err
+	}
+	body, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	signed := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(body)
+	digest := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+func decodeSegment(seg string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,153 @@
+package oidc_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gagarin-soft/internal/oidc"
+)
+
+const (
+	testAudience = "https://worker.example.com/gmail/push"
+	testEmail    = "pubsub-push@test-project.iam.gserviceaccount.com"
+)
+
+func newKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func writeJWKS(t *testing.T, keys map[string]*rsa.PublicKey) string {
+	t.Helper()
+	data, err := oidc.MarshalJWKS(keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func validClaims() oidc.Claims {
+	now := time.Now()
+	return oidc.Claims{
+		Issuer:        "https://accounts.google.com",
+		Audience:      oidc.Audience{testAudience},
+		Subject:       "1234567890",
+		Email:         testEmail,
+		EmailVerified: true,
+		IssuedAt:      now.Unix(),
+		Expiry:        now.Add(time.Hour).Unix(),
+	}
+}
+
+func TestVerifier_Verify(t *testing.T) {
+	key := newKey(t)
+	other := newKey(t)
+	jwks := writeJWKS(t, map[string]*rsa.PublicKey{"k1": &key.PublicKey})
+
+	v, err := oidc.NewVerifier(oidc.Config{JWKS: jwks, Audience: testAudience, Email: testEmail})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		key     *rsa.PrivateKey
+		kid     string
+		modify  func(c *oidc.Claims)
+		wantErr error
+	}{
+		{name: "valid", key: key, kid: "k1"},
+		{name: "wrong key", key: other, kid: "k1", wantErr: oidc.ErrInvalidToken},
+		{name: "unknown kid", key: key, kid: "k2", wantErr: oidc.ErrInvalidToken},
+		{name: "expired", key: key, kid: "k1", modify: func(c *oidc.Claims) { c.Expiry = time.Now().Add(-time.Hour).Unix() }, wantErr: oidc.ErrInvalidToken},
+		{name: "wrong audience", key: key, kid: "k1", modify: func(c *oidc.Claims) { c.Audience = oidc.Audience{"https://other"} }, wantErr: oidc.ErrForbidden},
+		{name: "wrong issuer", key: key, kid: "k1", modify: func(c *oidc.Claims) { c.Issuer = "https://evil.example.com" }, wantErr: oidc.ErrForbidden},
+		{name: "wrong email", key: key, kid: "k1", modify: func(c *oidc.Claims) { c.Email = "someone@example.com" }, wantErr: oidc.ErrForbidden},
+		{name: "unverified email", key: key, kid: "k1", modify: func(c *oidc.Claims) { c.EmailVerified = false }, wantErr: oidc.ErrForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := validClaims()
+			if tt.modify != nil {
+				tt.modify(&c)
+			}
+			token, err := oidc.Sign(tt.key, tt.kid, c)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := v.Verify(context.Background(), token)
+			if tt.wantErr == nil {
+				if err != nil {
+					t.Fatalf("Expected no error, got %v", err)
+				}
+				if got.Email != testEmail {
+					t.Errorf("Expected email %s, got %s", testEmail, got.Email)
+				}
+				return
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Expected %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestVerifier_RejectsMalformedTokens(t *testing.T) {
+	key := newKey(t)
+	v, _ := oidc.NewVerifier(oidc.Config{JWKS: writeJWKS(t, map[string]*rsa.PublicKey{"k1": &key.PublicKey}), Audience: testAudience})
+
+	token, _ := oidc.Sign(key, "k1", validClaims())
+	parts := strings.Split(token, ".")
+	for _, bad := range []string{"", "abc", parts[0] + "." + parts[1], "eyJhbGciOiJub25lIn0." + parts[1] + "."} {
+		if _, err := v.Verify(context.Background(), bad); !errors.Is(err, oidc.ErrInvalidToken) {
+			t.Errorf("Verify(%q): expected ErrInvalidToken, got %v", bad, err)
+		}
+	}
+}
+
+func TestVerifier_LimitsURLKeySetRefetches(t *testing.T) {
+	oldKey, newKeyPair := newKey(t), newKey(t)
+	keys := map[string]*rsa.PublicKey{"old": &oldKey.PublicKey}
+	fetches := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		data, _ := oidc.MarshalJWKS(keys)
+		w.Write(data)
+	}))
+	defer srv.Close()
+
+	v, _ := oidc.NewVerifier(oidc.Config{JWKS: srv.URL, Audience: testAudience})
+
+	token, _ := oidc.Sign(oldKey, "old", validClaims())
+	if _, err := v.Verify(context.Background(), token); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	// Rotation: the first fetch is too recent, so an unknown kid is rejected without a refetch.
+	keys["new"] = &newKeyPair.PublicKey
+	token, _ = oidc.Sign(newKeyPair, "new", validClaims())
+	if _, err := v.Verify(context.Background(), token); !errors.Is(err, oidc.ErrInvalidToken) {
+		t.Errorf("Expected ErrInvalidToken, got %v", err)
+	}
+	if fetches != 1 {
+		t.Errorf("Expected 1 JWKS fetch, got %d", fetches)
+	}
+}
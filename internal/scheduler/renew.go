@@ -0,0 +1,124 @@
+// Package scheduler keeps Gmail watches alive by renewing them before they expire.
+package scheduler
+
+import (
+	"context"
+	"log"
+	"math/rand/v2"
+	"time"
+
+	"gagarin-soft/internal/gmail"
+	"gagarin-soft/internal/storage"
+)
+
+// renewLockKey is the Postgres advisory lock that elects the instance doing renewals.
+const renewLockKey int64 = 0x676d61696c01 // "gmail" + 1
+
+// Renewer renews the watch of a single mailbox. *services.GmailWatchService implements it.
+type Renewer interface {
+	WatchedMailboxes(ctx context.Context) ([]storage.Mailbox, error)
+	RenewMailbox(ctx context.Context, mb *storage.Mailbox) (*gmail.WatchResponse, error)
+}
+
+// RenewScheduler periodically renews every watch that expires within RenewBefore. Only the
+// instance holding the advisory lock renews; the others skip the round.
+type RenewScheduler struct {
+	Renewer Renewer
+	Repo    storage.HistoryRepository
+
+	// Interval between checks; each wait is jittered by up to a fifth.
+	Interval time.Duration
+	// RenewBefore is how long before expiry a watch is renewed.
+	RenewBefore time.Duration
+	// Retries is how often a failed renewal is retried within one round.
+	Retries int
+	// RetryBackoff is the base delay before the first retry; it doubles per attempt and is
+	// jittered.
+	RetryBackoff time.Duration
+}
+
+func NewRenewScheduler(renewer Renewer, repo storage.HistoryRepository) *RenewScheduler {
+	return &RenewScheduler{
+		Renewer:      renewer,
+		Repo:         repo,
+		Interval:     15 * time.Minute,
+		RenewBefore:  24 * time.Hour,
+		Retries:      3,
+		RetryBackoff: 30 * time.Second,
+	}
+}
+
+// Run checks the watches until ctx is cancelled, starting with an immediate check.
+func (s *RenewScheduler) Run(ctx context.Context) {
+	for {
+		if err := s.Tick(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("Watch renewal round failed: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(jitter(s.Interval)):
+		}
+	}
+}
+
+// Tick runs one renewal round if this instance wins the lock.
+func (s *RenewScheduler) Tick(ctx context.Context) error {
+	acquired, err := s.Repo.WithAdvisoryLock(ctx, renewLockKey, s.renewDue)
+	if err == nil && !acquired {
+		log.Println("Another instance is renewing watches, skipping")
+	}
+	return err
+}
+
+func (s *RenewScheduler) renewDue(ctx context.Context) error {
+	mailboxes, err := s.Renewer.WatchedMailboxes(ctx)
+	if err != nil {
+		return err
+	}
+	expirations, err := s.Repo.ListWatchExpirations(ctx)
+	if err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(s.RenewBefore)
+	for i := range mailboxes {
+		mb := &mailboxes[i]
+		expiration, watched := expirations[mb.ID]
+		if watched && time.UnixMilli(expiration).After(deadline) {
+			continue
+		}
+		if err := s.renew(ctx, mb); err != nil {
+			// Keep going: one broken mailbox must not stop the others' renewals.
+			log.Printf("Giving up renewing watch for %s until next round: %v", mb.EmailAddress, err)
+		}
+	}
+	return nil
+}
+
+func (s *RenewScheduler) renew(ctx context.Context, mb *storage.Mailbox) error {
+	backoff := s.RetryBackoff
+	for attempt := 0; ; attempt++ {
+		_, err := s.Renewer.RenewMailbox(ctx, mb)
+		if err == nil || attempt >= s.Retries {
+			return err
+		}
+		wait := jitter(backoff)
+		log.Printf("Renewing watch for %s failed (attempt %d), retrying in %s: %v", mb.EmailAddress, attempt+1, wait.Round(time.Millisecond), err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+		backoff *= 2
+	}
+}
+
+// jitter spreads d by ±20% so instances and retries don't fire in lockstep.
+func jitter(d time.Duration) time.Duration {
+	if d <= 0 {
+		return 0
+	}
+	spread := int64(d) / 5
+	return d - time.Duration(spread) + time.Duration(rand.Int64N(2*spread+1))
+}
@@ -0,0 +1,87 @@
+package scheduler_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"gagarin-soft/internal/gmail"
+	"gagarin-soft/internal/scheduler"
+	"gagarin-soft/internal/storage"
+	"gagarin-soft/internal/storage/mocks"
+)
+
+type fakeRenewer struct {
+	mailboxes []storage.Mailbox
+	failures  map[string]int // remaining failures per mailbox ID
+	renewed   []string
+}
+
+func (f *fakeRenewer) WatchedMailboxes(ctx context.Context) ([]storage.Mailbox, error) {
+	return f.mailboxes, nil
+}
+
+func (f *fakeRenewer) RenewMailbox(ctx context.Context, mb *storage.Mailbox) (*gmail.WatchResponse, error) {
+	f.renewed = append(f.renewed, mb.ID)
+	if f.failures[mb.ID] > 0 {
+		f.failures[mb.ID]--
+		return nil, errors.New("gmail unavailable")
+	}
+	return &gmail.WatchResponse{HistoryId: 1, Expiration: time.Now().Add(7 * 24 * time.Hour).UnixMilli()}, nil
+}
+
+func newScheduler(renewer *fakeRenewer, repo *mocks.MockHistoryRepository) *scheduler.RenewScheduler {
+	s := scheduler.NewRenewScheduler(renewer, repo)
+	s.RetryBackoff = time.Millisecond
+	return s
+}
+
+func TestRenewScheduler_RenewsOnlyDueWatches(t *testing.T) {
+	repo := mocks.NewMockHistoryRepository()
+	now := time.Now()
+	repo.SaveWatchStatus(context.Background(), "fresh", 1, now.Add(6*24*time.Hour).UnixMilli())
+	repo.SaveWatchStatus(context.Background(), "expiring", 1, now.Add(2*time.Hour).UnixMilli())
+
+	renewer := &fakeRenewer{mailboxes: []storage.Mailbox{{ID: "fresh"}, {ID: "expiring"}, {ID: "never-watched"}}}
+	if err := newScheduler(renewer, repo).Tick(context.Background()); err != nil {
+		t.Fatalf("Tick: %v", err)
+	}
+
+	if len(renewer.renewed) != 2 || renewer.renewed[0] != "expiring" || renewer.renewed[1] != "never-watched" {
+		t.Errorf("Expected expiring and never-watched to be renewed, got %v", renewer.renewed)
+	}
+}
+
+func TestRenewScheduler_RetriesFailedRenewals(t *testing.T) {
+	repo := mocks.NewMockHistoryRepository()
+	renewer := &fakeRenewer{
+		mailboxes: []storage.Mailbox{{ID: "flaky"}, {ID: "broken"}, {ID: "ok"}},
+		failures:  map[string]int{"flaky": 2, "broken": 100},
+	}
+	if err := newScheduler(renewer, repo).Tick(context.Background()); err != nil {
+		t.Fatalf("Tick: %v", err)
+	}
+
+	counts := map[string]int{}
+	for _, id := range renewer.renewed {
+		counts[id]++
+	}
+	// One attempt plus three retries at most; the broken mailbox doesn't block the others.
+	if counts["flaky"] != 3 || counts["broken"] != 4 || counts["ok"] != 1 {
+		t.Errorf("Unexpected renewal attempts %v", counts)
+	}
+}
+
+func TestRenewScheduler_SkipsWhenAnotherInstanceHoldsTheLock(t *testing.T) {
+	repo := mocks.NewMockHistoryRepository()
+	repo.LockHeld = true
+	renewer := &fakeRenewer{mailboxes: []storage.Mailbox{{ID: "mb-1"}}}
+
+	if err := newScheduler(renewer, repo).Tick(context.Background()); err != nil {
+		t.Fatalf("Tick: %v", err)
+	}
+	if len(renewer.renewed) != 0 {
+		t.Errorf("Expected no renewals without the lock, got %v", renewer.renewed)
+	}
+}
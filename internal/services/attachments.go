@@ -0,0 +1,69 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"slices"
+
+	"gagarin-soft/internal/gmail"
+	"gagarin-soft/internal/storage"
+)
+
+// storeAttachments downloads every attachment of the message and writes it to the blob
+// store. Content is keyed by its SHA-256, so the same file is stored once no matter how
+// often it is received, and attachments already recorded for the message (same filename
+// and size) are not downloaded again when it is replayed or reprocessed. The returned
+// metadata still lacks the ProcessedEmailID.
+func (s *GmailWatchService) storeAttachments(ctx context.Context, client *gmail.Client, msg *gmail.DecodedMessage) ([]storage.Attachment, error) {
+	if s.Blobs == nil || msg == nil || len(msg.Attachments) == 0 {
+		return nil, nil
+	}
+
+	// A failed lookup only costs the downloads.
+	existing, err := s.Repo.ListAttachments(ctx, msg.ID)
+	if err != nil {
+		log.Printf("Failed to load the stored attachments of %s: %v", msg.ID, err)
+	}
+
+	var stored []storage.Attachment
+	for _, a := range msg.Attachments {
+		if i := slices.IndexFunc(existing, func(e storage.Attachment) bool {
+			return e.Filename == a.Filename && e.Size == a.Size
+		}); i >= 0 {
+			stored = append(stored, storage.Attachment{
+				MessageID:  msg.ID,
+				Filename:   a.Filename,
+				MimeType:   a.MimeType,
+				Size:       existing[i].Size,
+				SHA256:     existing[i].SHA256,
+				StorageKey: existing[i].StorageKey,
+			})
+			continue
+		}
+
+		data, err := client.AttachmentContent(msg.ID, a)
+		if err != nil {
+			return nil, fmt.Errorf("attachment %q: %w", a.Filename, err)
+		}
+
+		sum := sha256.Sum256(data)
+		hash := hex.EncodeToString(sum[:])
+		key := fmt.Sprintf("attachments/%s/%s", hash[:2], hash)
+		if err := s.Blobs.Put(ctx, key, data, a.MimeType); err != nil {
+			return nil, fmt.Errorf("attachment %q: %w", a.Filename, err)
+		}
+
+		stored = append(stored, storage.Attachment{
+			MessageID:  msg.ID,
+			Filename:   a.Filename,
+			MimeType:   a.MimeType,
+			Size:       int64(len(data)),
+			SHA256:     hash,
+			StorageKey: key,
+		})
+	}
+	return stored, nil
+}
@@ -0,0 +1,69 @@
+package services
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// defaultDeliveryTTL applies when the config leaves PubSubDedupTTL unset.
+const defaultDeliveryTTL = 24 * time.Hour
+
+// deliveryPurger makes sure expired Pub/Sub delivery records are removed at most hourly.
+type deliveryPurger struct {
+	mu      sync.Mutex
+	lastRun time.Time
+}
+
+func (s *GmailWatchService) deliveryTTL() time.Duration {
+	if s.Config.PubSubDedupTTL > 0 {
+		return s.Config.PubSubDedupTTL
+	}
+	return defaultDeliveryTTL
+}
+
+// ClaimDelivery reports whether the Pub/Sub message deliveryID is delivered for the first
+// time. Redeliveries within the dedup TTL return false and should be acknowledged without
+// processing. Deliveries without an ID are always processed.
+func (s *GmailWatchService) ClaimDelivery(ctx context.Context, deliveryID string) (bool, error) {
+	if deliveryID == "" {
+		return true, nil
+	}
+	ttl := s.deliveryTTL()
+	claimed, err := s.Repo.ClaimDelivery(ctx, deliveryID, ttl)
+	if err != nil {
+		return false, err
+	}
+	s.purgeDeliveries(ctx, ttl)
+	return claimed, nil
+}
+
+// ReleaseDelivery undoes ClaimDelivery for a push that was not accepted, so Pub/Sub's
+// redelivery of it is processed.
+func (s *GmailWatchService) ReleaseDelivery(ctx context.Context, deliveryID string) error {
+	if deliveryID == "" {
+		return nil
+	}
+	return s.Repo.ReleaseDelivery(ctx, deliveryID)
+}
+
+func (s *GmailWatchService) purgeDeliveries(ctx context.Context, ttl time.Duration) {
+	p := &s.purger
+	p.mu.Lock()
+	if time.Since(p.lastRun) < time.Hour {
+		p.mu.Unlock()
+		return
+	}
+	p.lastRun = time.Now()
+	p.mu.Unlock()
+
+	n, err := s.Repo.PurgeDeliveries(ctx, time.Now().Add(-ttl))
+	if err != nil {
+		log.Printf("Failed to purge expired Pub/Sub deliveries: %v", err)
+		return
+	}
+	if n > 0 {
+		log.Printf("Purged %d expired Pub/Sub deliveries", n)
+	}
+}
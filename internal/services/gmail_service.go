@@ -3,19 +3,33 @@ package services
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"net/mail"
+	"strings"
+	"time"
 
 	"gagarin-soft/internal/auth"
+	"gagarin-soft/internal/blob"
 	"gagarin-soft/internal/config"
 	"gagarin-soft/internal/gmail"
+	"gagarin-soft/internal/gmailquery"
 	"gagarin-soft/internal/storage"
+
+	gmailapi "google.golang.org/api/gmail/v1"
+	"google.golang.org/api/option"
 )
 
 type GmailWatchService struct {
 	Config      *config.Config
 	AuthManager auth.TokenManager
 	Repo        storage.HistoryRepository
+	// Blobs stores attachment content; attachments are not downloaded when nil.
+	Blobs blob.Store
+
+	purger deliveryPurger
+	labels labelCache
 }
 
 func NewGmailWatchService(cfg *config.Config, authMgr auth.TokenManager, repo storage.HistoryRepository) *GmailWatchService {
@@ -26,15 +40,94 @@ func NewGmailWatchService(cfg *config.Config, authMgr auth.TokenManager, repo st
 	}
 }
 
-func (s *GmailWatchService) Renew(ctx context.Context) ([]byte, error) {
-	// 1. Determine Topic
-	topicName := s.Config.GmailPubSubTopic
+// RenewResult is the outcome of renewing the watch of one registered mailbox.
+type RenewResult struct {
+	MailboxID    string `json:"mailboxId"`
+	EmailAddress string `json:"emailAddress"`
+	*gmail.WatchResponse
+	Error string `json:"error,omitempty"`
+}
+
+// RenewOptions override the configured watch settings for one renewal. Zero values keep
+// the mailbox's or the service's settings.
+type RenewOptions struct {
+	// EmailAddress limits the renewal to one mailbox.
+	EmailAddress string `json:"emailAddress,omitempty"`
+	TopicName    string `json:"topicName,omitempty"`
+	// Labels are label names or IDs.
+	Labels              []string `json:"labels,omitempty"`
+	LabelFilterBehavior string   `json:"labelFilterBehavior,omitempty"`
+}
+
+// Renew renews the watch of every active mailbox. Without registered mailboxes it renews
+// the legacy mailbox and returns its watch response as before.
+func (s *GmailWatchService) Renew(ctx context.Context, opts RenewOptions) ([]byte, error) {
+	if opts.EmailAddress != "" {
+		mb, err := s.mailboxFor(ctx, opts.EmailAddress)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := s.renewMailbox(ctx, mb, opts)
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(resp)
+	}
+
+	mailboxes, registered, err := s.activeMailboxes(ctx)
+	if err != nil {
+		log.Printf("Error listing mailboxes: %v", err)
+		return nil, fmt.Errorf("internal server error")
+	}
+
+	if !registered {
+		resp, err := s.renewMailbox(ctx, legacyMailbox(""), opts)
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(resp)
+	}
+
+	results := make([]RenewResult, 0, len(mailboxes))
+	failed := 0
+	for i := range mailboxes {
+		mb := &mailboxes[i]
+		result := RenewResult{MailboxID: mb.ID, EmailAddress: mb.EmailAddress}
+		resp, err := s.renewMailbox(ctx, mb, opts)
+		if err != nil {
+			failed++
+			result.Error = err.Error()
+		} else {
+			result.WatchResponse = resp
+		}
+		results = append(results, result)
+		reportProgress(ctx, i+1, len(mailboxes))
+	}
+	if failed > 0 && failed == len(mailboxes) {
+		return nil, fmt.Errorf("failed to renew watch for all %d mailboxes", failed)
+	}
+	return json.Marshal(results)
+}
+
+// RenewMailbox renews the Gmail watch of a single mailbox with its configured settings and
+// records the new expiration.
+func (s *GmailWatchService) RenewMailbox(ctx context.Context, mb *storage.Mailbox) (*gmail.WatchResponse, error) {
+	return s.renewMailbox(ctx, mb, RenewOptions{})
+}
+
+func (s *GmailWatchService) renewMailbox(ctx context.Context, mb *storage.Mailbox, opts RenewOptions) (*gmail.WatchResponse, error) {
+	// 1. Determine Topic and Labels
+	topicName := opts.TopicName
+	if topicName == "" {
+		topicName = s.Config.GmailPubSubTopic
+	}
 	if topicName == "" {
 		topicName = fmt.Sprintf("projects/%s/topics/gmail-hook-topic", s.Config.ProjectID)
 	}
+	labels, behavior := s.watchLabels(mb, opts)
 
 	// 2. Get Refresh Token
-	refreshToken, err := s.AuthManager.GetRefreshToken(ctx, "gmail-refresh-token")
+	refreshToken, err := s.AuthManager.GetRefreshToken(ctx, mb.RefreshTokenSecret)
 	if err != nil {
 		log.Printf("Error retrieving refresh token: %v", err)
 		return nil, fmt.Errorf("internal server error")
@@ -44,124 +137,432 @@ func (s *GmailWatchService) Renew(ctx context.Context) ([]byte, error) {
 	client := s.AuthManager.GetHTTPClient(ctx, refreshToken)
 
 	// 4. Create Gmail Client
-	gmailClient, err := gmail.NewClient(ctx, client)
+	gmailClient, err := gmail.NewClient(ctx, client, s.gmailOptions()...)
 	if err != nil {
 		log.Printf("Error creating Gmail client: %v", err)
 		return nil, fmt.Errorf("internal server error")
 	}
 
-	// 5. Call Renew Watch
-	log.Printf("Renewing watch for topic: %s", topicName)
-	resp, err := gmailClient.RenewWatch(topicName)
+	// 5. Resolve label names; Gmail only accepts IDs
+	labelIDs, err := gmailClient.ResolveLabelIDs(labels)
+	if err != nil {
+		log.Printf("Error resolving watch labels %v: %v", labels, err)
+		return nil, fmt.Errorf("failed to resolve watch labels: %w", err)
+	}
+
+	// 6. Call Renew Watch
+	log.Printf("Renewing watch for %s on topic: %s (%s %v)", mailboxName(mb), topicName, behavior, labelIDs)
+	resp, err := gmailClient.RenewWatch(gmail.WatchRequest{
+		TopicName:           topicName,
+		LabelIDs:            labelIDs,
+		LabelFilterBehavior: behavior,
+	})
 	if err != nil {
 		log.Printf("Error renewing watch: %v", err)
 		return nil, fmt.Errorf("failed to renew watch: %w", err)
 	}
 
-	// 6. Log & Save Results
-	log.Printf("Successfully renewed watch. HistoryID: %d, Expiration: %d", resp.HistoryId, resp.Expiration)
+	// 7. Log & Save Results
+	log.Printf("Successfully renewed watch for %s. HistoryID: %d, Expiration: %d", mailboxName(mb), resp.HistoryId, resp.Expiration)
 
-	if err := s.Repo.SaveWatchStatus(ctx, resp.HistoryId, resp.Expiration); err != nil {
+	if err := s.Repo.SaveWatchStatus(ctx, mb.ID, resp.HistoryId, resp.Expiration); err != nil {
 		log.Printf("Warning: Failed to save watch status: %v", err)
 	}
 
-	return json.Marshal(resp)
+	return resp, nil
 }
 
-func (s *GmailWatchService) ProcessPushNotification(ctx context.Context, startHistoryID uint64) error {
-	// 1. Get Authenticated Client
-	refreshToken, err := s.AuthManager.GetRefreshToken(ctx, "gmail-refresh-token")
+// watchLabels picks the watched labels and filter behavior: the request override first,
+// then the mailbox's own settings, then WATCH_LABELS / WATCH_LABEL_FILTER_BEHAVIOR.
+func (s *GmailWatchService) watchLabels(mb *storage.Mailbox, opts RenewOptions) ([]string, string) {
+	labels := opts.Labels
+	if len(labels) == 0 {
+		labels = mb.WatchLabels
+	}
+	if len(labels) == 0 {
+		labels = s.Config.WatchLabels
+	}
+
+	behavior := opts.LabelFilterBehavior
+	if behavior == "" {
+		behavior = mb.WatchLabelFilter
+	}
+	if behavior == "" {
+		behavior = s.Config.WatchLabelFilter
+	}
+	return labels, behavior
+}
+
+func mailboxName(mb *storage.Mailbox) string {
+	if mb.EmailAddress == "" {
+		return "default mailbox"
+	}
+	return mb.EmailAddress
+}
+
+// syncStats accumulates the per-run counters written to stats_daily.
+type syncStats struct {
+	Received int
+	Ok       int
+	Error    int
+}
+
+// syncRun carries the state shared by all messages of one incremental sync or resync.
+type syncRun struct {
+	mailbox    *storage.Mailbox
+	client     *gmail.Client
+	filters    []compiledFilter
+	labelNames map[string]string
+	writeback  map[string]string // outcome -> label ID applied after processing
+	stats      syncStats
+}
+
+// compiledFilter is an enabled filter with its Gmail query already parsed.
+type compiledFilter struct {
+	storage.Filter
+	query *gmailquery.Query
+}
+
+// gmailOptions points the Gmail client at GMAIL_ENDPOINT when it is set.
+func (s *GmailWatchService) gmailOptions() []option.ClientOption {
+	if s.Config.GmailEndpoint == "" {
+		return nil
+	}
+	return []option.ClientOption{option.WithEndpoint(s.Config.GmailEndpoint)}
+}
+
+func (s *GmailWatchService) newGmailClient(ctx context.Context, mb *storage.Mailbox) (*gmail.Client, error) {
+	refreshToken, err := s.AuthManager.GetRefreshToken(ctx, mb.RefreshTokenSecret)
 	if err != nil {
-		return fmt.Errorf("failed to get refresh token: %w", err)
+		return nil, fmt.Errorf("failed to get refresh token: %w", err)
 	}
 	client := s.AuthManager.GetHTTPClient(ctx, refreshToken)
-	gmailClient, err := gmail.NewClient(ctx, client)
+	gmailClient, err := gmail.NewClient(ctx, client, s.gmailOptions()...)
 	if err != nil {
-		return fmt.Errorf("failed to create gmail client: %w", err)
+		return nil, fmt.Errorf("failed to create gmail client: %w", err)
 	}
+	return gmailClient, nil
+}
 
-	// 2. List History
-	msgIDs, err := gmailClient.ListMessageIDs(startHistoryID)
+func (s *GmailWatchService) newSyncRun(ctx context.Context, mb *storage.Mailbox) (*syncRun, error) {
+	gmailClient, err := s.newGmailClient(ctx, mb)
 	if err != nil {
-		return fmt.Errorf("failed to list history: %w", err)
+		return nil, err
 	}
 
-	log.Printf("Found %d messages in history", len(msgIDs))
+	// Filters are reloaded for every run so admin edits apply without a restart.
+	filters, err := s.Repo.ListEnabledFilters(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load filters: %w", err)
+	}
 
-	// 3. Process Messages
-	targetLabel := s.Config.TargetGmailLabel
+	run := &syncRun{mailbox: mb, client: gmailClient}
+	needLabels := false
+	for _, f := range filters {
+		q, err := gmailquery.Parse(f.GmailQuery)
+		if err != nil {
+			log.Printf("Skipping filter %s (%s): invalid gmail_query: %v", f.Name, f.ID, err)
+			continue
+		}
+		run.filters = append(run.filters, compiledFilter{Filter: f, query: q})
+		needLabels = needLabels || q.Uses("label")
+	}
+
+	// label: compares against label names, while messages only carry label IDs.
+	if needLabels {
+		run.labelNames, err = gmailClient.LabelNames()
+		if err != nil {
+			return nil, err
+		}
+	}
+	s.loadWritebackLabels(run)
+	return run, nil
+}
 
-	statsReceived := len(msgIDs)
-	statsOk := 0
-	statsError := 0
+// ProcessPushNotification syncs the mailbox from its stored cursor up to pushHistoryID.
+// The cursor is only advanced once every message in the range has been committed, so a
+// failed run is retried in full by the next push (at-least-once processing).
+func (s *GmailWatchService) ProcessPushNotification(ctx context.Context, emailAddress string, pushHistoryID uint64) error {
+	// 1. Route the push to its mailbox
+	mb, err := s.mailboxFor(ctx, emailAddress)
+	if err != nil {
+		return err
+	}
 
-	for _, msgID := range msgIDs {
-		msg, err := gmailClient.GetMessage(msgID)
+	// 2. Determine where to start from
+	startHistoryID, err := s.Repo.GetSyncCursor(ctx, emailAddress)
+	if err != nil {
+		return fmt.Errorf("failed to load sync cursor: %w", err)
+	}
+	if startHistoryID == 0 {
+		// No checkpoint yet: start from the historyId returned by the last watch call.
+		startHistoryID, err = s.Repo.GetLatestWatchHistoryID(ctx, mb.ID)
 		if err != nil {
-			log.Printf("Failed to get message %s: %v", msgID, err)
-			statsError++
-			_ = s.Repo.RecordEvent(ctx, storage.Event{
-				MessageID: msgID,
-				Status:    "error",
-				Error:     fmt.Sprintf("Failed to get message: %v", err),
-			})
+			return fmt.Errorf("failed to load watch history: %w", err)
+		}
+	}
+	if startHistoryID == 0 {
+		log.Printf("No sync cursor for %s, starting from push historyId %d", emailAddress, pushHistoryID)
+		startHistoryID = pushHistoryID
+	} else if pushHistoryID <= startHistoryID {
+		log.Printf("Push historyId %d for %s is not newer than cursor %d, skipping", pushHistoryID, emailAddress, startHistoryID)
+		return nil
+	}
+
+	// 3. Get Authenticated Client and Filters
+	run, err := s.newSyncRun(ctx, mb)
+	if err != nil {
+		return err
+	}
+	gmailClient := run.client
+
+	// 4. List History
+	history, err := gmailClient.ListMessageIDs(startHistoryID)
+	if errors.Is(err, gmail.ErrHistoryTooOld) {
+		log.Printf("History %d for %s is no longer available, falling back to full resync", startHistoryID, emailAddress)
+		_, err := s.resync(ctx, run)
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("failed to list history: %w", err)
+	}
+
+	log.Printf("Found %d messages in history since %d", len(history), startHistoryID)
+
+	// 5. Process Messages
+	// committed stays true while every message is either saved or permanently unavailable
+	committed := true
+
+	for _, h := range history {
+		if !h.IsNew() && run.onlyWritebackLabels(h.LabelIDs) {
+			// The label change is our own writeback from an earlier run.
 			continue
 		}
+		if !h.IsNew() {
+			log.Printf("Message %s changed labels (%v), re-checking match", h.ID, h.Types)
+		}
+		if !s.processMessage(ctx, run, h.ID, processOptions{trigger: triggerPush, change: &h}) {
+			committed = false
+		}
+	}
 
-		matched := false
-		if targetLabel != "" {
-			for _, label := range msg.LabelIds {
-				if label == targetLabel {
-					matched = true
-					break
-				}
-			}
-		} else {
-			matched = true
+	s.updateDailyStats(ctx, run)
+
+	// 6. Advance the cursor
+	if !committed {
+		return fmt.Errorf("sync from %d to %d incomplete, cursor not advanced", startHistoryID, pushHistoryID)
+	}
+	if err := s.Repo.SaveSyncCursor(ctx, emailAddress, pushHistoryID); err != nil {
+		return fmt.Errorf("failed to save sync cursor: %w", err)
+	}
+
+	return nil
+}
+
+// processOptions tells processMessage why the message is processed. Messages processed again
+// on request rather than because they showed up in the history are treated differently.
+type processOptions struct {
+	// trigger is what brought the message up: triggerPush, triggerResync or triggerReprocess.
+	trigger string
+	// change is the history entry of the message, for pushes.
+	change *gmail.HistoryMessage
+	// overwrite rewrites the stored row even when nothing about the match changed.
+	overwrite bool
+	// retryOf is the earlier event of the message; new events link to it.
+	retryOf string
+}
+
+// processMessage fetches a single message, matches it and saves it when it matches.
+// It returns false when the message has to be retried later.
+func (s *GmailWatchService) processMessage(ctx context.Context, run *syncRun, msgID string, opts processOptions) bool {
+	stats := &run.stats
+	t := newTrace(run, msgID, opts)
+
+	msg, err := run.client.GetMessage(msgID)
+	if err != nil {
+		log.Printf("Failed to get message %s: %v", msgID, err)
+		stats.Error++
+		s.record(ctx, t, storage.EventError, fmt.Sprintf("Failed to get message: %v", err))
+		// A deleted message will never come back; anything else is retried.
+		return gmail.IsNotFound(err)
+	}
+	fetched := &storage.EventDetails{Trigger: opts.trigger}
+	if opts.change != nil {
+		for _, typ := range opts.change.Types {
+			fetched.HistoryTypes = append(fetched.HistoryTypes, string(typ))
 		}
+		fetched.LabelIDs = opts.change.LabelIDs
+	}
+	t.step(storage.EventFetched, fetched, nil)
 
-		if matched {
-			log.Printf("Message %s matched label %s. Saving...", msgID, targetLabel)
+	// Decoding only enriches matching with the body text; a malformed MIME tree is not fatal.
+	decoded, decodeErr := gmail.Decode(msg)
+	if decodeErr != nil {
+		log.Printf("Failed to decode message %s: %v", msgID, decodeErr)
+		decodeErr = fmt.Errorf("matched without the body: %w", decodeErr)
+	}
 
-			// Save using old logic
-			processed := storage.ProcessedEmail{
-				MessageID: msg.Id,
-				HistoryID: msg.HistoryId,
-				LabelIDs:  fmt.Sprintf("%v", msg.LabelIds),
-				Snippet:   msg.Snippet,
-			}
-			if err := s.Repo.SaveProcessedEmail(ctx, processed); err != nil {
-				log.Printf("Failed to save processed email: %v", err)
-				statsError++
-				_ = s.Repo.RecordEvent(ctx, storage.Event{
-					MessageID: msg.Id,
-					Status:    "error",
-					Error:     fmt.Sprintf("Failed to save to db: %v", err),
-				})
-			} else {
-				statsOk++
-				// Record Success Event for Admin Dashboard
-				_ = s.Repo.RecordEvent(ctx, storage.Event{
-					MessageID: msg.Id,
-					Status:    "processed",
-					// Assuming we can't get Filter ID easily unless we re-fetch filters.
-					// For now, leave FilterID empty or infer from label name if needed.
-				})
+	filterID, matched := s.matchMessage(run, msg, decoded)
+	if !matched {
+		t.step(storage.EventUnmatched, s.missDetails(run), decodeErr)
+		s.writeback(run, t, msg, outcomeIgnored)
+		// Only a reprocessed message gets an outcome: its earlier attempt needs an answer.
+		// Otherwise the steps alone tell why the message was passed over, and only when that
+		// is news: label changes and replays of a known miss leave no events, like replays of
+		// a saved message.
+		if opts.retryOf != "" {
+			s.record(ctx, t, storage.EventIgnored, "")
+			return true
+		}
+		latest, err := s.Repo.LatestDecision(ctx, msgID)
+		if err != nil {
+			log.Printf("Failed to load the last decision on %s: %v", msgID, err)
+		}
+		if err == nil && (latest == storage.EventUnmatched || latest == storage.EventIgnored) {
+			return true
+		}
+		s.record(ctx, t, "", "")
+		return true
+	}
+	t.event.FilterID = filterID
+	t.step(storage.EventMatched, &storage.EventDetails{Filter: run.filterName(filterID)}, decodeErr)
+
+	log.Printf("Message %s matched (filter %q). Saving...", msgID, filterID)
+
+	// Attachment content goes to the blob store before the message is recorded, so a failed
+	// upload leaves the message unprocessed and it is retried as a whole.
+	attachments, err := s.storeAttachments(ctx, run.client, decoded)
+	if err != nil {
+		log.Printf("Failed to store attachments of %s: %v", msgID, err)
+		stats.Error++
+		s.writeback(run, t, msg, outcomeError)
+		s.record(ctx, t, storage.EventError, fmt.Sprintf("Failed to store attachments: %v", err))
+		return false
+	}
+
+	processed := &storage.ProcessedEmail{
+		MailboxID:    run.mailbox.ID,
+		MessageID:    msg.Id,
+		ThreadID:     msg.ThreadId,
+		HistoryID:    msg.HistoryId,
+		LabelIDs:     run.withoutWritebackLabels(msg.LabelIds),
+		SizeEstimate: msg.SizeEstimate,
+		Snippet:      msg.Snippet,
+		FilterID:     filterID,
+	}
+	if msg.InternalDate > 0 {
+		internalDate := time.UnixMilli(msg.InternalDate)
+		processed.InternalDate = &internalDate
+	}
+	if decoded != nil {
+		processed.FromAddress = fromAddress(decoded.Headers.From)
+		processed.Subject = decoded.Headers.Subject
+	}
+	result, err := s.Repo.SaveProcessedEmail(ctx, processed, opts.overwrite)
+	if err != nil {
+		log.Printf("Failed to save processed email: %v", err)
+		stats.Error++
+		s.writeback(run, t, msg, outcomeError)
+		s.record(ctx, t, storage.EventError, fmt.Sprintf("Failed to save to db: %v", err))
+		return false
+	}
+
+	// The content is already in the blob store; a failed metadata row is noted on the step,
+	// as are attachments that could not be found in an undecodable message.
+	var metaErrs []error
+	if decoded == nil && s.Blobs != nil {
+		metaErrs = append(metaErrs, errors.New("attachments not stored: the message could not be decoded"))
+	}
+	for _, a := range attachments {
+		a.ProcessedEmailID = processed.ID
+		if err := s.Repo.SaveAttachment(ctx, a); err != nil {
+			log.Printf("Failed to save attachment metadata for %s: %v", msgID, err)
+			metaErrs = append(metaErrs, fmt.Errorf("failed to save attachment %q: %w", a.Filename, err))
+		}
+	}
+	t.step(storage.EventSaved, &storage.EventDetails{Result: result.String(), Attachments: len(attachments)}, errors.Join(metaErrs...))
+
+	s.writeback(run, t, msg, outcomeProcessed)
+
+	// Replays of an already recorded message leave events and stats untouched.
+	switch result {
+	case storage.SaveUnchanged:
+		log.Printf("Message %s already processed, nothing changed", msgID)
+		return true
+	case storage.SaveCreated:
+		stats.Received++
+	}
+	stats.Ok++
+	s.record(ctx, t, storage.EventProcessed, "")
+	return true
+}
+
+// missDetails explains why matchMessage passed a message over.
+func (s *GmailWatchService) missDetails(run *syncRun) *storage.EventDetails {
+	if len(run.filters) == 0 {
+		return &storage.EventDetails{Reason: fmt.Sprintf("no filters enabled and the message lacks label %s", s.Config.TargetGmailLabel)}
+	}
+	details := &storage.EventDetails{Reason: "no enabled filter matched"}
+	for _, f := range run.filters {
+		details.FiltersChecked = append(details.FiltersChecked, f.Name)
+	}
+	return details
+}
+
+// filterName returns the name of an enabled filter, or "" for the TargetGmailLabel fallback.
+func (run *syncRun) filterName(id string) string {
+	for _, f := range run.filters {
+		if f.ID == id {
+			return f.Name
+		}
+	}
+	return ""
+}
+
+// fromAddress extracts the bare address from a From header, keeping the header as-is when
+// it doesn't parse.
+func fromAddress(from string) string {
+	if addr, err := mail.ParseAddress(from); err == nil {
+		return strings.ToLower(addr.Address)
+	}
+	return from
+}
+
+// matchMessage picks the first enabled filter (in priority order) whose Gmail query matches
+// the message. Without any enabled filters it falls back to TargetGmailLabel, in which case
+// the returned filter ID is empty.
+func (s *GmailWatchService) matchMessage(run *syncRun, msg *gmailapi.Message, decoded *gmail.DecodedMessage) (string, bool) {
+	if len(run.filters) == 0 {
+		targetLabel := s.Config.TargetGmailLabel
+		if targetLabel == "" {
+			return "", true
+		}
+		for _, label := range msg.LabelIds {
+			if label == targetLabel {
+				return "", true
 			}
-		} else {
-			// Message didn't match, maybe log as filtered?
-			// Existing logic just ignores it.
-			// Admin dashboard might want to know about ignored messages too?
-			// For now, stick to processed ones to avoid spamming events.
 		}
+		return "", false
 	}
 
-	// Update Daily Stats
-	if statsReceived > 0 || statsOk > 0 || statsError > 0 {
-		if err := s.Repo.UpdateDailyStats(ctx, statsReceived, statsOk, statsError); err != nil {
-			log.Printf("Failed to update daily stats: %v", err)
+	m := gmailquery.FromGmail(msg, run.labelNames)
+	if decoded != nil && decoded.TextBody != "" {
+		m.Text += "\n" + decoded.TextBody
+	}
+	for _, f := range run.filters {
+		if f.query.Match(m) {
+			return f.ID, true
 		}
 	}
+	return "", false
+}
 
-	return nil
+func (s *GmailWatchService) updateDailyStats(ctx context.Context, run *syncRun) {
+	stats := run.stats
+	if stats.Received == 0 && stats.Ok == 0 && stats.Error == 0 {
+		return
+	}
+	if err := s.Repo.UpdateDailyStats(ctx, run.mailbox.ID, stats.Received, stats.Ok, stats.Error); err != nil {
+		log.Printf("Failed to update daily stats: %v", err)
+	}
 }
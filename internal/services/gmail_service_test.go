@@ -4,21 +4,31 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"io"
 	"net/http"
+	"reflect"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 
+	"gagarin-soft/internal/blob"
 	"gagarin-soft/internal/config"
+	"gagarin-soft/internal/gmailtest"
 	"gagarin-soft/internal/services"
+	"gagarin-soft/internal/storage"
 	"gagarin-soft/internal/storage/mocks"
 )
 
 // MockTokenManager implements auth.TokenManager
 type MockTokenManager struct {
-	Client *http.Client
+	Client  *http.Client
+	Secrets []string
 }
 
 func (m *MockTokenManager) GetRefreshToken(ctx context.Context, secretName string) (string, error) {
+	m.Secrets = append(m.Secrets, secretName)
 	return "mock-refresh-token", nil
 }
 
@@ -39,6 +49,17 @@ func (m *MockTransport) RoundTrip(req *http.Request) (*http.Response, error) {
 	return m.RoundTripFunc(req)
 }
 
+// outcomes drops the step events, leaving one event per processing attempt.
+func outcomes(events []storage.Event) []storage.Event {
+	var out []storage.Event
+	for _, e := range events {
+		if storage.IsOutcome(e.Status) {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
 func TestGmailWatchService_Renew(t *testing.T) {
 	// 1. Setup Config
 	cfg := &config.Config{
@@ -79,7 +100,7 @@ func TestGmailWatchService_Renew(t *testing.T) {
 
 	// 6. Execute
 	ctx := context.Background()
-	result, err := service.Renew(ctx)
+	result, err := service.Renew(ctx, services.RenewOptions{})
 
 	// 7. Verify
 	if err != nil {
@@ -106,3 +127,865 @@ func TestGmailWatchService_Renew(t *testing.T) {
 		}
 	}
 }
+
+func TestGmailWatchService_RenewsEveryActiveMailbox(t *testing.T) {
+	mockRepo := mocks.NewMockHistoryRepository()
+	mockRepo.Mailboxes = []storage.Mailbox{
+		{ID: "mb-1", EmailAddress: "shop@example.com", RefreshTokenSecret: "shop-token", WatchLabels: storage.StringArray{"Receipts", "INBOX"}, WatchLabelFilter: "exclude", Status: storage.MailboxActive},
+		{ID: "mb-2", EmailAddress: "old@example.com", RefreshTokenSecret: "old-token", Status: storage.MailboxDisabled},
+	}
+
+	transport := &MockTransport{
+		RoundTripFunc: func(req *http.Request) (*http.Response, error) {
+			if req.URL.Path == "/gmail/v1/users/me/labels" {
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					Body:       io.NopCloser(bytes.NewBufferString(`{"labels": [{"id": "Label_7", "name": "receipts"}]}`)),
+					Header:     make(http.Header),
+				}, nil
+			}
+			var body struct {
+				LabelIds            []string `json:"labelIds"`
+				LabelFilterBehavior string   `json:"labelFilterBehavior"`
+			}
+			json.NewDecoder(req.Body).Decode(&body)
+			if strings.Join(body.LabelIds, ",") != "Label_7,INBOX" || body.LabelFilterBehavior != "exclude" {
+				t.Errorf("Expected exclude watch on Label_7,INBOX, got %s %v", body.LabelFilterBehavior, body.LabelIds)
+			}
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(bytes.NewBufferString(`{"historyId": "12345", "expiration": "1700000000000"}`)),
+				Header:     make(http.Header),
+			}, nil
+		},
+	}
+	mockAuth := &MockTokenManager{Client: &http.Client{Transport: transport}}
+	service := services.NewGmailWatchService(&config.Config{ProjectID: "test-project"}, mockAuth, mockRepo)
+
+	result, err := service.Renew(context.Background(), services.RenewOptions{})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	var results []services.RenewResult
+	if err := json.Unmarshal(result, &results); err != nil {
+		t.Fatalf("Failed to parse result json: %v", err)
+	}
+	if len(results) != 1 || results[0].MailboxID != "mb-1" || results[0].Error != "" {
+		t.Errorf("Expected a single successful renewal of mb-1, got %+v", results)
+	}
+	if len(mockRepo.SavedHistory) != 1 || mockRepo.SavedHistory[0].MailboxID != "mb-1" {
+		t.Errorf("Expected watch status saved for mb-1, got %+v", mockRepo.SavedHistory)
+	}
+}
+
+func TestGmailWatchService_RenewUsesConfigForMailboxWithoutLabels(t *testing.T) {
+	mockRepo := mocks.NewMockHistoryRepository()
+	mockRepo.Mailboxes = []storage.Mailbox{
+		{ID: "mb-1", EmailAddress: "shop@example.com", RefreshTokenSecret: "shop-token", WatchLabels: storage.StringArray{}, Status: storage.MailboxActive},
+	}
+
+	var gotLabels, gotBehavior string
+	transport := &MockTransport{
+		RoundTripFunc: func(req *http.Request) (*http.Response, error) {
+			var body struct {
+				LabelIds            []string `json:"labelIds"`
+				LabelFilterBehavior string   `json:"labelFilterBehavior"`
+			}
+			json.NewDecoder(req.Body).Decode(&body)
+			gotLabels, gotBehavior = strings.Join(body.LabelIds, ","), body.LabelFilterBehavior
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(bytes.NewBufferString(`{"historyId": "12345", "expiration": "1700000000000"}`)),
+				Header:     make(http.Header),
+			}, nil
+		},
+	}
+	cfg := &config.Config{ProjectID: "test-project", WatchLabels: []string{"SPAM", "TRASH"}, WatchLabelFilter: "exclude"}
+	service := services.NewGmailWatchService(cfg, &MockTokenManager{Client: &http.Client{Transport: transport}}, mockRepo)
+
+	if _, err := service.Renew(context.Background(), services.RenewOptions{}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if gotLabels != "SPAM,TRASH" || gotBehavior != "exclude" {
+		t.Errorf("Expected the configured exclude watch on SPAM,TRASH, got %s %s", gotBehavior, gotLabels)
+	}
+}
+
+func newPushTransport(t *testing.T, wantStart string) *MockTransport {
+	return &MockTransport{
+		RoundTripFunc: func(req *http.Request) (*http.Response, error) {
+			var respBody string
+			switch req.URL.Path {
+			case "/gmail/v1/users/me/history":
+				if got := req.URL.Query().Get("startHistoryId"); got != wantStart {
+					t.Errorf("Expected startHistoryId %s, got %s", wantStart, got)
+				}
+				respBody = `{"history": [{"id": "120", "messagesAdded": [{"message": {"id": "m1"}}]}], "historyId": "150"}`
+			case "/gmail/v1/users/me/messages/m1":
+				respBody = `{"id": "m1", "threadId": "t1", "historyId": "120", "labelIds": ["INBOX", "UNREAD"], "snippet": "hello",
+					"internalDate": "1767225600000", "sizeEstimate": 2048, "payload": {"mimeType": "text/plain", "headers": [
+					{"name": "From", "value": "Shop <Receipts@Shop.example>"}, {"name": "Subject", "value": "Your receipt"}]}}`
+			default:
+				return &http.Response{
+					StatusCode: http.StatusNotFound,
+					Body:       io.NopCloser(bytes.NewBufferString("Not Found")),
+				}, nil
+			}
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(bytes.NewBufferString(respBody)),
+				Header:     make(http.Header),
+			}, nil
+		},
+	}
+}
+
+func TestGmailWatchService_ProcessPushNotification_SyncsFromCursor(t *testing.T) {
+	mockRepo := mocks.NewMockHistoryRepository()
+	mockRepo.Cursors["shop@example.com"] = 100
+
+	mockAuth := &MockTokenManager{Client: &http.Client{Transport: newPushTransport(t, "100")}}
+	service := services.NewGmailWatchService(&config.Config{}, mockAuth, mockRepo)
+
+	if err := service.ProcessPushNotification(context.Background(), "shop@example.com", 150); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if len(mockRepo.SavedEmails) != 1 || mockRepo.SavedEmails[0].MessageID != "m1" {
+		t.Fatalf("Expected message m1 to be saved, got %+v", mockRepo.SavedEmails)
+	}
+	saved := mockRepo.SavedEmails[0]
+	if saved.ThreadID != "t1" || saved.FromAddress != "receipts@shop.example" || saved.Subject != "Your receipt" ||
+		saved.SizeEstimate != 2048 || len(saved.LabelIDs) != 2 || saved.LabelIDs[1] != "UNREAD" {
+		t.Errorf("Expected typed message details to be saved, got %+v", saved)
+	}
+	if saved.InternalDate == nil || !saved.InternalDate.Equal(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected internal date 2026-01-01, got %v", saved.InternalDate)
+	}
+	if got := mockRepo.Cursors["shop@example.com"]; got != 150 {
+		t.Errorf("Expected cursor to advance to 150, got %d", got)
+	}
+}
+
+func TestGmailWatchService_ProcessPushNotification_RoutesByMailbox(t *testing.T) {
+	mockRepo := mocks.NewMockHistoryRepository()
+	mockRepo.Mailboxes = []storage.Mailbox{
+		{ID: "mb-1", EmailAddress: "shop@example.com", RefreshTokenSecret: "shop-token", Status: storage.MailboxActive},
+		{ID: "mb-2", EmailAddress: "cafe@example.com", RefreshTokenSecret: "cafe-token", Status: storage.MailboxActive},
+	}
+	mockRepo.Cursors["cafe@example.com"] = 100
+
+	mockAuth := &MockTokenManager{Client: &http.Client{Transport: newPushTransport(t, "100")}}
+	service := services.NewGmailWatchService(&config.Config{}, mockAuth, mockRepo)
+
+	if err := service.ProcessPushNotification(context.Background(), "cafe@example.com", 150); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if len(mockAuth.Secrets) != 1 || mockAuth.Secrets[0] != "cafe-token" {
+		t.Errorf("Expected the cafe-token secret to be used, got %v", mockAuth.Secrets)
+	}
+	if len(mockRepo.SavedEmails) != 1 || mockRepo.SavedEmails[0].MailboxID != "mb-2" {
+		t.Errorf("Expected message saved for mailbox mb-2, got %+v", mockRepo.SavedEmails)
+	}
+	for _, e := range mockRepo.Events {
+		if e.MailboxID != "mb-2" {
+			t.Errorf("Expected event for mailbox mb-2, got %+v", e)
+		}
+	}
+	if len(mockRepo.Stats) != 1 || mockRepo.Stats[0].MailboxID != "mb-2" {
+		t.Errorf("Expected stats for mailbox mb-2, got %+v", mockRepo.Stats)
+	}
+}
+
+func TestGmailWatchService_ProcessPushNotification_SharesCursorAcrossAddressCase(t *testing.T) {
+	mockRepo := mocks.NewMockHistoryRepository()
+	mockRepo.Mailboxes = []storage.Mailbox{
+		{ID: "mb-1", EmailAddress: "Shop@Example.com", RefreshTokenSecret: "shop-token", Status: storage.MailboxActive},
+	}
+	// Saved by a resync, under the registry address.
+	if err := mockRepo.SaveSyncCursor(context.Background(), "Shop@Example.com", 100); err != nil {
+		t.Fatal(err)
+	}
+
+	mockAuth := &MockTokenManager{Client: &http.Client{Transport: newPushTransport(t, "100")}}
+	service := services.NewGmailWatchService(&config.Config{}, mockAuth, mockRepo)
+
+	if err := service.ProcessPushNotification(context.Background(), "shop@example.com", 150); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	got, _ := mockRepo.GetSyncCursor(context.Background(), "SHOP@example.com")
+	if got != 150 || len(mockRepo.Cursors) != 1 {
+		t.Errorf("Expected one cursor advanced to 150, got %v", mockRepo.Cursors)
+	}
+}
+
+func TestGmailWatchService_ProcessPushNotification_RejectsUnknownMailbox(t *testing.T) {
+	mockRepo := mocks.NewMockHistoryRepository()
+	mockRepo.Mailboxes = []storage.Mailbox{
+		{ID: "mb-1", EmailAddress: "shop@example.com", RefreshTokenSecret: "shop-token", Status: storage.MailboxActive},
+		{ID: "mb-2", EmailAddress: "old@example.com", RefreshTokenSecret: "old-token", Status: storage.MailboxDisabled},
+	}
+
+	transport := &MockTransport{
+		RoundTripFunc: func(req *http.Request) (*http.Response, error) {
+			t.Fatalf("Unexpected Gmail call: %s", req.URL.Path)
+			return nil, nil
+		},
+	}
+	mockAuth := &MockTokenManager{Client: &http.Client{Transport: transport}}
+	service := services.NewGmailWatchService(&config.Config{}, mockAuth, mockRepo)
+
+	err := service.ProcessPushNotification(context.Background(), "stranger@example.com", 150)
+	if !errors.Is(err, services.ErrUnknownMailbox) {
+		t.Errorf("Expected ErrUnknownMailbox, got %v", err)
+	}
+	err = service.ProcessPushNotification(context.Background(), "old@example.com", 150)
+	if !errors.Is(err, services.ErrMailboxDisabled) {
+		t.Errorf("Expected ErrMailboxDisabled, got %v", err)
+	}
+}
+
+func TestGmailWatchService_ProcessPushNotification_ReplayIsNoOp(t *testing.T) {
+	mockRepo := mocks.NewMockHistoryRepository()
+	mockAuth := &MockTokenManager{Client: &http.Client{Transport: newPushTransport(t, "100")}}
+	service := services.NewGmailWatchService(&config.Config{}, mockAuth, mockRepo)
+
+	for i := 0; i < 2; i++ {
+		// Rewind the cursor as if the first run's checkpoint had been lost.
+		mockRepo.Cursors["shop@example.com"] = 100
+		if err := service.ProcessPushNotification(context.Background(), "shop@example.com", 150); err != nil {
+			t.Fatalf("Run %d: expected no error, got %v", i+1, err)
+		}
+	}
+
+	if len(mockRepo.SavedEmails) != 1 {
+		t.Errorf("Expected 1 saved email, got %d", len(mockRepo.SavedEmails))
+	}
+	if got := outcomes(mockRepo.Events); len(got) != 1 || got[0].Status != "processed" {
+		t.Errorf("Expected a single processed event, got %+v", got)
+	}
+	// fetched, matched and saved of the first run; the replay changed nothing.
+	if len(mockRepo.Events) != 4 {
+		t.Errorf("Expected the replay to record no events, got %+v", mockRepo.Events)
+	}
+	if len(mockRepo.Stats) != 1 || mockRepo.Stats[0].Received != 1 || mockRepo.Stats[0].ProcessedOk != 1 {
+		t.Errorf("Expected stats to be counted once, got %+v", mockRepo.Stats)
+	}
+}
+
+func TestGmailWatchService_ClaimDelivery(t *testing.T) {
+	mockRepo := mocks.NewMockHistoryRepository()
+	service := services.NewGmailWatchService(&config.Config{}, &MockTokenManager{}, mockRepo)
+	ctx := context.Background()
+
+	if ok, err := service.ClaimDelivery(ctx, "d1"); err != nil || !ok {
+		t.Fatalf("Expected first delivery to be claimed, got %v, %v", ok, err)
+	}
+	if ok, _ := service.ClaimDelivery(ctx, "d1"); ok {
+		t.Error("Expected redelivery to be rejected")
+	}
+	if err := service.ReleaseDelivery(ctx, "d1"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := service.ClaimDelivery(ctx, "d1"); !ok {
+		t.Error("Expected released delivery to be claimed again")
+	}
+	if ok, _ := service.ClaimDelivery(ctx, ""); !ok {
+		t.Error("Expected deliveries without an ID to always be processed")
+	}
+}
+
+func TestGmailWatchService_ProcessPushNotification_KeepsCursorOnSaveFailure(t *testing.T) {
+	mockRepo := mocks.NewMockHistoryRepository()
+	mockRepo.Cursors["shop@example.com"] = 100
+	mockRepo.SaveEmailErr = errors.New("db down")
+
+	mockAuth := &MockTokenManager{Client: &http.Client{Transport: newPushTransport(t, "100")}}
+	service := services.NewGmailWatchService(&config.Config{}, mockAuth, mockRepo)
+
+	if err := service.ProcessPushNotification(context.Background(), "shop@example.com", 150); err == nil {
+		t.Fatal("Expected an error when the message could not be committed")
+	}
+	if got := mockRepo.Cursors["shop@example.com"]; got != 100 {
+		t.Errorf("Expected cursor to stay at 100, got %d", got)
+	}
+}
+
+func TestGmailWatchService_ProcessPushNotification_SkipsStalePush(t *testing.T) {
+	mockRepo := mocks.NewMockHistoryRepository()
+	mockRepo.Cursors["shop@example.com"] = 200
+
+	transport := &MockTransport{
+		RoundTripFunc: func(req *http.Request) (*http.Response, error) {
+			t.Fatalf("Unexpected Gmail call: %s", req.URL.Path)
+			return nil, nil
+		},
+	}
+	mockAuth := &MockTokenManager{Client: &http.Client{Transport: transport}}
+	service := services.NewGmailWatchService(&config.Config{}, mockAuth, mockRepo)
+
+	if err := service.ProcessPushNotification(context.Background(), "shop@example.com", 150); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+}
+
+func TestGmailWatchService_ProcessPushNotification_ResyncsWhenHistoryTooOld(t *testing.T) {
+	mockRepo := mocks.NewMockHistoryRepository()
+	mockRepo.Cursors["shop@example.com"] = 100
+	mockRepo.SavedEmails = append(mockRepo.SavedEmails, storage.ProcessedEmail{MessageID: "m2"})
+
+	var fetched []string
+	transport := &MockTransport{
+		RoundTripFunc: func(req *http.Request) (*http.Response, error) {
+			var respBody string
+			switch req.URL.Path {
+			case "/gmail/v1/users/me/history":
+				return &http.Response{
+					StatusCode: http.StatusNotFound,
+					Body:       io.NopCloser(bytes.NewBufferString(`{"error": {"code": 404, "message": "Requested entity was not found."}}`)),
+					Header:     make(http.Header),
+				}, nil
+			case "/gmail/v1/users/me/profile":
+				respBody = `{"emailAddress": "shop@example.com", "historyId": "500"}`
+			case "/gmail/v1/users/me/messages":
+				if q := req.URL.Query().Get("q"); !strings.Contains(q, "after:") {
+					t.Errorf("Expected lookback window in query, got %q", q)
+				}
+				respBody = `{"messages": [{"id": "m1"}, {"id": "m2"}]}`
+			default:
+				fetched = append(fetched, req.URL.Path)
+				respBody = `{"id": "m1", "historyId": "480", "labelIds": ["INBOX"]}`
+			}
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(bytes.NewBufferString(respBody)),
+				Header:     make(http.Header),
+			}, nil
+		},
+	}
+
+	cfg := &config.Config{ResyncLookbackDays: 7, ResyncMaxMessages: 50}
+	mockAuth := &MockTokenManager{Client: &http.Client{Transport: transport}}
+	service := services.NewGmailWatchService(cfg, mockAuth, mockRepo)
+
+	if err := service.ProcessPushNotification(context.Background(), "shop@example.com", 150); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if len(fetched) != 1 || fetched[0] != "/gmail/v1/users/me/messages/m1" {
+		t.Errorf("Expected only m1 to be fetched, got %v", fetched)
+	}
+	if got := mockRepo.Cursors["shop@example.com"]; got != 500 {
+		t.Errorf("Expected cursor to be reset to 500, got %d", got)
+	}
+}
+
+func TestGmailWatchService_ProcessPushNotification_RecordsMatchedFilter(t *testing.T) {
+	mockRepo := mocks.NewMockHistoryRepository()
+	mockRepo.Cursors["shop@example.com"] = 100
+	mockRepo.Filters = []storage.Filter{
+		{ID: "f-receipts", Name: "Receipts", Enabled: true, Priority: 20, GmailQuery: "subject:receipt label:pos-receipts"},
+		{ID: "f-orders", Name: "Orders", Enabled: true, Priority: 10, GmailQuery: "from:orders@pos.example"},
+		{ID: "f-broken", Name: "Broken", Enabled: true, Priority: 5, GmailQuery: "subject:(receipt"},
+		{ID: "f-off", Name: "Disabled", Enabled: false, Priority: 1, GmailQuery: "in:anywhere"},
+	}
+
+	transport := &MockTransport{
+		RoundTripFunc: func(req *http.Request) (*http.Response, error) {
+			var respBody string
+			switch req.URL.Path {
+			case "/gmail/v1/users/me/history":
+				respBody = `{"history": [{"id": "120", "messagesAdded": [{"message": {"id": "m1"}}]}]}`
+			case "/gmail/v1/users/me/labels":
+				respBody = `{"labels": [{"id": "Label_7", "name": "POS/Receipts"}]}`
+			case "/gmail/v1/users/me/messages/m1":
+				respBody = `{"id": "m1", "historyId": "120", "labelIds": ["INBOX", "Label_7"],
+					"payload": {"headers": [
+						{"name": "From", "value": "billing@pos.example"},
+						{"name": "Subject", "value": "Your receipt"}
+					]}}`
+			default:
+				t.Fatalf("Unexpected Gmail call: %s", req.URL.Path)
+			}
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(bytes.NewBufferString(respBody)),
+				Header:     make(http.Header),
+			}, nil
+		},
+	}
+
+	mockAuth := &MockTokenManager{Client: &http.Client{Transport: transport}}
+	service := services.NewGmailWatchService(&config.Config{}, mockAuth, mockRepo)
+
+	if err := service.ProcessPushNotification(context.Background(), "shop@example.com", 150); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if len(mockRepo.SavedEmails) != 1 || mockRepo.SavedEmails[0].FilterID != "f-receipts" {
+		t.Errorf("Expected processed email with filter f-receipts, got %+v", mockRepo.SavedEmails)
+	}
+	if got := outcomes(mockRepo.Events); len(got) != 1 || got[0].FilterID != "f-receipts" {
+		t.Errorf("Expected processed event with filter f-receipts, got %+v", got)
+	}
+}
+
+func TestGmailWatchService_ProcessPushNotification_StoresAttachments(t *testing.T) {
+	mockRepo := mocks.NewMockHistoryRepository()
+	mockRepo.Cursors["shop@example.com"] = 100
+
+	transport := &MockTransport{
+		RoundTripFunc: func(req *http.Request) (*http.Response, error) {
+			var respBody string
+			switch req.URL.Path {
+			case "/gmail/v1/users/me/history":
+				respBody = `{"history": [{"id": "120", "messagesAdded": [{"message": {"id": "m1"}}]}]}`
+			case "/gmail/v1/users/me/messages/m1":
+				respBody = `{"id": "m1", "historyId": "120", "labelIds": ["INBOX"], "payload": {
+					"mimeType": "multipart/mixed",
+					"parts": [
+						{"partId": "0", "mimeType": "text/plain", "body": {"data": "VG90YWw6IDEw"}},
+						{"partId": "1", "mimeType": "application/pdf", "filename": "receipt.pdf",
+						 "body": {"attachmentId": "att-1", "size": 9}}
+					]}}`
+			case "/gmail/v1/users/me/messages/m1/attachments/att-1":
+				respBody = `{"data": "JVBERi0xLjQK", "size": 9}`
+			default:
+				t.Fatalf("Unexpected Gmail call: %s", req.URL.Path)
+			}
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(bytes.NewBufferString(respBody)),
+				Header:     make(http.Header),
+			}, nil
+		},
+	}
+
+	blobs, err := blob.NewLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewLocalStore: %v", err)
+	}
+	mockAuth := &MockTokenManager{Client: &http.Client{Transport: transport}}
+	service := services.NewGmailWatchService(&config.Config{}, mockAuth, mockRepo)
+	service.Blobs = blobs
+
+	if err := service.ProcessPushNotification(context.Background(), "shop@example.com", 150); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if len(mockRepo.Attachments) != 1 {
+		t.Fatalf("Expected 1 attachment record, got %+v", mockRepo.Attachments)
+	}
+	att := mockRepo.Attachments[0]
+	if att.Filename != "receipt.pdf" || att.MimeType != "application/pdf" || att.Size != 9 || att.ProcessedEmailID != 1 {
+		t.Errorf("Unexpected attachment metadata %+v", att)
+	}
+	data, err := blobs.Get(context.Background(), att.StorageKey)
+	if err != nil || string(data) != "%PDF-1.4\n" {
+		t.Errorf("Expected stored PDF content, got %q (%v)", data, err)
+	}
+}
+
+func TestGmailWatchService_ProcessPushNotification_ReusesStoredAttachments(t *testing.T) {
+	mockRepo := mocks.NewMockHistoryRepository()
+	mockRepo.Cursors["shop@example.com"] = 100
+	stored := storage.Attachment{ProcessedEmailID: 1, MessageID: "m1", Filename: "receipt.pdf", MimeType: "application/pdf", Size: 9, SHA256: "abc", StorageKey: "attachments/ab/abc"}
+	mockRepo.Attachments = []storage.Attachment{stored}
+
+	transport := &MockTransport{
+		RoundTripFunc: func(req *http.Request) (*http.Response, error) {
+			var respBody string
+			switch req.URL.Path {
+			case "/gmail/v1/users/me/history":
+				respBody = `{"history": [{"id": "120", "messagesAdded": [{"message": {"id": "m1"}}]}]}`
+			case "/gmail/v1/users/me/messages/m1":
+				respBody = `{"id": "m1", "historyId": "120", "labelIds": ["INBOX"], "payload": {
+					"mimeType": "multipart/mixed",
+					"parts": [
+						{"partId": "0", "mimeType": "text/plain", "body": {"data": "VG90YWw6IDEw"}},
+						{"partId": "1", "mimeType": "application/pdf", "filename": "receipt.pdf",
+						 "body": {"attachmentId": "att-1", "size": 9}}
+					]}}`
+			default:
+				t.Errorf("Unexpected Gmail call: %s", req.URL.Path)
+				return &http.Response{StatusCode: http.StatusNotFound, Body: io.NopCloser(bytes.NewBufferString(`{}`)), Header: make(http.Header)}, nil
+			}
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(bytes.NewBufferString(respBody)),
+				Header:     make(http.Header),
+			}, nil
+		},
+	}
+
+	blobs, err := blob.NewLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewLocalStore: %v", err)
+	}
+	mockAuth := &MockTokenManager{Client: &http.Client{Transport: transport}}
+	service := services.NewGmailWatchService(&config.Config{}, mockAuth, mockRepo)
+	service.Blobs = blobs
+
+	if err := service.ProcessPushNotification(context.Background(), "shop@example.com", 150); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(mockRepo.Attachments) != 1 || mockRepo.Attachments[0] != stored {
+		t.Errorf("Expected the stored attachment to be kept as is, got %+v", mockRepo.Attachments)
+	}
+	if got := outcomes(mockRepo.Events); len(got) != 1 || got[0].Status != storage.EventProcessed {
+		t.Errorf("Expected the message to be processed, got %+v", got)
+	}
+}
+
+func TestGmailWatchService_ProcessPushNotification_NotesUndecodableAttachments(t *testing.T) {
+	mockRepo := mocks.NewMockHistoryRepository()
+	mockRepo.Cursors["shop@example.com"] = 100
+
+	transport := &MockTransport{
+		RoundTripFunc: func(req *http.Request) (*http.Response, error) {
+			var respBody string
+			switch req.URL.Path {
+			case "/gmail/v1/users/me/history":
+				respBody = `{"history": [{"id": "120", "messagesAdded": [{"message": {"id": "m1"}}]}]}`
+			case "/gmail/v1/users/me/messages/m1":
+				respBody = `{"id": "m1", "historyId": "120", "labelIds": ["INBOX"], "payload": {
+					"mimeType": "multipart/mixed",
+					"parts": [
+						{"partId": "0", "mimeType": "text/plain", "body": {"data": "not base64!"}},
+						{"partId": "1", "mimeType": "application/pdf", "filename": "receipt.pdf",
+						 "body": {"attachmentId": "att-1", "size": 9}}
+					]}}`
+			default:
+				t.Fatalf("Unexpected Gmail call: %s", req.URL.Path)
+			}
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(bytes.NewBufferString(respBody)),
+				Header:     make(http.Header),
+			}, nil
+		},
+	}
+
+	blobs, err := blob.NewLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewLocalStore: %v", err)
+	}
+	mockAuth := &MockTokenManager{Client: &http.Client{Transport: transport}}
+	service := services.NewGmailWatchService(&config.Config{}, mockAuth, mockRepo)
+	service.Blobs = blobs
+
+	if err := service.ProcessPushNotification(context.Background(), "shop@example.com", 150); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	var saved *storage.Event
+	for i, e := range mockRepo.Events {
+		if e.Status == storage.EventSaved {
+			saved = &mockRepo.Events[i]
+		}
+	}
+	if saved == nil || !strings.Contains(saved.Error, "attachments not stored") {
+		t.Errorf("Expected the saved step to note the missing attachments, got %+v", saved)
+	}
+}
+
+func TestGmailWatchService_Reprocess(t *testing.T) {
+	mockRepo := mocks.NewMockHistoryRepository()
+	mockRepo.Mailboxes = []storage.Mailbox{
+		{ID: "mb-1", EmailAddress: "shop@example.com", RefreshTokenSecret: "shop-token", Status: storage.MailboxActive},
+	}
+	created := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	mockRepo.SavedEmails = []storage.ProcessedEmail{
+		{ID: 1, MessageID: "m1", MailboxID: "mb-1", LabelIDs: storage.StringArray{"INBOX"}, Snippet: "old", CreatedAt: created},
+		{ID: 2, MessageID: "m2", MailboxID: "mb-1", LabelIDs: storage.StringArray{"INBOX"}, CreatedAt: created.Add(48 * time.Hour)},
+	}
+	mockRepo.Events = []storage.Event{
+		{ID: "00000000-0000-0000-0000-000000000001", MessageID: "m1", MailboxID: "mb-1", Status: "processed"},
+		{ID: "00000000-0000-0000-0000-000000000003", MessageID: "m3", MailboxID: "mb-1", Status: "error", Error: "Failed to get message: 500"},
+	}
+
+	transport := &MockTransport{
+		RoundTripFunc: func(req *http.Request) (*http.Response, error) {
+			id := strings.TrimPrefix(req.URL.Path, "/gmail/v1/users/me/messages/")
+			if id != "m1" && id != "m3" {
+				t.Errorf("Unexpected Gmail call: %s", req.URL.Path)
+			}
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(bytes.NewBufferString(`{"id": "` + id + `", "historyId": "120", "labelIds": ["INBOX"], "snippet": "fixed"}`)),
+				Header:     make(http.Header),
+			}, nil
+		},
+	}
+	service := services.NewGmailWatchService(&config.Config{}, &MockTokenManager{Client: &http.Client{Transport: transport}}, mockRepo)
+
+	from, to := created.Add(-time.Hour), created.Add(time.Hour)
+	out, err := service.Reprocess(context.Background(), services.ReprocessRequest{EventIDs: []string{"00000000-0000-0000-0000-000000000003"}, From: &from, To: &to})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	var result services.ReprocessResult
+	json.Unmarshal(out, &result)
+	if result.Requested != 2 || result.Processed != 2 || result.Errors != 0 {
+		t.Errorf("Expected m3 and m1 to be reprocessed, got %+v", result)
+	}
+	// m1 is unchanged apart from the snippet, and is still rewritten.
+	if mockRepo.SavedEmails[0].Snippet != "fixed" {
+		t.Errorf("Expected m1 to be overwritten, got %+v", mockRepo.SavedEmails[0])
+	}
+	retries := map[string]string{}
+	for _, e := range outcomes(mockRepo.Events[2:]) {
+		retries[e.MessageID] = e.RetryOf
+		if e.Status != "processed" {
+			t.Errorf("Expected processed events, got %+v", e)
+		}
+	}
+	if retries["m1"] != "00000000-0000-0000-0000-000000000001" || retries["m3"] != "00000000-0000-0000-0000-000000000003" {
+		t.Errorf("Expected new events to link to the earlier events, got %v", retries)
+	}
+
+	_, err = service.Reprocess(context.Background(), services.ReprocessRequest{EventIDs: []string{"00000000-0000-0000-0000-000000000404"}})
+	if !errors.Is(err, services.ErrInvalidReprocess) {
+		t.Errorf("Expected ErrInvalidReprocess for an unknown event, got %v", err)
+	}
+	_, err = service.Reprocess(context.Background(), services.ReprocessRequest{EventIDs: []string{"ev-3"}})
+	if !errors.Is(err, services.ErrInvalidReprocess) {
+		t.Errorf("Expected ErrInvalidReprocess for an event ID that is not a UUID, got %v", err)
+	}
+}
+
+func TestGmailWatchService_JobKeys(t *testing.T) {
+	ctx := context.Background()
+	legacy := services.NewGmailWatchService(&config.Config{}, &MockTokenManager{}, mocks.NewMockHistoryRepository())
+	if a, b := legacy.MailboxKey(ctx, ""), legacy.MailboxKey(ctx, "Me@Example.com"); a == "" || a != b {
+		t.Errorf("Expected pushes and default-mailbox jobs of the legacy mailbox to share a key, got %q and %q", a, b)
+	}
+
+	mockRepo := mocks.NewMockHistoryRepository()
+	mockRepo.Mailboxes = []storage.Mailbox{
+		{ID: "mb-1", EmailAddress: "Shop@example.com", Status: storage.MailboxActive},
+	}
+	mockRepo.Events = []storage.Event{{ID: "00000000-0000-0000-0000-000000000001", MessageID: "m1", MailboxID: "mb-1", Status: "error"}}
+	service := services.NewGmailWatchService(&config.Config{}, &MockTokenManager{}, mockRepo)
+
+	push := service.MailboxKey(ctx, "SHOP@example.com")
+	if push != "shop@example.com" {
+		t.Errorf("Expected the lowercased registry address as push key, got %q", push)
+	}
+	if got := service.MailboxKey(ctx, ""); got != push {
+		t.Errorf("Expected the default mailbox to share the push key %q, got %q", push, got)
+	}
+	if got := service.RenewKeys(ctx, services.RenewOptions{}); !slices.Equal(got, []string{push}) {
+		t.Errorf("Expected renewing every mailbox to hold %q, got %v", push, got)
+	}
+	for _, req := range []services.ReprocessRequest{{MessageIDs: []string{"m1"}}, {EventIDs: []string{"00000000-0000-0000-0000-000000000001"}}} {
+		if got := service.ReprocessKeys(ctx, req); !slices.Equal(got, []string{push}) {
+			t.Errorf("Expected reprocessing %+v to hold %q, got %v", req, push, got)
+		}
+	}
+}
+
+func TestGmailWatchService_ProcessPushNotification_WritesBackLabels(t *testing.T) {
+	mockRepo := mocks.NewMockHistoryRepository()
+	mockRepo.Cursors["shop@example.com"] = 100
+
+	var created []string
+	var modified []string
+	gets := 0
+	transport := &MockTransport{
+		RoundTripFunc: func(req *http.Request) (*http.Response, error) {
+			var respBody string
+			switch {
+			case req.URL.Path == "/gmail/v1/users/me/labels" && req.Method == http.MethodPost:
+				var label struct{ Name string }
+				json.NewDecoder(req.Body).Decode(&label)
+				created = append(created, label.Name)
+				respBody = `{"id": "Label_new", "name": "` + label.Name + `"}`
+			case req.URL.Path == "/gmail/v1/users/me/labels":
+				respBody = `{"labels": [{"id": "INBOX", "name": "INBOX"}, {"id": "Label_err", "name": "POS/Error"}]}`
+			case req.URL.Path == "/gmail/v1/users/me/history" && req.URL.Query().Get("startHistoryId") == "100":
+				respBody = `{"history": [{"id": "120", "messagesAdded": [{"message": {"id": "m1"}}]}], "historyId": "150"}`
+			case req.URL.Path == "/gmail/v1/users/me/history":
+				// The second push only reports our own label change.
+				respBody = `{"history": [{"id": "160", "labelsAdded": [{"message": {"id": "m1"}, "labelIds": ["Label_new"]}]}], "historyId": "170"}`
+			case req.URL.Path == "/gmail/v1/users/me/messages/m1":
+				gets++
+				respBody = `{"id": "m1", "historyId": "120", "labelIds": ["INBOX", "Label_err"], "snippet": "hello"}`
+			case req.URL.Path == "/gmail/v1/users/me/messages/m1/modify":
+				var body struct {
+					AddLabelIds    []string
+					RemoveLabelIds []string
+				}
+				json.NewDecoder(req.Body).Decode(&body)
+				modified = append(modified, strings.Join(body.AddLabelIds, ",")+"-"+strings.Join(body.RemoveLabelIds, ","))
+				respBody = `{"id": "m1"}`
+			default:
+				t.Errorf("Unexpected Gmail call: %s %s", req.Method, req.URL.Path)
+				return &http.Response{StatusCode: http.StatusNotFound, Body: io.NopCloser(bytes.NewBufferString("Not Found"))}, nil
+			}
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(bytes.NewBufferString(respBody)),
+				Header:     make(http.Header),
+			}, nil
+		},
+	}
+	cfg := &config.Config{LabelProcessed: "pos/processed", LabelError: "pos/error"}
+	service := services.NewGmailWatchService(cfg, &MockTokenManager{Client: &http.Client{Transport: transport}}, mockRepo)
+
+	if err := service.ProcessPushNotification(context.Background(), "shop@example.com", 150); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if err := service.ProcessPushNotification(context.Background(), "shop@example.com", 170); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if len(created) != 1 || created[0] != "pos/processed" {
+		t.Errorf("Expected only pos/processed to be created, got %v", created)
+	}
+	if len(modified) != 1 || modified[0] != "Label_new-Label_err" {
+		t.Errorf("Expected m1 labeled processed and unlabeled error once, got %v", modified)
+	}
+	if gets != 1 {
+		t.Errorf("Expected the writeback's own history record to be skipped, got %d fetches", gets)
+	}
+	if got := mockRepo.SavedEmails[0].LabelIDs; len(got) != 1 || got[0] != "INBOX" {
+		t.Errorf("Expected writeback labels to be left out of the stored labels, got %v", got)
+	}
+	if got := mockRepo.Cursors["shop@example.com"]; got != 170 {
+		t.Errorf("Expected cursor to advance to 170, got %d", got)
+	}
+}
+
+func TestGmailWatchService_ProcessPushNotification_AgainstFakeGmail(t *testing.T) {
+	srv := gmailtest.NewServer("shop@example.com")
+	defer srv.Close()
+
+	mockRepo := mocks.NewMockHistoryRepository()
+	mockRepo.Cursors["shop@example.com"] = srv.HistoryID()
+	blobs, err := blob.NewLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewLocalStore: %v", err)
+	}
+	cfg := &config.Config{GmailEndpoint: srv.Endpoint(), LabelProcessed: "pos/processed"}
+	service := services.NewGmailWatchService(cfg, &MockTokenManager{Client: http.DefaultClient}, mockRepo)
+	service.Blobs = blobs
+	push := func() {
+		t.Helper()
+		if err := service.ProcessPushNotification(context.Background(), "shop@example.com", srv.HistoryID()); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+	}
+
+	receipt := srv.AddMessage(gmailtest.Message{
+		From:        "Shop <receipts@shop.example>",
+		Subject:     "Your receipt",
+		Body:        "Total: 10",
+		Attachments: []gmailtest.Attachment{{Filename: "receipt.pdf", MimeType: "application/pdf", Data: []byte("%PDF-1.4\n")}},
+	})
+	push()
+	// The writeback's own label change is pushed as well.
+	push()
+
+	if len(mockRepo.SavedEmails) != 1 || mockRepo.SavedEmails[0].FromAddress != "receipts@shop.example" {
+		t.Fatalf("Expected the receipt to be saved, got %+v", mockRepo.SavedEmails)
+	}
+	if len(mockRepo.Attachments) != 1 || mockRepo.Attachments[0].Filename != "receipt.pdf" {
+		t.Errorf("Expected the attachment to be stored, got %+v", mockRepo.Attachments)
+	}
+	labels := srv.Message(receipt.Id).LabelIds
+	if len(labels) != 3 || !strings.HasPrefix(labels[2], "Label_") {
+		t.Errorf("Expected the message labeled as processed, got %v", labels)
+	}
+	gets := 0
+	for _, r := range srv.Requests() {
+		if r == "GET /gmail/v1/users/me/messages/"+receipt.Id {
+			gets++
+		}
+	}
+	if gets != 1 {
+		t.Errorf("Expected the message to be fetched once, got %d", gets)
+	}
+	if got := mockRepo.Cursors["shop@example.com"]; got != srv.HistoryID() {
+		t.Errorf("Expected cursor to advance to %d, got %d", srv.HistoryID(), got)
+	}
+}
+
+func TestGmailWatchService_ProcessPushNotification_RecordsSteps(t *testing.T) {
+	srv := gmailtest.NewServer("shop@example.com")
+	defer srv.Close()
+
+	mockRepo := mocks.NewMockHistoryRepository()
+	mockRepo.Cursors["shop@example.com"] = srv.HistoryID()
+	mockRepo.Filters = []storage.Filter{
+		{ID: "f-receipts", Name: "Receipts", Enabled: true, Priority: 10, GmailQuery: "subject:receipt"},
+		{ID: "f-invoices", Name: "Invoices", Enabled: true, Priority: 20, GmailQuery: "subject:invoice"},
+	}
+	cfg := &config.Config{GmailEndpoint: srv.Endpoint(), LabelProcessed: "pos/processed"}
+	service := services.NewGmailWatchService(cfg, &MockTokenManager{Client: http.DefaultClient}, mockRepo)
+
+	receipt := srv.AddMessage(gmailtest.Message{From: "receipts@shop.example", Subject: "Your receipt"})
+	news := srv.AddMessage(gmailtest.Message{From: "news@shop.example", Subject: "Weekly news"})
+	if err := service.ProcessPushNotification(context.Background(), "shop@example.com", srv.HistoryID()); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	steps := map[string][]storage.Event{}
+	for _, e := range mockRepo.Events {
+		steps[e.MessageID] = append(steps[e.MessageID], e)
+	}
+	statuses := func(events []storage.Event) []string {
+		var out []string
+		for _, e := range events {
+			out = append(out, e.Status)
+		}
+		return out
+	}
+
+	got := steps[receipt.Id]
+	if want := []string{"fetched", "matched", "saved", "labeled", "processed"}; !reflect.DeepEqual(statuses(got), want) {
+		t.Fatalf("Expected receipt events %v, got %+v", want, got)
+	}
+	if d := got[0].Details; d.Trigger != "push" || !reflect.DeepEqual(d.HistoryTypes, []string{"messageAdded"}) {
+		t.Errorf("Expected the fetched step to name the push and its change, got %+v", d)
+	}
+	if d := got[1].Details; got[1].FilterID != "f-receipts" || d.Filter != "Receipts" {
+		t.Errorf("Expected the matched step to name the filter, got %+v %+v", got[1], d)
+	}
+	if d := got[2].Details; d.Result != "created" || got[2].FilterID != "f-receipts" {
+		t.Errorf("Expected the saved step to report a new row, got %+v", d)
+	}
+	if d := got[3].Details; len(d.LabelIDs) != 1 || !strings.HasPrefix(d.LabelIDs[0], "Label_") {
+		t.Errorf("Expected the labeled step to list the writeback label, got %+v", d)
+	}
+	for i := 1; i < len(got); i++ {
+		if got[i].Details == nil || got[i].Details.Attempt != got[0].Details.Attempt || got[0].Details.Attempt == "" {
+			t.Errorf("Expected every event of the attempt to carry its ID, got %+v", got[i].Details)
+		}
+		if got[i].CreatedAt.Before(got[i-1].CreatedAt) {
+			t.Errorf("Expected events in order, got %v before %v", got[i].Status, got[i-1].Status)
+		}
+	}
+
+	got = steps[news.Id]
+	if want := []string{"fetched", "unmatched"}; !reflect.DeepEqual(statuses(got), want) {
+		t.Fatalf("Expected news events %v, got %+v", want, got)
+	}
+	if d := got[1].Details; d.Reason == "" || !reflect.DeepEqual(d.FiltersChecked, []string{"Receipts", "Invoices"}) {
+		t.Errorf("Expected the unmatched step to explain the miss, got %+v", d)
+	}
+
+	// Reading the news brings it up again, but a known miss is not recorded twice.
+	recorded := len(mockRepo.Events)
+	if _, err := srv.ModifyLabels(news.Id, nil, []string{"UNREAD"}); err != nil {
+		t.Fatalf("ModifyLabels: %v", err)
+	}
+	if err := service.ProcessPushNotification(context.Background(), "shop@example.com", srv.HistoryID()); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(mockRepo.Events) != recorded {
+		t.Errorf("Expected no new events for a known miss, got %+v", mockRepo.Events[recorded:])
+	}
+}
@@ -0,0 +1,77 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+)
+
+// progressInterval bounds how often a running job writes its progress to the database.
+const progressInterval = time.Second
+
+// JobProgress is the progress a long-running action reports while it runs as a job.
+type JobProgress struct {
+	Done  int `json:"done"`
+	Total int `json:"total"`
+}
+
+type progressKey struct{}
+
+// progressReporter throttles progress updates of one job.
+type progressReporter struct {
+	mu       sync.Mutex
+	lastSave time.Time
+	save     func(JobProgress)
+}
+
+func (p *progressReporter) report(progress JobProgress, force bool) {
+	p.mu.Lock()
+	if !force && time.Since(p.lastSave) < progressInterval {
+		p.mu.Unlock()
+		return
+	}
+	p.lastSave = time.Now()
+	p.mu.Unlock()
+	p.save(progress)
+}
+
+// reportProgress records the progress of the job running under ctx, if any. The last
+// step (done == total) is always written; intermediate ones at most once per second.
+func reportProgress(ctx context.Context, done, total int) {
+	p, ok := ctx.Value(progressKey{}).(*progressReporter)
+	if !ok {
+		return
+	}
+	p.report(JobProgress{Done: done, Total: total}, done >= total)
+}
+
+// RunJob runs fn as the admin job jobID: it marks the job running, lets fn report progress
+// and stores fn's result or error on the job. The error of fn is returned as well.
+func (s *GmailWatchService) RunJob(ctx context.Context, jobID string, fn func(ctx context.Context) ([]byte, error)) error {
+	if err := s.Repo.StartJob(ctx, jobID); err != nil {
+		log.Printf("Failed to start job %s: %v", jobID, err)
+	}
+
+	reporter := &progressReporter{save: func(progress JobProgress) {
+		data, _ := json.Marshal(progress)
+		if err := s.Repo.UpdateJobProgress(ctx, jobID, data); err != nil {
+			log.Printf("Failed to update progress of job %s: %v", jobID, err)
+		}
+	}}
+	result, err := fn(context.WithValue(ctx, progressKey{}, reporter))
+
+	errMsg := ""
+	if err != nil {
+		errMsg = err.Error()
+		log.Printf("Job %s failed: %v", jobID, err)
+	}
+	// The outcome is recorded even when the job ran out of time.
+	finishCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+	defer cancel()
+	if ferr := s.Repo.FinishJob(finishCtx, jobID, result, errMsg); ferr != nil {
+		log.Printf("Failed to finish job %s: %v", jobID, ferr)
+	}
+	return err
+}
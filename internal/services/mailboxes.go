@@ -0,0 +1,143 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"gagarin-soft/internal/storage"
+)
+
+// legacyRefreshTokenSecret holds the refresh token of the single mailbox used before the
+// mailboxes registry existed. It is only used while the registry is empty.
+const legacyRefreshTokenSecret = "gmail-refresh-token"
+
+var (
+	ErrUnknownMailbox  = errors.New("mailbox is not registered")
+	ErrMailboxDisabled = errors.New("mailbox is disabled")
+)
+
+func legacyMailbox(emailAddress string) *storage.Mailbox {
+	return &storage.Mailbox{
+		EmailAddress:       emailAddress,
+		RefreshTokenSecret: legacyRefreshTokenSecret,
+		Status:             storage.MailboxActive,
+	}
+}
+
+// activeMailboxes returns the active registered mailboxes. registered is false when the
+// registry is empty, in which case the caller should fall back to the legacy mailbox.
+func (s *GmailWatchService) activeMailboxes(ctx context.Context) (active []storage.Mailbox, registered bool, err error) {
+	mailboxes, err := s.Repo.ListMailboxes(ctx)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to list mailboxes: %w", err)
+	}
+	for _, mb := range mailboxes {
+		if mb.Status == storage.MailboxActive {
+			active = append(active, mb)
+		}
+	}
+	return active, len(mailboxes) > 0, nil
+}
+
+// mailboxFor resolves the mailbox that a push for emailAddress belongs to.
+func (s *GmailWatchService) mailboxFor(ctx context.Context, emailAddress string) (*storage.Mailbox, error) {
+	mb, err := s.Repo.GetMailboxByEmail(ctx, emailAddress)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load mailbox: %w", err)
+	}
+	if mb != nil {
+		if mb.Status != storage.MailboxActive {
+			return nil, fmt.Errorf("%w: %s", ErrMailboxDisabled, emailAddress)
+		}
+		return mb, nil
+	}
+
+	_, registered, err := s.activeMailboxes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if registered {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownMailbox, emailAddress)
+	}
+	return legacyMailbox(emailAddress), nil
+}
+
+// defaultMailbox picks the mailbox for requests that don't name one: the legacy mailbox or
+// the only active registered mailbox.
+func (s *GmailWatchService) defaultMailbox(ctx context.Context) (*storage.Mailbox, error) {
+	active, registered, err := s.activeMailboxes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !registered {
+		return legacyMailbox(""), nil
+	}
+	if len(active) != 1 {
+		return nil, fmt.Errorf("%d active mailboxes, emailAddress is required", len(active))
+	}
+	return &active[0], nil
+}
+
+// resolveMailbox resolves the mailbox named by a request, or the default mailbox when it
+// names none.
+func (s *GmailWatchService) resolveMailbox(ctx context.Context, emailAddress string) (*storage.Mailbox, error) {
+	if emailAddress == "" {
+		return s.defaultMailbox(ctx)
+	}
+	return s.mailboxFor(ctx, emailAddress)
+}
+
+// legacyMailboxKey is the worker pool key of the legacy mailbox, whose address is only known
+// from its pushes.
+const legacyMailboxKey = "legacy"
+
+// mailboxKey is the worker pool key of mb. Jobs on a mailbox share its sync cursor and must
+// not run concurrently.
+func mailboxKey(mb *storage.Mailbox) string {
+	if mb.ID == "" {
+		return legacyMailboxKey
+	}
+	return strings.ToLower(mb.EmailAddress)
+}
+
+// MailboxKey returns the worker pool key of the mailbox a push or request for emailAddress
+// belongs to, or of the default mailbox when emailAddress is empty. A mailbox that does not
+// resolve fails the job anyway; its lowercased address is returned then.
+func (s *GmailWatchService) MailboxKey(ctx context.Context, emailAddress string) string {
+	mb, err := s.resolveMailbox(ctx, emailAddress)
+	if err != nil {
+		return strings.ToLower(emailAddress)
+	}
+	return mailboxKey(mb)
+}
+
+// RenewKeys returns the worker pool keys of the mailboxes Renew touches with opts.
+func (s *GmailWatchService) RenewKeys(ctx context.Context, opts RenewOptions) []string {
+	if opts.EmailAddress != "" {
+		return []string{s.MailboxKey(ctx, opts.EmailAddress)}
+	}
+	mailboxes, err := s.WatchedMailboxes(ctx)
+	if err != nil {
+		return nil
+	}
+	keys := make([]string, 0, len(mailboxes))
+	for i := range mailboxes {
+		keys = append(keys, mailboxKey(&mailboxes[i]))
+	}
+	return keys
+}
+
+// WatchedMailboxes returns the mailboxes whose watch has to be kept alive: the active
+// registered mailboxes, or the legacy mailbox while the registry is empty.
+func (s *GmailWatchService) WatchedMailboxes(ctx context.Context) ([]storage.Mailbox, error) {
+	active, registered, err := s.activeMailboxes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !registered {
+		return []storage.Mailbox{*legacyMailbox("")}, nil
+	}
+	return active, nil
+}
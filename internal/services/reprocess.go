@@ -0,0 +1,241 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+
+	"gagarin-soft/internal/storage"
+)
+
+// maxReprocessMessages caps a single reprocess request; larger ranges have to be split.
+const maxReprocessMessages = 1000
+
+var ErrInvalidReprocess = errors.New("invalid reprocess request")
+
+// ReprocessRequest selects already handled messages to run through the pipeline again.
+// The selectors are combined; a message selected more than once is processed once.
+type ReprocessRequest struct {
+	// MessageIDs are Gmail message IDs of the mailbox named by EmailAddress, which may be
+	// left empty when only one mailbox is active.
+	MessageIDs   []string `json:"messageIds"`
+	EmailAddress string   `json:"emailAddress"`
+	// EventIDs are events, usually failed ones, whose message is processed again.
+	EventIDs []string `json:"eventIds"`
+	// From and To select processed_emails rows by created_at, From inclusive.
+	From *time.Time `json:"from"`
+	To   *time.Time `json:"to"`
+}
+
+// ReprocessResult summarizes a reprocess run.
+type ReprocessResult struct {
+	Requested int `json:"requested"`
+	Processed int `json:"processed"`
+	Ignored   int `json:"ignored"`
+	Errors    int `json:"errors"`
+}
+
+// reprocessTarget is one message to process again and the event it retries.
+type reprocessTarget struct {
+	messageID string
+	retryOf   string
+}
+
+// Reprocess fetches the selected messages from Gmail again, matches and saves them with
+// the stored rows overwritten, and records new events linked to each message's earlier
+// attempt. Sync cursors are left alone. The result is returned as JSON.
+func (s *GmailWatchService) Reprocess(ctx context.Context, req ReprocessRequest) ([]byte, error) {
+	byMailbox, order, err := s.reprocessTargets(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ReprocessResult{}
+	for _, mailboxID := range order {
+		result.Requested += len(byMailbox[mailboxID])
+	}
+	log.Printf("Reprocessing %d messages in %d mailboxes", result.Requested, len(order))
+
+	done := 0
+	reportProgress(ctx, 0, result.Requested)
+	for _, mailboxID := range order {
+		targets := byMailbox[mailboxID]
+		run, err := s.reprocessRun(ctx, mailboxID)
+		if err != nil {
+			log.Printf("Cannot reprocess %d messages of mailbox %q: %v", len(targets), mailboxID, err)
+			result.Errors += len(targets)
+			done += len(targets)
+			reportProgress(ctx, done, result.Requested)
+			continue
+		}
+
+		for _, t := range targets {
+			s.processMessage(ctx, run, t.messageID, processOptions{trigger: triggerReprocess, overwrite: true, retryOf: t.retryOf})
+			done++
+			reportProgress(ctx, done, result.Requested)
+		}
+		s.updateDailyStats(ctx, run)
+
+		result.Processed += run.stats.Ok
+		result.Errors += run.stats.Error
+	}
+	result.Ignored = result.Requested - result.Processed - result.Errors
+
+	return json.Marshal(result)
+}
+
+// ReprocessKeys returns the worker pool keys of the mailboxes the messages selected by req
+// belong to. A request that does not resolve fails the job anyway and has no keys.
+func (s *GmailWatchService) ReprocessKeys(ctx context.Context, req ReprocessRequest) []string {
+	_, order, err := s.reprocessTargets(ctx, req)
+	if err != nil {
+		return nil
+	}
+	var keys []string
+	for _, mailboxID := range order {
+		if mb, err := s.reprocessMailbox(ctx, mailboxID); err == nil {
+			keys = append(keys, mailboxKey(mb))
+		}
+	}
+	return keys
+}
+
+// Validate checks the request without looking anything up; its errors wrap
+// ErrInvalidReprocess.
+func (req ReprocessRequest) Validate() error {
+	if len(req.MessageIDs) == 0 && len(req.EventIDs) == 0 && req.From == nil {
+		return fmt.Errorf("%w: messageIds, eventIds or from is required", ErrInvalidReprocess)
+	}
+	if req.From == nil && req.To != nil {
+		return fmt.Errorf("%w: to requires from", ErrInvalidReprocess)
+	}
+	for _, id := range req.EventIDs {
+		if err := uuid.Validate(id); err != nil {
+			return fmt.Errorf("%w: event ID %q is not a UUID", ErrInvalidReprocess, id)
+		}
+	}
+	return nil
+}
+
+// reprocessTargets resolves the request into messages grouped by mailbox ID, in the order
+// the mailboxes were first seen.
+func (s *GmailWatchService) reprocessTargets(ctx context.Context, req ReprocessRequest) (map[string][]reprocessTarget, []string, error) {
+	if err := req.Validate(); err != nil {
+		return nil, nil, err
+	}
+
+	byMailbox := make(map[string][]reprocessTarget)
+	var order []string
+	seen := make(map[string]bool)
+	var unlinked []string // messages whose earlier event is looked up afterwards
+	add := func(mailboxID, messageID, retryOf string) {
+		if messageID == "" || seen[messageID] {
+			return
+		}
+		seen[messageID] = true
+		if _, ok := byMailbox[mailboxID]; !ok {
+			order = append(order, mailboxID)
+		}
+		byMailbox[mailboxID] = append(byMailbox[mailboxID], reprocessTarget{messageID: messageID, retryOf: retryOf})
+		if retryOf == "" {
+			unlinked = append(unlinked, messageID)
+		}
+	}
+
+	if len(req.EventIDs) > 0 {
+		events, err := s.Repo.GetEvents(ctx, req.EventIDs)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to load events: %w", err)
+		}
+		found := make(map[string]bool, len(events))
+		for _, e := range events {
+			found[e.ID] = true
+		}
+		for _, id := range req.EventIDs {
+			if !found[id] {
+				return nil, nil, fmt.Errorf("%w: event %s not found", ErrInvalidReprocess, id)
+			}
+		}
+		for _, e := range events {
+			add(e.MailboxID, e.MessageID, e.ID)
+		}
+	}
+
+	if len(req.MessageIDs) > 0 {
+		mb, err := s.resolveMailbox(ctx, req.EmailAddress)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, id := range req.MessageIDs {
+			add(mb.ID, id, "")
+		}
+	}
+
+	if req.From != nil {
+		to := time.Now()
+		if req.To != nil {
+			to = *req.To
+		}
+		emails, err := s.Repo.ListProcessedEmails(ctx, *req.From, to, maxReprocessMessages+1)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to list processed emails: %w", err)
+		}
+		for _, e := range emails {
+			add(e.MailboxID, e.MessageID, "")
+		}
+	}
+
+	if len(seen) > maxReprocessMessages {
+		return nil, nil, fmt.Errorf("%w: more than %d messages selected", ErrInvalidReprocess, maxReprocessMessages)
+	}
+
+	// Messages selected by ID or range retry their most recent event.
+	latest, err := s.Repo.LatestEventIDs(ctx, unlinked)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load earlier events: %w", err)
+	}
+	for _, targets := range byMailbox {
+		for i := range targets {
+			if targets[i].retryOf == "" {
+				targets[i].retryOf = latest[targets[i].messageID]
+			}
+		}
+	}
+	return byMailbox, order, nil
+}
+
+// reprocessRun prepares a sync run for a mailbox referenced by ID.
+func (s *GmailWatchService) reprocessRun(ctx context.Context, mailboxID string) (*syncRun, error) {
+	mb, err := s.reprocessMailbox(ctx, mailboxID)
+	if err != nil {
+		return nil, err
+	}
+	return s.newSyncRun(ctx, mb)
+}
+
+// reprocessMailbox loads an active mailbox by ID. An empty ID stands for messages recorded
+// before the mailbox registry existed.
+func (s *GmailWatchService) reprocessMailbox(ctx context.Context, mailboxID string) (*storage.Mailbox, error) {
+	if mailboxID == "" {
+		return s.defaultMailbox(ctx)
+	}
+	mailboxes, err := s.Repo.ListMailboxes(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list mailboxes: %w", err)
+	}
+	for i := range mailboxes {
+		if mailboxes[i].ID != mailboxID {
+			continue
+		}
+		if mailboxes[i].Status != storage.MailboxActive {
+			return nil, fmt.Errorf("%w: %s", ErrMailboxDisabled, mailboxes[i].EmailAddress)
+		}
+		return &mailboxes[i], nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnknownMailbox, mailboxID)
+}
@@ -0,0 +1,113 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+)
+
+// ResyncResult summarizes a full resync run.
+type ResyncResult struct {
+	EmailAddress string `json:"emailAddress"`
+	HistoryID    uint64 `json:"historyId,string"`
+	Listed       int    `json:"listed"`
+	Skipped      int    `json:"skipped"`
+	Processed    int    `json:"processed"`
+	Errors       int    `json:"errors"`
+}
+
+// Resync runs a bounded full resync of a mailbox and returns the result as JSON.
+// It is used on demand (admin "resync" action) and automatically when Gmail
+// reports that the stored history cursor is too old. An empty emailAddress selects
+// the only active mailbox.
+func (s *GmailWatchService) Resync(ctx context.Context, emailAddress string) ([]byte, error) {
+	mb, err := s.resolveMailbox(ctx, emailAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	run, err := s.newSyncRun(ctx, mb)
+	if err != nil {
+		log.Printf("Error preparing resync of %s: %v", mailboxName(mb), err)
+		return nil, fmt.Errorf("internal server error")
+	}
+
+	result, err := s.resync(ctx, run)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(result)
+}
+
+// resync lists recent messages matching the configured label/query, processes the ones
+// that are not in processed_emails yet and then moves the cursor to the mailbox's
+// current history ID.
+func (s *GmailWatchService) resync(ctx context.Context, run *syncRun) (*ResyncResult, error) {
+	gmailClient := run.client
+
+	// Capture the history ID before listing so anything arriving mid-resync is picked up
+	// by the next incremental sync.
+	profile, err := gmailClient.GetProfile()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get profile: %w", err)
+	}
+
+	var labelIDs []string
+	if s.Config.TargetGmailLabel != "" {
+		labelIDs = []string{s.Config.TargetGmailLabel}
+	}
+	var after time.Time
+	if s.Config.ResyncLookbackDays > 0 {
+		after = time.Now().AddDate(0, 0, -s.Config.ResyncLookbackDays)
+	}
+
+	msgIDs, err := gmailClient.ListMessages(s.Config.ResyncQuery, labelIDs, after, s.Config.ResyncMaxMessages)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list messages: %w", err)
+	}
+
+	seen, err := s.Repo.ProcessedMessageIDs(ctx, msgIDs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load processed messages: %w", err)
+	}
+
+	// The cursor is keyed by the address pushes arrive for.
+	emailAddress := run.mailbox.EmailAddress
+	if emailAddress == "" {
+		emailAddress = profile.EmailAddress
+	}
+
+	result := &ResyncResult{
+		EmailAddress: emailAddress,
+		HistoryID:    profile.HistoryId,
+		Listed:       len(msgIDs),
+	}
+	log.Printf("Resync of %s: %d messages listed, %d already processed", emailAddress, len(msgIDs), len(seen))
+
+	committed := true
+	reportProgress(ctx, 0, len(msgIDs))
+	for i, msgID := range msgIDs {
+		if seen[msgID] {
+			result.Skipped++
+		} else if !s.processMessage(ctx, run, msgID, processOptions{trigger: triggerResync}) {
+			committed = false
+		}
+		reportProgress(ctx, i+1, len(msgIDs))
+	}
+	result.Processed = run.stats.Ok
+	result.Errors = run.stats.Error
+
+	s.updateDailyStats(ctx, run)
+
+	if !committed {
+		return result, fmt.Errorf("resync of %s incomplete, cursor not advanced", emailAddress)
+	}
+	if err := s.Repo.SaveSyncCursor(ctx, emailAddress, profile.HistoryId); err != nil {
+		return result, fmt.Errorf("failed to save sync cursor: %w", err)
+	}
+
+	log.Printf("Resync of %s complete, cursor set to %d", emailAddress, profile.HistoryId)
+	return result, nil
+}
@@ -0,0 +1,79 @@
+package services
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+
+	"gagarin-soft/internal/storage"
+)
+
+// Triggers of a processing attempt, kept on its fetched step.
+const (
+	triggerPush      = "push"
+	triggerResync    = "resync"
+	triggerReprocess = "reprocess"
+)
+
+// trace collects the step events of one processing attempt of a message, each timed since
+// the previous one. They are recorded together with the attempt's outcome, so a replay that
+// changes nothing leaves no events behind.
+type trace struct {
+	id    string
+	event storage.Event // the fields every event of the attempt shares
+	start time.Time
+	mark  time.Time
+	steps []storage.Event
+}
+
+func newTrace(run *syncRun, msgID string, opts processOptions) *trace {
+	now := time.Now()
+	return &trace{
+		id:    uuid.NewString(),
+		event: storage.Event{MailboxID: run.mailbox.ID, MessageID: msgID, RetryOf: opts.retryOf},
+		start: now,
+		mark:  now,
+	}
+}
+
+// step adds a step that ended now. A non-nil err is kept on the step without failing the
+// attempt.
+func (t *trace) step(status string, details *storage.EventDetails, err error) {
+	now := time.Now()
+	if details == nil {
+		details = &storage.EventDetails{}
+	}
+	details.Attempt = t.id
+	e := t.event
+	e.Status = status
+	e.Details = details
+	e.DurationMS = now.Sub(t.mark).Milliseconds()
+	e.CreatedAt = now
+	if err != nil {
+		e.Error = err.Error()
+	}
+	t.mark = now
+	t.steps = append(t.steps, e)
+}
+
+// record writes the steps followed by an outcome event timed over the whole attempt. An
+// empty status records the steps alone.
+func (s *GmailWatchService) record(ctx context.Context, t *trace, status, errMsg string) {
+	events := t.steps
+	if status != "" {
+		e := t.event
+		e.Status = status
+		e.Error = errMsg
+		e.Details = &storage.EventDetails{Attempt: t.id}
+		e.CreatedAt = time.Now()
+		e.DurationMS = e.CreatedAt.Sub(t.start).Milliseconds()
+		events = append(events, e)
+	}
+	for _, e := range events {
+		if err := s.Repo.RecordEvent(ctx, e); err != nil {
+			log.Printf("Failed to record %s event of %s: %v", e.Status, e.MessageID, err)
+		}
+	}
+}
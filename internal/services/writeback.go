@@ -0,0 +1,148 @@
+package services
+
+import (
+	"log"
+	"slices"
+	"sync"
+
+	gmailapi "google.golang.org/api/gmail/v1"
+
+	"gagarin-soft/internal/storage"
+)
+
+// Processing outcomes that are written back to the message as labels.
+const (
+	outcomeProcessed = "processed"
+	outcomeError     = "error"
+	outcomeIgnored   = "ignored"
+)
+
+// labelCache keeps the IDs of the writeback labels per mailbox, so they are only listed (and
+// created) once per process.
+type labelCache struct {
+	mu  sync.Mutex
+	ids map[string]map[string]string // mailbox ID -> outcome -> label ID
+}
+
+// writebackLabelNames maps each outcome to its configured label name. Outcomes without a
+// label are left out.
+func (s *GmailWatchService) writebackLabelNames() map[string]string {
+	names := make(map[string]string)
+	for outcome, name := range map[string]string{
+		outcomeProcessed: s.Config.LabelProcessed,
+		outcomeError:     s.Config.LabelError,
+		outcomeIgnored:   s.Config.LabelIgnored,
+	} {
+		if name != "" {
+			names[outcome] = name
+		}
+	}
+	return names
+}
+
+// loadWritebackLabels sets the run's writeback label IDs, creating missing labels. Failures
+// only disable writeback for the run.
+func (s *GmailWatchService) loadWritebackLabels(run *syncRun) {
+	names := s.writebackLabelNames()
+	if len(names) == 0 {
+		return
+	}
+
+	c := &s.labels
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if ids, ok := c.ids[run.mailbox.ID]; ok {
+		run.writeback = ids
+		return
+	}
+
+	list := make([]string, 0, len(names))
+	for _, name := range names {
+		list = append(list, name)
+	}
+	byName, err := run.client.EnsureLabels(list)
+	if err != nil {
+		log.Printf("Label writeback disabled for %s: %v", mailboxName(run.mailbox), err)
+		return
+	}
+	ids := make(map[string]string, len(names))
+	for outcome, name := range names {
+		ids[outcome] = byName[name]
+	}
+	if c.ids == nil {
+		c.ids = make(map[string]map[string]string)
+	}
+	c.ids[run.mailbox.ID] = ids
+	run.writeback = ids
+}
+
+// forgetWritebackLabels drops the cached label IDs of the mailbox, e.g. after a label was
+// deleted in Gmail, so the next run looks them up again.
+func (s *GmailWatchService) forgetWritebackLabels(mailboxID string) {
+	c := &s.labels
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.ids, mailboxID)
+}
+
+// isWritebackLabel reports whether id is one of the labels this service applies.
+func (run *syncRun) isWritebackLabel(id string) bool {
+	for _, own := range run.writeback {
+		if own == id {
+			return true
+		}
+	}
+	return false
+}
+
+// onlyWritebackLabels reports whether all the label changes of a history record are our
+// own writebacks. Such records are skipped so a writeback doesn't trigger another one.
+func (run *syncRun) onlyWritebackLabels(labelIDs []string) bool {
+	if len(run.writeback) == 0 || len(labelIDs) == 0 {
+		return false
+	}
+	for _, id := range labelIDs {
+		if !run.isWritebackLabel(id) {
+			return false
+		}
+	}
+	return true
+}
+
+// withoutWritebackLabels strips our own labels, so writing them back doesn't make the stored
+// label list differ on the next run.
+func (run *syncRun) withoutWritebackLabels(labelIDs []string) []string {
+	if len(run.writeback) == 0 {
+		return labelIDs
+	}
+	return slices.DeleteFunc(slices.Clone(labelIDs), run.isWritebackLabel)
+}
+
+// writeback labels the message with the outcome's label and removes the labels of the other
+// outcomes. Nothing is sent when the message is already labeled that way.
+func (s *GmailWatchService) writeback(run *syncRun, t *trace, msg *gmailapi.Message, outcome string) {
+	if len(run.writeback) == 0 {
+		return
+	}
+
+	var add, remove []string
+	for o, id := range run.writeback {
+		has := slices.Contains(msg.LabelIds, id)
+		switch {
+		case o == outcome && !has:
+			add = append(add, id)
+		case o != outcome && has:
+			remove = append(remove, id)
+		}
+	}
+	if len(add) == 0 && len(remove) == 0 {
+		return
+	}
+
+	err := run.client.ModifyLabels(msg.Id, add, remove)
+	if err != nil {
+		log.Printf("Failed to label message %s as %s: %v", msg.Id, outcome, err)
+		s.forgetWritebackLabels(run.mailbox.ID)
+	}
+	t.step(storage.EventLabeled, &storage.EventDetails{LabelIDs: add, RemovedLabelIDs: remove}, err)
+}
@@ -0,0 +1,417 @@
+package storage
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// AdminRepository is the side of the storage used by the admin service: it reads what the
+// worker recorded and manages the registry (filters, mailboxes) and the job queue.
+type AdminRepository interface {
+	GetFilters(ctx context.Context) ([]Filter, error)
+	CreateFilter(ctx context.Context, f *Filter) error
+	UpdateFilter(ctx context.Context, id string, f *Filter) error
+	DeleteFilter(ctx context.Context, id string) error
+	ListMailboxes(ctx context.Context) ([]Mailbox, error)
+	CreateMailbox(ctx context.Context, m *Mailbox) error
+	UpdateMailbox(ctx context.Context, id string, m *Mailbox) error
+	GetDailyStats(ctx context.Context, from, to, mailboxID string) ([]DailyStat, error)
+	ListEvents(ctx context.Context, q EventQuery) (*EventPage, error)
+	GetMessageEvents(ctx context.Context, messageID string) ([]Event, error)
+	GetProcessedEmails(ctx context.Context, q ProcessedEmailQuery) ([]ProcessedEmail, error)
+	GetProcessedEmail(ctx context.Context, messageID string) (*ProcessedEmail, error)
+	CreateJob(ctx context.Context, j *Job) error
+	FailJob(ctx context.Context, id, errMsg string) error
+	GetJob(ctx context.Context, id string) (*Job, error)
+	GetJobs(ctx context.Context, status string, limit int) ([]Job, error)
+}
+
+// ProcessedEmailQuery narrows GetProcessedEmails; zero values don't filter.
+type ProcessedEmailQuery struct {
+	MailboxID   string
+	FilterID    string
+	Label       string // label ID
+	FromAddress string // case-insensitive exact match
+	Since       time.Time
+	Until       time.Time
+	Limit       int
+}
+
+// EventQuery narrows ListEvents; zero values don't filter.
+type EventQuery struct {
+	Statuses     []string // outcome statuses unless Steps is set
+	Steps        bool     // include step events when Statuses is empty
+	MessageID    string
+	FilterID     string
+	MailboxID    string
+	MailboxEmail string // case-insensitive, resolved through the mailboxes table
+	Since        time.Time
+	Until        time.Time
+	ErrorText    string       // case-insensitive substring of the error
+	After        *EventCursor // only events older than this position
+	Limit        int
+	WithTotal    bool // count all matching events, ignoring After and Limit
+}
+
+// EventPage is one page of ListEvents. NextCursor is empty on the last page.
+type EventPage struct {
+	Events     []Event `json:"events"`
+	NextCursor string  `json:"next_cursor,omitempty"`
+	Total      *int64  `json:"total,omitempty"`
+}
+
+// EventCursor is the position of an event in the newest-first order of ListEvents.
+type EventCursor struct {
+	CreatedAt time.Time
+	ID        string
+}
+
+// Encode returns the opaque form handed to API clients.
+func (c EventCursor) Encode() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(c.CreatedAt.UTC().Format(time.RFC3339Nano) + "|" + c.ID))
+}
+
+// DecodeEventCursor parses a cursor made by Encode.
+func DecodeEventCursor(s string) (EventCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return EventCursor{}, errors.New("invalid cursor")
+	}
+	ts, id, ok := strings.Cut(string(raw), "|")
+	if !ok || id == "" {
+		return EventCursor{}, errors.New("invalid cursor")
+	}
+	createdAt, err := time.Parse(time.RFC3339Nano, ts)
+	if err != nil {
+		return EventCursor{}, errors.New("invalid cursor")
+	}
+	return EventCursor{CreatedAt: createdAt, ID: id}, nil
+}
+
+func (r *PostgresRepository) GetFilters(ctx context.Context) ([]Filter, error) {
+	rows, err := r.pool.Query(ctx, `SELECT id, name, enabled, priority, gmail_query, created_at, updated_at, COALESCE(updated_by, '') FROM filters ORDER BY priority ASC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var filters []Filter
+	for rows.Next() {
+		var f Filter
+		if err := rows.Scan(&f.ID, &f.Name, &f.Enabled, &f.Priority, &f.GmailQuery, &f.CreatedAt, &f.UpdatedAt, &f.UpdatedBy); err != nil {
+			return nil, err
+		}
+		filters = append(filters, f)
+	}
+	return filters, nil
+}
+
+func (r *PostgresRepository) CreateFilter(ctx context.Context, f *Filter) error {
+	_, err := r.pool.Exec(ctx, `INSERT INTO filters (name, enabled, priority, gmail_query, updated_by) VALUES ($1, $2, $3, $4, $5)`,
+		f.Name, f.Enabled, f.Priority, f.GmailQuery, f.UpdatedBy)
+	return err
+}
+
+func (r *PostgresRepository) UpdateFilter(ctx context.Context, id string, f *Filter) error {
+	_, err := r.pool.Exec(ctx, `UPDATE filters SET name=$1, enabled=$2, priority=$3, gmail_query=$4, updated_by=$5, updated_at=NOW() WHERE id=$6`,
+		f.Name, f.Enabled, f.Priority, f.GmailQuery, f.UpdatedBy, id)
+	return err
+}
+
+func (r *PostgresRepository) DeleteFilter(ctx context.Context, id string) error {
+	_, err := r.pool.Exec(ctx, `DELETE FROM filters WHERE id=$1`, id)
+	return err
+}
+
+func (r *PostgresRepository) CreateMailbox(ctx context.Context, m *Mailbox) error {
+	_, err := r.pool.Exec(ctx, `INSERT INTO mailboxes (email_address, refresh_token_secret, watch_labels, watch_label_filter_behavior, status, updated_by) VALUES ($1, $2, $3, $4, $5, $6)`,
+		m.EmailAddress, m.RefreshTokenSecret, []string(m.WatchLabels), m.WatchLabelFilter, m.Status, m.UpdatedBy)
+	return err
+}
+
+func (r *PostgresRepository) UpdateMailbox(ctx context.Context, id string, m *Mailbox) error {
+	_, err := r.pool.Exec(ctx, `UPDATE mailboxes SET email_address=$1, refresh_token_secret=$2, watch_labels=$3, watch_label_filter_behavior=$4, status=$5, updated_by=$6, updated_at=NOW() WHERE id=$7`,
+		m.EmailAddress, m.RefreshTokenSecret, []string(m.WatchLabels), m.WatchLabelFilter, m.Status, m.UpdatedBy, id)
+	return err
+}
+
+// GetDailyStats returns per-day stats. Without a mailboxID the rows of all mailboxes are
+// summed per day.
+func (r *PostgresRepository) GetDailyStats(ctx context.Context, from, to, mailboxID string) ([]DailyStat, error) {
+	query := `SELECT day, '', SUM(received)::int, SUM(processed_ok)::int, SUM(processed_error)::int, MAX(last_event_at) FROM stats_daily WHERE day >= $1 AND day <= $2 GROUP BY day ORDER BY day DESC`
+	args := []any{from, to}
+	if mailboxID != "" {
+		query = `SELECT day, mailbox_id::text, received, processed_ok, processed_error, last_event_at FROM stats_daily WHERE day >= $1 AND day <= $2 AND mailbox_id = $3 ORDER BY day DESC`
+		args = append(args, mailboxID)
+	}
+
+	rows, err := r.pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var stats []DailyStat
+	for rows.Next() {
+		var st DailyStat
+		var day time.Time
+		if err := rows.Scan(&day, &st.MailboxID, &st.Received, &st.ProcessedOk, &st.ProcessedError, &st.LastEventAt); err != nil {
+			return nil, err
+		}
+		st.Day = day.Format("2006-01-02")
+		stats = append(stats, st)
+	}
+	return stats, nil
+}
+
+const eventColumns = `id, COALESCE(message_id, ''), COALESCE(filter_id::text, ''), COALESCE(mailbox_id::text, ''), COALESCE(retry_of::text, ''),
+	status, COALESCE(error, ''), COALESCE(duration_ms, 0), details, created_at`
+
+func scanEvent(row pgx.Row) (*Event, error) {
+	var e Event
+	var details []byte
+	if err := row.Scan(&e.ID, &e.MessageID, &e.FilterID, &e.MailboxID, &e.RetryOf, &e.Status, &e.Error, &e.DurationMS, &details, &e.CreatedAt); err != nil {
+		return nil, err
+	}
+	if details != nil {
+		e.Details = &EventDetails{}
+		if err := json.Unmarshal(details, e.Details); err != nil {
+			return nil, fmt.Errorf("invalid details of event %s: %w", e.ID, err)
+		}
+	}
+	return &e, nil
+}
+
+// ListEvents returns a page of events, newest first. The page carries a cursor for the
+// next one when more events match.
+func (r *PostgresRepository) ListEvents(ctx context.Context, q EventQuery) (*EventPage, error) {
+	var where conditions
+	if len(q.Statuses) > 0 {
+		where.add("status = ANY(?)", q.Statuses)
+	} else if !q.Steps {
+		where.add("status = ANY(?)", EventOutcomes)
+	}
+	if q.MessageID != "" {
+		where.add("message_id = ?", q.MessageID)
+	}
+	if q.FilterID != "" {
+		where.add("filter_id = ?::uuid", q.FilterID)
+	}
+	if q.MailboxID != "" {
+		where.add("mailbox_id = ?::uuid", q.MailboxID)
+	}
+	if q.MailboxEmail != "" {
+		where.add("mailbox_id IN (SELECT id FROM mailboxes WHERE lower(email_address) = lower(?))", q.MailboxEmail)
+	}
+	if !q.Since.IsZero() {
+		where.add("created_at >= ?", q.Since)
+	}
+	if !q.Until.IsZero() {
+		where.add("created_at < ?", q.Until)
+	}
+	if q.ErrorText != "" {
+		where.add(`error ILIKE '%' || ? || '%'`, escapeLike(q.ErrorText))
+	}
+
+	page := &EventPage{Events: []Event{}}
+	if q.WithTotal {
+		var total int64
+		if err := r.pool.QueryRow(ctx, `SELECT COUNT(*) FROM events`+where.sql(), where.args...).Scan(&total); err != nil {
+			return nil, err
+		}
+		page.Total = &total
+	}
+
+	if q.After != nil {
+		where.add("(created_at, id) < (?, ?::uuid)", q.After.CreatedAt, q.After.ID)
+	}
+	// One more row than asked tells whether there is a next page.
+	args := append(where.args, q.Limit+1)
+	rows, err := r.pool.Query(ctx, `SELECT `+eventColumns+` FROM events`+where.sql()+
+		fmt.Sprintf(` ORDER BY created_at DESC, id DESC LIMIT $%d`, len(args)), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		e, err := scanEvent(rows)
+		if err != nil {
+			return nil, err
+		}
+		page.Events = append(page.Events, *e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(page.Events) > q.Limit {
+		page.Events = page.Events[:q.Limit]
+		last := page.Events[q.Limit-1]
+		page.NextCursor = EventCursor{CreatedAt: last.CreatedAt, ID: last.ID}.Encode()
+	}
+	return page, nil
+}
+
+// GetMessageEvents returns every event of a Gmail message, oldest first.
+func (r *PostgresRepository) GetMessageEvents(ctx context.Context, messageID string) ([]Event, error) {
+	rows, err := r.pool.Query(ctx, `SELECT `+eventColumns+` FROM events WHERE message_id = $1 ORDER BY created_at, id`, messageID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []Event
+	for rows.Next() {
+		e, err := scanEvent(rows)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, *e)
+	}
+	return events, rows.Err()
+}
+
+// conditions collects the WHERE clauses of a query. Each ? in a clause becomes the
+// positional parameter of the matching argument.
+type conditions struct {
+	clauses []string
+	args    []any
+}
+
+func (c *conditions) add(clause string, args ...any) {
+	for _, arg := range args {
+		c.args = append(c.args, arg)
+		clause = strings.Replace(clause, "?", "$"+strconv.Itoa(len(c.args)), 1)
+	}
+	c.clauses = append(c.clauses, clause)
+}
+
+func (c *conditions) sql() string {
+	if len(c.clauses) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(c.clauses, " AND ")
+}
+
+// escapeLike quotes the LIKE wildcards in s so it matches literally.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+func (r *PostgresRepository) CreateJob(ctx context.Context, j *Job) error {
+	return r.pool.QueryRow(ctx, `INSERT INTO jobs (type, params, requested_by) VALUES ($1, $2, $3) RETURNING id, status, created_at, updated_at`,
+		j.Type, string(j.Params), j.RequestedBy).Scan(&j.ID, &j.Status, &j.CreatedAt, &j.UpdatedAt)
+}
+
+// FailJob marks a job that never reached the worker (or was refused by it) as failed.
+func (r *PostgresRepository) FailJob(ctx context.Context, id, errMsg string) error {
+	_, err := r.pool.Exec(ctx, `UPDATE jobs SET status=$1, error=$2, finished_at=NOW(), updated_at=NOW() WHERE id=$3`, JobFailed, errMsg, id)
+	return err
+}
+
+const jobColumns = `id, type, params, COALESCE(requested_by, ''), status, progress, result, COALESCE(error, ''), created_at, started_at, finished_at, updated_at`
+
+func scanJob(row pgx.Row) (*Job, error) {
+	var j Job
+	var params, progress, result []byte
+	if err := row.Scan(&j.ID, &j.Type, &params, &j.RequestedBy, &j.Status, &progress, &result, &j.Error, &j.CreatedAt, &j.StartedAt, &j.FinishedAt, &j.UpdatedAt); err != nil {
+		return nil, err
+	}
+	j.Params, j.Progress, j.Result = params, progress, result
+	return &j, nil
+}
+
+// GetJob returns the job or nil when it does not exist.
+func (r *PostgresRepository) GetJob(ctx context.Context, id string) (*Job, error) {
+	j, err := scanJob(r.pool.QueryRow(ctx, `SELECT `+jobColumns+` FROM jobs WHERE id=$1`, id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	return j, err
+}
+
+// GetJobs lists the most recent jobs, optionally only those with the given status.
+func (r *PostgresRepository) GetJobs(ctx context.Context, status string, limit int) ([]Job, error) {
+	rows, err := r.pool.Query(ctx, `SELECT `+jobColumns+` FROM jobs WHERE ($1 = '' OR status = $1) ORDER BY created_at DESC LIMIT $2`, status, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var jobs []Job
+	for rows.Next() {
+		j, err := scanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, *j)
+	}
+	return jobs, rows.Err()
+}
+
+const processedEmailColumns = `id, message_id, COALESCE(thread_id, ''), history_id, COALESCE(mailbox_id::text, ''), label_ids,
+	COALESCE(from_address, ''), COALESCE(subject, ''), internal_date, COALESCE(size_estimate, 0), COALESCE(snippet, ''),
+	COALESCE(filter_id::text, ''), created_at`
+
+func scanProcessedEmail(row pgx.Row) (*ProcessedEmail, error) {
+	var e ProcessedEmail
+	var labels []string
+	err := row.Scan(&e.ID, &e.MessageID, &e.ThreadID, &e.HistoryID, &e.MailboxID, &labels,
+		&e.FromAddress, &e.Subject, &e.InternalDate, &e.SizeEstimate, &e.Snippet, &e.FilterID, &e.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	e.LabelIDs = labels
+	return &e, nil
+}
+
+// GetProcessedEmails lists recorded messages, newest first.
+func (r *PostgresRepository) GetProcessedEmails(ctx context.Context, q ProcessedEmailQuery) ([]ProcessedEmail, error) {
+	var since, until *time.Time
+	if !q.Since.IsZero() {
+		since = &q.Since
+	}
+	if !q.Until.IsZero() {
+		until = &q.Until
+	}
+	rows, err := r.pool.Query(ctx, `SELECT `+processedEmailColumns+` FROM processed_emails
+		WHERE ($1 = '' OR mailbox_id = $1::uuid)
+		AND ($2 = '' OR filter_id = $2::uuid)
+		AND ($3 = '' OR label_ids @> ARRAY[$3])
+		AND ($4 = '' OR lower(from_address) = lower($4))
+		AND ($5::timestamptz IS NULL OR created_at >= $5)
+		AND ($6::timestamptz IS NULL OR created_at < $6)
+		ORDER BY created_at DESC, id DESC LIMIT $7`,
+		q.MailboxID, q.FilterID, q.Label, q.FromAddress, since, until, q.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var emails []ProcessedEmail
+	for rows.Next() {
+		e, err := scanProcessedEmail(rows)
+		if err != nil {
+			return nil, err
+		}
+		emails = append(emails, *e)
+	}
+	return emails, rows.Err()
+}
+
+// GetProcessedEmail returns the record of a Gmail message or nil when there is none.
+func (r *PostgresRepository) GetProcessedEmail(ctx context.Context, messageID string) (*ProcessedEmail, error) {
+	e, err := scanProcessedEmail(r.pool.QueryRow(ctx, `SELECT `+processedEmailColumns+` FROM processed_emails WHERE message_id=$1`, messageID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	return e, err
+}
@@ -0,0 +1,38 @@
+package storage
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestConditions_NumbersParameters(t *testing.T) {
+	var c conditions
+	if got := c.sql(); got != "" {
+		t.Errorf("Expected no WHERE without conditions, got %q", got)
+	}
+	c.add("status = ANY(?)", []string{"error"})
+	c.add("(created_at, id) < (?, ?::uuid)", time.Time{}, "id")
+	c.add(`error ILIKE '%' || ? || '%'`, escapeLike(`50%_off\`))
+
+	want := ` WHERE status = ANY($1) AND (created_at, id) < ($2, $3::uuid) AND error ILIKE '%' || $4 || '%'`
+	if got := c.sql(); got != want {
+		t.Errorf("Unexpected SQL:\n got %s\nwant %s", got, want)
+	}
+	if got := c.args[3]; got != `50\%\_off\\` {
+		t.Errorf("Expected LIKE wildcards to be escaped, got %q", got)
+	}
+}
+
+func TestEventCursor_RoundTrip(t *testing.T) {
+	c := EventCursor{CreatedAt: time.Date(2026, 3, 1, 12, 0, 0, 123456789, time.UTC), ID: "0b5a3c1e-8f7d-4c2a-9e1b-2d3f4a5b6c7d"}
+	got, err := DecodeEventCursor(c.Encode())
+	if err != nil || !reflect.DeepEqual(got, c) {
+		t.Errorf("Expected %+v, got %+v, %v", c, got, err)
+	}
+	for _, bad := range []string{"", "!!", "bm8tc2VwYXJhdG9y"} {
+		if _, err := DecodeEventCursor(bad); err == nil {
+			t.Errorf("Expected an error for %q", bad)
+		}
+	}
+}
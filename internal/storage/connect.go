@@ -0,0 +1,142 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/cloudsqlconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// ConnOptions says how to reach Postgres. A DSN is used as is; otherwise one is built from
+// the host, port and credentials. With InstanceConnectionName set, connections are dialed
+// through the Cloud SQL connector (which encrypts them itself) and the host, port and TLS
+// settings are ignored.
+type ConnOptions struct {
+	DSN                    string
+	InstanceConnectionName string
+	Host                   string // defaults to localhost
+	Port                   int    // defaults to 5432
+	User                   string
+	Password               string
+	Name                   string
+	SSLMode                string // disable, prefer, require, verify-ca or verify-full; pgx defaults to prefer
+	SSLRootCert            string // CA file for verify-ca and verify-full
+
+	// Pool sizing; zero values keep the pgx defaults.
+	MaxConns        int
+	MinConns        int
+	MaxConnLifetime time.Duration
+	MaxConnIdleTime time.Duration
+}
+
+// Describe tells how the connection is made, without credentials, for logs.
+func (o ConnOptions) Describe() string {
+	switch {
+	case o.DSN != "":
+		return "DSN"
+	case o.InstanceConnectionName != "":
+		return "Cloud SQL instance " + o.InstanceConnectionName
+	default:
+		return "host " + o.hostPort()
+	}
+}
+
+func (o ConnOptions) hostPort() string {
+	host, port := o.Host, o.Port
+	if host == "" {
+		host = "localhost"
+	}
+	if port == 0 {
+		port = 5432
+	}
+	return net.JoinHostPort(host, strconv.Itoa(port))
+}
+
+func (o ConnOptions) connString() string {
+	if o.DSN != "" {
+		return o.DSN
+	}
+	if o.InstanceConnectionName != "" {
+		// The connector picks the address; only the credentials and database matter.
+		return fmt.Sprintf("user=%s password=%s dbname=%s", quoteConnValue(o.User), quoteConnValue(o.Password), quoteConnValue(o.Name))
+	}
+	u := url.URL{
+		Scheme: "postgres",
+		User:   url.UserPassword(o.User, o.Password),
+		Host:   o.hostPort(),
+		Path:   "/" + o.Name,
+	}
+	q := url.Values{}
+	if o.SSLMode != "" {
+		q.Set("sslmode", o.SSLMode)
+	}
+	if o.SSLRootCert != "" {
+		q.Set("sslrootcert", o.SSLRootCert)
+	}
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// connValueEscaper escapes a keyword/value connection string value for single quotes.
+var connValueEscaper = strings.NewReplacer(`\`, `\\`, `'`, `\'`)
+
+// quoteConnValue quotes a value of a keyword/value connection string, so spaces, '=' and
+// quotes in passwords survive parsing.
+func quoteConnValue(v string) string {
+	return "'" + connValueEscaper.Replace(v) + "'"
+}
+
+// Connect opens and pings a connection pool. The returned cleanup closes the pool and, if
+// one was created, the Cloud SQL dialer.
+func Connect(ctx context.Context, opts ConnOptions) (*pgxpool.Pool, func() error, error) {
+	config, err := pgxpool.ParseConfig(opts.connString())
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse connection string: %w", err)
+	}
+	if opts.MaxConns > 0 {
+		config.MaxConns = int32(opts.MaxConns)
+	}
+	if opts.MinConns > 0 {
+		config.MinConns = int32(opts.MinConns)
+	}
+	if opts.MaxConnLifetime > 0 {
+		config.MaxConnLifetime = opts.MaxConnLifetime
+	}
+	if opts.MaxConnIdleTime > 0 {
+		config.MaxConnIdleTime = opts.MaxConnIdleTime
+	}
+
+	closeDialer := func() error { return nil }
+	if opts.InstanceConnectionName != "" {
+		d, err := cloudsqlconn.NewDialer(ctx)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to init dialer: %w", err)
+		}
+		closeDialer = d.Close
+		config.ConnConfig.DialFunc = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return d.Dial(ctx, opts.InstanceConnectionName)
+		}
+	}
+
+	pool, err := pgxpool.NewWithConfig(ctx, config)
+	if err != nil {
+		closeDialer()
+		return nil, nil, fmt.Errorf("failed to connect to db: %w", err)
+	}
+	if err := pool.Ping(ctx); err != nil {
+		pool.Close()
+		closeDialer()
+		return nil, nil, fmt.Errorf("failed to ping db: %w", err)
+	}
+
+	return pool, func() error {
+		pool.Close()
+		return closeDialer()
+	}, nil
+}
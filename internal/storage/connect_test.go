@@ -0,0 +1,57 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+func TestConnOptions_ConnString(t *testing.T) {
+	tests := []struct {
+		name string
+		opts ConnOptions
+		want string
+	}{
+		{
+			name: "dsn wins",
+			opts: ConnOptions{DSN: "postgres://a@db/x", Host: "ignored", User: "ignored"},
+			want: "postgres://a@db/x",
+		},
+		{
+			name: "cloud sql ignores host",
+			opts: ConnOptions{InstanceConnectionName: "p:r:i", Host: "ignored", User: "u", Password: "p", Name: "n"},
+			want: "user='u' password='p' dbname='n'",
+		},
+		{
+			name: "host defaults",
+			opts: ConnOptions{User: "u", Password: "p", Name: "n"},
+			want: "postgres://u:p@localhost:5432/n",
+		},
+		{
+			name: "host and port with escaped password",
+			opts: ConnOptions{Host: "db", Port: 6543, User: "u", Password: "p@ss/word", Name: "n"},
+			want: "postgres://u:p%40ss%2Fword@db:6543/n",
+		},
+		{
+			name: "tls",
+			opts: ConnOptions{Host: "db", User: "u", Password: "p", Name: "n", SSLMode: "verify-full", SSLRootCert: "/ca.pem"},
+			want: "postgres://u:p@db:5432/n?sslmode=verify-full&sslrootcert=%2Fca.pem",
+		},
+	}
+	for _, tt := range tests {
+		if got := tt.opts.connString(); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestConnOptions_ConnStringQuotesCloudSQLCredentials(t *testing.T) {
+	opts := ConnOptions{InstanceConnectionName: "p:r:i", User: "svc user", Password: `it's a p=ss\word`, Name: "pos db"}
+	config, err := pgxpool.ParseConfig(opts.connString())
+	if err != nil {
+		t.Fatalf("ParseConfig: %v", err)
+	}
+	if c := config.ConnConfig; c.User != opts.User || c.Password != opts.Password || c.Database != opts.Name {
+		t.Errorf("Expected the credentials to round-trip, got user %q password %q database %q", c.User, c.Password, c.Database)
+	}
+}
@@ -0,0 +1,51 @@
+package storage
+
+import (
+	"os"
+	"strconv"
+	"time"
+)
+
+// ConnOptionsFromEnv reads the database settings shared by the worker, the admin and the
+// tools: DATABASE_URL (which wins), else INSTANCE_CONNECTION_NAME for the Cloud SQL
+// connector or DB_HOST and DB_PORT, with DB_USER, DB_PASS and DB_NAME. DB_PASSWORD and
+// DB_INSTANCE_CONNECTION_NAME are accepted when the primary names are unset.
+func ConnOptionsFromEnv() ConnOptions {
+	return ConnOptions{
+		DSN:                    os.Getenv("DATABASE_URL"),
+		InstanceConnectionName: firstEnv("INSTANCE_CONNECTION_NAME", "DB_INSTANCE_CONNECTION_NAME"),
+		Host:                   os.Getenv("DB_HOST"),
+		Port:                   envInt("DB_PORT", 5432),
+		User:                   os.Getenv("DB_USER"),
+		Password:               firstEnv("DB_PASS", "DB_PASSWORD"),
+		Name:                   os.Getenv("DB_NAME"),
+		SSLMode:                os.Getenv("DB_SSLMODE"),     // e.g. require or verify-full; ignored with the Cloud SQL connector
+		SSLRootCert:            os.Getenv("DB_SSLROOTCERT"), // CA file for verify-ca and verify-full
+		MaxConns:               envInt("DB_MAX_CONNS", 0),   // 0 keeps the pgx defaults
+		MinConns:               envInt("DB_MIN_CONNS", 0),
+		MaxConnLifetime:        time.Duration(envInt("DB_MAX_CONN_LIFETIME_MINUTES", 0)) * time.Minute,
+		MaxConnIdleTime:        time.Duration(envInt("DB_MAX_CONN_IDLE_MINUTES", 0)) * time.Minute,
+	}
+}
+
+// Configured reports whether a database is set: a DSN, a Cloud SQL instance or a host.
+func (o ConnOptions) Configured() bool {
+	return o.DSN != "" || o.InstanceConnectionName != "" || o.Host != ""
+}
+
+func firstEnv(keys ...string) string {
+	for _, k := range keys {
+		if v := os.Getenv(k); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+func envInt(key string, def int) int {
+	v, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return def
+	}
+	return v
+}
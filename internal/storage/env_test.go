@@ -0,0 +1,35 @@
+package storage
+
+import (
+	"testing"
+	"time"
+)
+
+func TestConnOptionsFromEnv(t *testing.T) {
+	t.Setenv("DATABASE_URL", "")
+	t.Setenv("INSTANCE_CONNECTION_NAME", "")
+	t.Setenv("DB_INSTANCE_CONNECTION_NAME", "p:r:i")
+	t.Setenv("DB_HOST", "db")
+	t.Setenv("DB_PORT", "6543")
+	t.Setenv("DB_USER", "u")
+	t.Setenv("DB_PASS", "pass")
+	t.Setenv("DB_PASSWORD", "password")
+	t.Setenv("DB_NAME", "n")
+	t.Setenv("DB_MAX_CONN_IDLE_MINUTES", "5")
+
+	got := ConnOptionsFromEnv()
+	if got.InstanceConnectionName != "p:r:i" {
+		t.Errorf("Expected DB_INSTANCE_CONNECTION_NAME as the fallback, got %q", got.InstanceConnectionName)
+	}
+	if got.Password != "pass" {
+		t.Errorf("Expected DB_PASS to win over DB_PASSWORD, got %q", got.Password)
+	}
+	if got.Host != "db" || got.Port != 6543 || got.User != "u" || got.Name != "n" || got.MaxConnIdleTime != 5*time.Minute {
+		t.Errorf("Unexpected options %+v", got)
+	}
+
+	t.Setenv("DB_PASS", "")
+	if got := ConnOptionsFromEnv(); got.Password != "password" {
+		t.Errorf("Expected DB_PASSWORD without DB_PASS, got %q", got.Password)
+	}
+}
@@ -2,19 +2,35 @@ package mocks
 
 import (
 	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 
 	"gagarin-soft/internal/storage"
 )
 
 type MockHistoryRepository struct {
 	mu           sync.Mutex
+	Mailboxes    []storage.Mailbox
 	SavedHistory []SavedEntry
 	SavedEmails  []storage.ProcessedEmail
+	Attachments  []storage.Attachment
+	Events       []storage.Event
+	Stats        []storage.DailyStat
+	Filters      []storage.Filter
+	Cursors      map[string]uint64
+	Deliveries   map[string]time.Time
+	Jobs         map[string]*storage.Job
+	LockHeld     bool // simulates another instance holding every advisory lock
+	SaveEmailErr error
 	Err          error
 }
 
 type SavedEntry struct {
+	MailboxID  string
 	HistoryID  uint64
 	Expiration int64
 }
@@ -23,10 +39,39 @@ func NewMockHistoryRepository() *MockHistoryRepository {
 	return &MockHistoryRepository{
 		SavedHistory: make([]SavedEntry, 0),
 		SavedEmails:  make([]storage.ProcessedEmail, 0),
+		Cursors:      make(map[string]uint64),
+		Deliveries:   make(map[string]time.Time),
+		Jobs:         make(map[string]*storage.Job),
 	}
 }
 
-func (m *MockHistoryRepository) SaveWatchStatus(ctx context.Context, historyID uint64, expiration int64) error {
+func (m *MockHistoryRepository) ListMailboxes(ctx context.Context) ([]storage.Mailbox, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	return m.Mailboxes, nil
+}
+
+func (m *MockHistoryRepository) GetMailboxByEmail(ctx context.Context, emailAddress string) (*storage.Mailbox, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	for i := range m.Mailboxes {
+		if strings.EqualFold(m.Mailboxes[i].EmailAddress, emailAddress) {
+			mb := m.Mailboxes[i]
+			return &mb, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *MockHistoryRepository) SaveWatchStatus(ctx context.Context, mailboxID string, historyID uint64, expiration int64) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
@@ -35,29 +80,382 @@ func (m *MockHistoryRepository) SaveWatchStatus(ctx context.Context, historyID u
 	}
 
 	m.SavedHistory = append(m.SavedHistory, SavedEntry{
+		MailboxID:  mailboxID,
 		HistoryID:  historyID,
 		Expiration: expiration,
 	})
 	return nil
 }
 
-func (m *MockHistoryRepository) SaveProcessedEmail(ctx context.Context, email storage.ProcessedEmail) error {
+func (m *MockHistoryRepository) GetLatestWatchHistoryID(ctx context.Context, mailboxID string) (uint64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.Err != nil {
+		return 0, m.Err
+	}
+	for i := len(m.SavedHistory) - 1; i >= 0; i-- {
+		if m.SavedHistory[i].MailboxID == mailboxID {
+			return m.SavedHistory[i].HistoryID, nil
+		}
+	}
+	return 0, nil
+}
+
+func (m *MockHistoryRepository) ListWatchExpirations(ctx context.Context) (map[string]int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	expirations := make(map[string]int64)
+	for _, e := range m.SavedHistory {
+		expirations[e.MailboxID] = e.Expiration
+	}
+	return expirations, nil
+}
+
+func (m *MockHistoryRepository) WithAdvisoryLock(ctx context.Context, key int64, fn func(ctx context.Context) error) (bool, error) {
+	m.mu.Lock()
+	if m.Err != nil {
+		defer m.mu.Unlock()
+		return false, m.Err
+	}
+	if m.LockHeld {
+		m.mu.Unlock()
+		return false, nil
+	}
+	m.LockHeld = true
+	m.mu.Unlock()
+
+	defer func() {
+		m.mu.Lock()
+		m.LockHeld = false
+		m.mu.Unlock()
+	}()
+	return true, fn(ctx)
+}
+
+func (m *MockHistoryRepository) GetSyncCursor(ctx context.Context, emailAddress string) (uint64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.Err != nil {
+		return 0, m.Err
+	}
+	return m.Cursors[strings.ToLower(emailAddress)], nil
+}
+
+func (m *MockHistoryRepository) SaveSyncCursor(ctx context.Context, emailAddress string, historyID uint64) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
 	if m.Err != nil {
 		return m.Err
 	}
-	m.SavedEmails = append(m.SavedEmails, email)
+	key := strings.ToLower(emailAddress)
+	if historyID > m.Cursors[key] {
+		m.Cursors[key] = historyID
+	}
 	return nil
 }
 
+func (m *MockHistoryRepository) SaveProcessedEmail(ctx context.Context, email *storage.ProcessedEmail, overwrite bool) (storage.SaveResult, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.Err != nil {
+		return storage.SaveUnchanged, m.Err
+	}
+	if m.SaveEmailErr != nil {
+		return storage.SaveUnchanged, m.SaveEmailErr
+	}
+	for i := range m.SavedEmails {
+		existing := &m.SavedEmails[i]
+		if existing.MessageID != email.MessageID {
+			continue
+		}
+		email.ID = existing.ID
+		if !overwrite && slices.Equal(existing.LabelIDs, email.LabelIDs) && existing.FilterID == email.FilterID && existing.MailboxID == email.MailboxID {
+			return storage.SaveUnchanged, nil
+		}
+		email.CreatedAt = existing.CreatedAt
+		*existing = *email
+		return storage.SaveUpdated, nil
+	}
+	email.ID = uint64(len(m.SavedEmails) + 1)
+	m.SavedEmails = append(m.SavedEmails, *email)
+	return storage.SaveCreated, nil
+}
+
+func (m *MockHistoryRepository) SaveAttachment(ctx context.Context, attachment storage.Attachment) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.Err != nil {
+		return m.Err
+	}
+	for _, a := range m.Attachments {
+		if a.MessageID == attachment.MessageID && a.Filename == attachment.Filename && a.SHA256 == attachment.SHA256 {
+			return nil
+		}
+	}
+	m.Attachments = append(m.Attachments, attachment)
+	return nil
+}
+
+func (m *MockHistoryRepository) ListAttachments(ctx context.Context, messageID string) ([]storage.Attachment, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	var found []storage.Attachment
+	for _, a := range m.Attachments {
+		if a.MessageID == messageID {
+			found = append(found, a)
+		}
+	}
+	return found, nil
+}
+
+func (m *MockHistoryRepository) ProcessedMessageIDs(ctx context.Context, messageIDs []string) (map[string]bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	seen := make(map[string]bool)
+	for _, id := range messageIDs {
+		for _, e := range m.SavedEmails {
+			if e.MessageID == id {
+				seen[id] = true
+			}
+		}
+	}
+	return seen, nil
+}
+
+func (m *MockHistoryRepository) ListProcessedEmails(ctx context.Context, from, to time.Time, limit int) ([]storage.ProcessedEmail, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	var emails []storage.ProcessedEmail
+	for _, e := range m.SavedEmails {
+		if !e.CreatedAt.Before(from) && e.CreatedAt.Before(to) {
+			emails = append(emails, e)
+		}
+	}
+	sort.SliceStable(emails, func(i, j int) bool { return emails[i].CreatedAt.Before(emails[j].CreatedAt) })
+	if len(emails) > limit {
+		emails = emails[:limit]
+	}
+	return emails, nil
+}
+
+func (m *MockHistoryRepository) ListEnabledFilters(ctx context.Context) ([]storage.Filter, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	var enabled []storage.Filter
+	for _, f := range m.Filters {
+		if f.Enabled {
+			enabled = append(enabled, f)
+		}
+	}
+	sort.SliceStable(enabled, func(i, j int) bool { return enabled[i].Priority < enabled[j].Priority })
+	return enabled, nil
+}
+
 func (m *MockHistoryRepository) RecordEvent(ctx context.Context, event storage.Event) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	return m.Err
+
+	if m.Err != nil {
+		return m.Err
+	}
+	if event.ID == "" {
+		event.ID = fmt.Sprintf("event-%d", len(m.Events)+1)
+	}
+	m.Events = append(m.Events, event)
+	return nil
+}
+
+func (m *MockHistoryRepository) GetEvents(ctx context.Context, ids []string) ([]storage.Event, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	var events []storage.Event
+	for _, e := range m.Events {
+		for _, id := range ids {
+			if e.ID == id {
+				events = append(events, e)
+			}
+		}
+	}
+	return events, nil
+}
+
+// LatestEventIDs relies on Events being in insertion order.
+func (m *MockHistoryRepository) LatestEventIDs(ctx context.Context, messageIDs []string) (map[string]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	latest := make(map[string]string)
+	for _, e := range m.Events {
+		for _, id := range messageIDs {
+			if e.MessageID == id && e.ID != "" && storage.IsOutcome(e.Status) {
+				latest[id] = e.ID
+			}
+		}
+	}
+	return latest, nil
+}
+
+func (m *MockHistoryRepository) LatestDecision(ctx context.Context, messageID string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.Err != nil {
+		return "", m.Err
+	}
+	status := ""
+	for _, e := range m.Events {
+		if e.MessageID == messageID && storage.IsDecision(e.Status) {
+			status = e.Status
+		}
+	}
+	return status, nil
+}
+
+func (m *MockHistoryRepository) UpdateDailyStats(ctx context.Context, mailboxID string, received, processedOk, processedError int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.Err != nil {
+		return m.Err
+	}
+	m.Stats = append(m.Stats, storage.DailyStat{
+		MailboxID:      mailboxID,
+		Received:       received,
+		ProcessedOk:    processedOk,
+		ProcessedError: processedError,
+	})
+	return nil
+}
+
+func (m *MockHistoryRepository) ClaimDelivery(ctx context.Context, deliveryID string, ttl time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.Err != nil {
+		return false, m.Err
+	}
+	if at, ok := m.Deliveries[deliveryID]; ok && time.Since(at) < ttl {
+		return false, nil
+	}
+	m.Deliveries[deliveryID] = time.Now()
+	return true, nil
+}
+
+func (m *MockHistoryRepository) ReleaseDelivery(ctx context.Context, deliveryID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.Err != nil {
+		return m.Err
+	}
+	delete(m.Deliveries, deliveryID)
+	return nil
+}
+
+func (m *MockHistoryRepository) PurgeDeliveries(ctx context.Context, before time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.Err != nil {
+		return 0, m.Err
+	}
+	var n int64
+	for id, at := range m.Deliveries {
+		if at.Before(before) {
+			delete(m.Deliveries, id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+// StartJob moves a job to running, creating it first so tests need not seed the admin side.
+func (m *MockHistoryRepository) StartJob(ctx context.Context, jobID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.Err != nil {
+		return m.Err
+	}
+	job, ok := m.Jobs[jobID]
+	if !ok {
+		job = &storage.Job{ID: jobID, Status: storage.JobQueued}
+		m.Jobs[jobID] = job
+	}
+	if job.Status == storage.JobQueued {
+		now := time.Now()
+		job.Status = storage.JobRunning
+		job.StartedAt = &now
+		job.UpdatedAt = now
+	}
+	return nil
+}
+
+func (m *MockHistoryRepository) UpdateJobProgress(ctx context.Context, jobID string, progress []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.Err != nil {
+		return m.Err
+	}
+	if job, ok := m.Jobs[jobID]; ok && job.Status == storage.JobRunning {
+		job.Progress = append([]byte(nil), progress...)
+		job.UpdatedAt = time.Now()
+	}
+	return nil
 }
 
-func (m *MockHistoryRepository) UpdateDailyStats(ctx context.Context, received, processedOk, processedError int) error {
-	return m.Err
+func (m *MockHistoryRepository) FinishJob(ctx context.Context, jobID string, result []byte, errMsg string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.Err != nil {
+		return m.Err
+	}
+	job, ok := m.Jobs[jobID]
+	if !ok {
+		return nil
+	}
+	now := time.Now()
+	job.Status = storage.JobSucceeded
+	if errMsg != "" {
+		job.Status = storage.JobFailed
+	}
+	job.Result = append([]byte(nil), result...)
+	job.Error = errMsg
+	job.FinishedAt = &now
+	job.UpdatedAt = now
+	return nil
 }
@@ -0,0 +1,180 @@
+package storage
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// The models below are shared by the worker (through GORM) and the admin service (through
+// pgx). Their tables are created by the SQL migrations in /migrations.
+
+// Mailbox maps to the 'mailboxes' registry managed by the admin service
+type Mailbox struct {
+	ID                 string      `gorm:"type:uuid;primaryKey" json:"id"`
+	EmailAddress       string      `json:"email_address"`
+	RefreshTokenSecret string      `json:"refresh_token_secret"`
+	WatchLabels        StringArray `json:"watch_labels"`                                                          // label names or IDs; empty uses the config
+	WatchLabelFilter   string      `gorm:"column:watch_label_filter_behavior" json:"watch_label_filter_behavior"` // "include" or "exclude"; empty uses the config
+	Status             string      `json:"status"`                                                                // active, disabled
+	CreatedAt          time.Time   `json:"created_at"`
+	UpdatedAt          time.Time   `json:"updated_at"`
+	UpdatedBy          string      `json:"updated_by"`
+}
+
+// ProcessedEmail maps to 'processed_emails', a message the worker matched and recorded.
+type ProcessedEmail struct {
+	ID           uint64      `gorm:"primaryKey" json:"id"`
+	MessageID    string      `json:"message_id"`
+	ThreadID     string      `json:"thread_id,omitempty"`
+	HistoryID    uint64      `json:"history_id"`
+	MailboxID    string      `json:"mailbox_id,omitempty"` // Empty for the legacy single-mailbox setup
+	LabelIDs     StringArray `json:"label_ids"`
+	FromAddress  string      `json:"from_address,omitempty"`
+	Subject      string      `json:"subject,omitempty"`
+	InternalDate *time.Time  `json:"internal_date,omitempty"` // Nil for rows recorded before it was stored
+	SizeEstimate int64       `json:"size_estimate,omitempty"`
+	Snippet      string      `json:"snippet,omitempty"`
+	FilterID     string      `json:"filter_id,omitempty"` // Filter that matched; empty when matched by TargetGmailLabel
+	CreatedAt    time.Time   `json:"created_at"`
+}
+
+// Attachment is the metadata of an attachment whose content lives in the blob store.
+type Attachment struct {
+	ID               uint64 `gorm:"primaryKey"`
+	ProcessedEmailID uint64
+	MessageID        string
+	Filename         string
+	MimeType         string
+	Size             int64
+	SHA256           string `gorm:"column:sha256"`
+	StorageKey       string
+	CreatedAt        time.Time
+}
+
+// Filter maps to the 'filters' table managed by the admin service
+type Filter struct {
+	ID         string    `gorm:"type:uuid;primaryKey" json:"id"`
+	Name       string    `json:"name"`
+	Enabled    bool      `json:"enabled"`
+	Priority   int       `json:"priority"`
+	GmailQuery string    `json:"gmail_query"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+	UpdatedBy  string    `json:"updated_by"`
+}
+
+// Event maps to the 'events' table. A processing attempt of a message ends with one
+// outcome event; the step events recorded before it trace how the attempt got there.
+type Event struct {
+	ID         string        `gorm:"type:uuid;default:gen_random_uuid();primaryKey" json:"id"`
+	MessageID  string        `json:"message_id"`
+	MailboxID  string        `json:"mailbox_id,omitempty"` // Optional
+	FilterID   string        `json:"filter_id,omitempty"`  // Optional
+	RetryOf    string        `json:"retry_of,omitempty"`   // Optional; the earlier event of the message this attempt reprocesses
+	Status     string        `json:"status"`
+	Error      string        `json:"error,omitempty"`
+	DurationMS int64         `gorm:"column:duration_ms" json:"duration_ms,omitempty"` // the step, or the whole attempt for outcomes
+	Details    *EventDetails `gorm:"type:jsonb;serializer:json" json:"details,omitempty"`
+	CreatedAt  time.Time     `json:"created_at"`
+}
+
+// EventDetails are the specifics of a step event; only the fields of its step are set.
+type EventDetails struct {
+	// Attempt is the ID shared by all events of one processing attempt, outcomes included.
+	Attempt string `json:"attempt,omitempty"`
+	// Trigger is what brought the message up: push, resync or reprocess (fetched).
+	Trigger string `json:"trigger,omitempty"`
+	// HistoryTypes are the Gmail history changes of a push (fetched).
+	HistoryTypes []string `json:"history_types,omitempty"`
+	// LabelIDs are the labels changed by those history records (fetched), or the labels
+	// written back (labeled).
+	LabelIDs []string `json:"label_ids,omitempty"`
+	// RemovedLabelIDs are the writeback labels taken off the message (labeled).
+	RemovedLabelIDs []string `json:"removed_label_ids,omitempty"`
+	// Filter is the name of the matched filter (matched).
+	Filter string `json:"filter,omitempty"`
+	// FiltersChecked are the names of the enabled filters tried, in priority order (unmatched).
+	FiltersChecked []string `json:"filters_checked,omitempty"`
+	// Reason explains a miss (unmatched), or notes what went wrong without failing the step.
+	Reason string `json:"reason,omitempty"`
+	// Result is created, updated or unchanged (saved).
+	Result string `json:"result,omitempty"`
+	// Attachments is the number of attachments stored (saved).
+	Attachments int `json:"attachments,omitempty"`
+}
+
+// Job maps to the 'jobs' table: a long-running admin action executed by the worker.
+// Params, Progress and Result hold JSON.
+type Job struct {
+	ID          string          `gorm:"type:uuid;primaryKey" json:"id"`
+	Type        string          `json:"type"`
+	Params      json.RawMessage `gorm:"type:jsonb" json:"params"`
+	RequestedBy string          `json:"requested_by"`
+	Status      string          `json:"status"`
+	Progress    json.RawMessage `gorm:"type:jsonb" json:"progress,omitempty"`
+	Result      json.RawMessage `gorm:"type:jsonb" json:"result,omitempty"`
+	Error       string          `json:"error,omitempty"`
+	CreatedAt   time.Time       `json:"created_at"`
+	StartedAt   *time.Time      `json:"started_at,omitempty"`
+	FinishedAt  *time.Time      `json:"finished_at,omitempty"`
+	UpdatedAt   time.Time       `json:"updated_at"`
+}
+
+// DailyStat maps to the 'stats_daily' table
+type DailyStat struct {
+	Day            string    `gorm:"type:date" json:"day"` // YYYY-MM-DD
+	MailboxID      string    `json:"mailbox_id,omitempty"`
+	Received       int       `json:"received"`
+	ProcessedOk    int       `json:"processed_ok"`
+	ProcessedError int       `json:"processed_error"`
+	LastEventAt    time.Time `json:"last_event_at"`
+}
+
+type GmailWatchHistory struct {
+	ID         uint64 `gorm:"primaryKey"`
+	MailboxID  string `gorm:"index"`
+	HistoryID  uint64 `gorm:"not null"`
+	Expiration int64  `gorm:"not null"`
+	CreatedAt  time.Time
+}
+
+// GmailSyncCursor is the last history ID whose changes have been fully committed for a mailbox.
+// Incremental syncs start from here rather than from the historyId carried by the push.
+// EmailAddress is stored lowercased.
+type GmailSyncCursor struct {
+	EmailAddress string `gorm:"primaryKey"`
+	HistoryID    uint64 `gorm:"not null"`
+	UpdatedAt    time.Time
+}
+
+// PubSubDelivery records a Pub/Sub message ID that has been accepted, so redeliveries of
+// the same push are dropped. Rows expire after the dedup TTL.
+type PubSubDelivery struct {
+	MessageID  string    `gorm:"primaryKey"`
+	ReceivedAt time.Time `gorm:"not null;index"`
+}
+
+func (PubSubDelivery) TableName() string {
+	return "pubsub_deliveries"
+}
+
+// TableName overrides the default pluralization if needed, though 'events' and 'stats_daily' are standard.
+func (Event) TableName() string {
+	return "events"
+}
+
+func (DailyStat) TableName() string {
+	return "stats_daily"
+}
+
+func (Filter) TableName() string {
+	return "filters"
+}
+
+func (Mailbox) TableName() string {
+	return "mailboxes"
+}
+
+func (Job) TableName() string {
+	return "jobs"
+}
@@ -2,99 +2,296 @@ package storage
 
 import (
 	"context"
-	"database/sql"
 	"fmt"
-	"net"
+	"log"
+	"strings"
 	"time"
 
-	"cloud.google.com/go/cloudsqlconn"
-	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/jackc/pgx/v5/stdlib"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"gagarin-soft/internal/migrate"
+	"gagarin-soft/migrations"
 )
 
-type GmailWatchHistory struct {
-	ID         uint64 `gorm:"primaryKey"`
-	HistoryID  uint64 `gorm:"not null"`
-	Expiration int64  `gorm:"not null"`
-	CreatedAt  time.Time
+// PostgresRepository implements both HistoryRepository and AdminRepository on one
+// connection pool. The worker side goes through GORM, the admin side through pgx.
+type PostgresRepository struct {
+	pool *pgxpool.Pool
+	db   *gorm.DB
 }
 
-// TableName overrides the default pluralization if needed, though 'events' and 'stats_daily' are standard.
-func (Event) TableName() string {
-	return "events"
-}
+var (
+	_ HistoryRepository = (*PostgresRepository)(nil)
+	_ AdminRepository   = (*PostgresRepository)(nil)
+)
+
+// NewPostgresRepository connects as described by opts. The returned cleanup closes the
+// connections.
+func NewPostgresRepository(ctx context.Context, opts ConnOptions) (*PostgresRepository, func() error, error) {
+	pool, cleanup, err := Connect(ctx, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sqlDB := stdlib.OpenDBFromPool(pool)
+	gormDB, err := gorm.Open(postgres.New(postgres.Config{
+		Conn: sqlDB,
+	}), &gorm.Config{})
+	if err != nil {
+		sqlDB.Close()
+		cleanup()
+		return nil, nil, fmt.Errorf("failed to open gorm connection: %w", err)
+	}
 
-func (DailyStat) TableName() string {
-	return "stats_daily"
+	return &PostgresRepository{pool: pool, db: gormDB}, func() error {
+		sqlDB.Close()
+		return cleanup()
+	}, nil
 }
 
-type PostgresRepository struct {
-	db *gorm.DB
+// Migrator returns a migration runner for the repository's database; see cmd/migrate.
+func (r *PostgresRepository) Migrator() (*migrate.Migrator, error) {
+	sqlDB, err := r.db.DB()
+	if err != nil {
+		return nil, err
+	}
+	m, err := migrate.New(sqlDB, migrations.FS)
+	if err != nil {
+		return nil, err
+	}
+	m.Logf = log.Printf
+	return m, nil
 }
 
-func NewPostgresRepository(ctx context.Context, instanceConnectionName, dbUser, dbPass, dbName string) (*PostgresRepository, func() error, error) {
-	d, err := cloudsqlconn.NewDialer(ctx)
+// Migrate applies pending schema migrations.
+func (r *PostgresRepository) Migrate(ctx context.Context) error {
+	m, err := r.Migrator()
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to init dialer: %w", err)
+		return err
 	}
+	_, err = m.Up(ctx)
+	return err
+}
 
-	// Cleanup function to close the dialer
-	cleanup := func() error {
-		return d.Close()
+func (r *PostgresRepository) ListMailboxes(ctx context.Context) ([]Mailbox, error) {
+	var mailboxes []Mailbox
+	err := r.db.WithContext(ctx).Order("email_address ASC").Find(&mailboxes).Error
+	return mailboxes, err
+}
+
+func (r *PostgresRepository) GetMailboxByEmail(ctx context.Context, emailAddress string) (*Mailbox, error) {
+	var mailboxes []Mailbox
+	err := r.db.WithContext(ctx).Where("LOWER(email_address) = LOWER(?)", emailAddress).Limit(1).Find(&mailboxes).Error
+	if err != nil || len(mailboxes) == 0 {
+		return nil, err
 	}
+	return &mailboxes[0], nil
+}
 
-	dsn := fmt.Sprintf("user=%s password=%s database=%s", dbUser, dbPass, dbName)
-	config, err := pgx.ParseConfig(dsn)
-	if err != nil {
-		cleanup()
-		return nil, nil, fmt.Errorf("failed to parse pgx config: %w", err)
+func (r *PostgresRepository) SaveWatchStatus(ctx context.Context, mailboxID string, historyID uint64, expiration int64) error {
+	entry := GmailWatchHistory{
+		MailboxID:  mailboxID,
+		HistoryID:  historyID,
+		Expiration: expiration,
+		CreatedAt:  time.Now(),
 	}
+	return r.db.WithContext(ctx).Create(&entry).Error
+}
 
-	// Configure the dialer
-	config.DialFunc = func(ctx context.Context, network, addr string) (net.Conn, error) {
-		return d.Dial(ctx, instanceConnectionName)
+func (r *PostgresRepository) GetLatestWatchHistoryID(ctx context.Context, mailboxID string) (uint64, error) {
+	var entry GmailWatchHistory
+	err := r.db.WithContext(ctx).Where("COALESCE(mailbox_id, '') = ?", mailboxID).Order("created_at DESC").Limit(1).Find(&entry).Error
+	if err != nil {
+		return 0, err
 	}
+	return entry.HistoryID, nil
+}
 
-	dbURI := stdlib.RegisterConnConfig(config)
-	sqlDB, err := sql.Open("pgx", dbURI)
+// ListWatchExpirations returns the expiration (ms since epoch) of the latest watch of each
+// mailbox, keyed by mailbox ID ("" for the legacy mailbox).
+func (r *PostgresRepository) ListWatchExpirations(ctx context.Context) (map[string]int64, error) {
+	var rows []struct {
+		MailboxID  string
+		Expiration int64
+	}
+	err := r.db.WithContext(ctx).Model(&GmailWatchHistory{}).
+		Select("DISTINCT ON (COALESCE(mailbox_id, '')) COALESCE(mailbox_id, '') AS mailbox_id, expiration").
+		Order("COALESCE(mailbox_id, ''), created_at DESC").
+		Scan(&rows).Error
 	if err != nil {
-		cleanup()
-		return nil, nil, fmt.Errorf("failed to open sql connection: %w", err)
+		return nil, err
+	}
+	expirations := make(map[string]int64, len(rows))
+	for _, row := range rows {
+		expirations[row.MailboxID] = row.Expiration
 	}
+	return expirations, nil
+}
 
-	gormDB, err := gorm.Open(postgres.New(postgres.Config{
-		Conn: sqlDB,
-	}), &gorm.Config{})
+// WithAdvisoryLock runs fn while holding the session-level Postgres advisory lock key. It
+// returns false without calling fn when another session holds the lock.
+func (r *PostgresRepository) WithAdvisoryLock(ctx context.Context, key int64, fn func(ctx context.Context) error) (bool, error) {
+	sqlDB, err := r.db.DB()
 	if err != nil {
-		cleanup()
-		return nil, nil, fmt.Errorf("failed to open gorm connection: %w", err)
+		return false, err
+	}
+	// Advisory locks belong to a session, so lock and unlock on the same connection.
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		return false, err
 	}
+	defer conn.Close()
 
-	// AutoMigrate
-	if err := gormDB.AutoMigrate(&GmailWatchHistory{}, &ProcessedEmail{}); err != nil {
-		cleanup()
-		return nil, nil, fmt.Errorf("failed to migrate database: %w", err)
+	var acquired bool
+	if err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", key).Scan(&acquired); err != nil {
+		return false, err
 	}
+	if !acquired {
+		return false, nil
+	}
+	defer func() {
+		// The caller's ctx may be done by now; the lock must still be released.
+		if _, err := conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", key); err != nil {
+			log.Printf("Failed to release advisory lock %d: %v", key, err)
+		}
+	}()
 
-	return &PostgresRepository{db: gormDB}, cleanup, nil
+	return true, fn(ctx)
 }
 
-func (r *PostgresRepository) SaveWatchStatus(ctx context.Context, historyID uint64, expiration int64) error {
-	entry := GmailWatchHistory{
-		HistoryID:  historyID,
-		Expiration: expiration,
-		CreatedAt:  time.Now(),
+// GetSyncCursor reads the cursor of a mailbox. Cursors are keyed by the lowercased address,
+// like mailboxes are matched, so pushes naming the address in any case share one.
+func (r *PostgresRepository) GetSyncCursor(ctx context.Context, emailAddress string) (uint64, error) {
+	var cursor GmailSyncCursor
+	err := r.db.WithContext(ctx).Where("email_address = ?", strings.ToLower(emailAddress)).Limit(1).Find(&cursor).Error
+	if err != nil {
+		return 0, err
 	}
-	return r.db.WithContext(ctx).Create(&entry).Error
+	return cursor.HistoryID, nil
+}
+
+func (r *PostgresRepository) SaveSyncCursor(ctx context.Context, emailAddress string, historyID uint64) error {
+	// The cursor only moves forward: a late or replayed push must not rewind it.
+	query := `
+		INSERT INTO gmail_sync_cursors (email_address, history_id, updated_at)
+		VALUES (?, ?, NOW())
+		ON CONFLICT (email_address) DO UPDATE SET
+			history_id = GREATEST(gmail_sync_cursors.history_id, excluded.history_id),
+			updated_at = NOW();
+	`
+	return r.db.WithContext(ctx).Exec(query, strings.ToLower(emailAddress), historyID).Error
 }
 
-func (r *PostgresRepository) SaveProcessedEmail(ctx context.Context, email ProcessedEmail) error {
+// SaveProcessedEmail upserts the message by Gmail message ID. Unless overwrite is set, an
+// existing row is only rewritten when its labels, filter or mailbox differ, so processing
+// the same message again is reported as SaveUnchanged.
+func (r *PostgresRepository) SaveProcessedEmail(ctx context.Context, email *ProcessedEmail, overwrite bool) (SaveResult, error) {
 	if email.CreatedAt.IsZero() {
 		email.CreatedAt = time.Now()
 	}
-	return r.db.WithContext(ctx).Create(&email).Error
+
+	var row struct {
+		ID       uint64
+		Inserted bool
+	}
+	res := r.db.WithContext(ctx).Raw(`
+		INSERT INTO processed_emails (message_id, thread_id, history_id, mailbox_id, label_ids, from_address,
+			subject, internal_date, size_estimate, snippet, filter_id, created_at)
+		VALUES (?, ?, ?, NULLIF(?, '')::uuid, ?, ?, ?, ?, ?, ?, NULLIF(?, '')::uuid, ?)
+		ON CONFLICT (message_id) DO UPDATE SET
+			thread_id = EXCLUDED.thread_id,
+			history_id = EXCLUDED.history_id,
+			mailbox_id = EXCLUDED.mailbox_id,
+			label_ids = EXCLUDED.label_ids,
+			from_address = EXCLUDED.from_address,
+			subject = EXCLUDED.subject,
+			internal_date = EXCLUDED.internal_date,
+			size_estimate = EXCLUDED.size_estimate,
+			snippet = EXCLUDED.snippet,
+			filter_id = EXCLUDED.filter_id
+		WHERE ? OR (processed_emails.label_ids, processed_emails.filter_id, processed_emails.mailbox_id)
+			IS DISTINCT FROM (EXCLUDED.label_ids, EXCLUDED.filter_id, EXCLUDED.mailbox_id)
+		RETURNING id, (xmax = 0) AS inserted`,
+		email.MessageID, email.ThreadID, email.HistoryID, email.MailboxID, email.LabelIDs, email.FromAddress,
+		email.Subject, email.InternalDate, email.SizeEstimate, email.Snippet, email.FilterID, email.CreatedAt, overwrite,
+	).Scan(&row)
+	if res.Error != nil {
+		return SaveUnchanged, res.Error
+	}
+
+	if res.RowsAffected == 0 {
+		// Conflict without changes: nothing is returned, look up the existing row's ID.
+		err := r.db.WithContext(ctx).Model(&ProcessedEmail{}).
+			Where("message_id = ?", email.MessageID).
+			Pluck("id", &email.ID).Error
+		return SaveUnchanged, err
+	}
+	email.ID = row.ID
+	if row.Inserted {
+		return SaveCreated, nil
+	}
+	return SaveUpdated, nil
+}
+
+func (r *PostgresRepository) SaveAttachment(ctx context.Context, attachment Attachment) error {
+	if attachment.CreatedAt.IsZero() {
+		attachment.CreatedAt = time.Now()
+	}
+	// The same file on the same message is stored once, even if the message is processed again.
+	return r.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(&attachment).Error
+}
+
+// ListAttachments returns the attachments already stored for a message.
+func (r *PostgresRepository) ListAttachments(ctx context.Context, messageID string) ([]Attachment, error) {
+	var attachments []Attachment
+	err := r.db.WithContext(ctx).Where("message_id = ?", messageID).Order("id").Find(&attachments).Error
+	return attachments, err
+}
+
+func (r *PostgresRepository) ProcessedMessageIDs(ctx context.Context, messageIDs []string) (map[string]bool, error) {
+	seen := make(map[string]bool)
+	if len(messageIDs) == 0 {
+		return seen, nil
+	}
+	var found []string
+	err := r.db.WithContext(ctx).Model(&ProcessedEmail{}).
+		Where("message_id IN ?", messageIDs).
+		Pluck("message_id", &found).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range found {
+		seen[id] = true
+	}
+	return seen, nil
+}
+
+// ListProcessedEmails returns the messages recorded in [from, to), oldest first.
+func (r *PostgresRepository) ListProcessedEmails(ctx context.Context, from, to time.Time, limit int) ([]ProcessedEmail, error) {
+	var emails []ProcessedEmail
+	err := r.db.WithContext(ctx).
+		Select(`id, message_id, COALESCE(thread_id, '') AS thread_id, history_id,
+			COALESCE(mailbox_id::text, '') AS mailbox_id, label_ids, COALESCE(from_address, '') AS from_address,
+			COALESCE(subject, '') AS subject, internal_date, COALESCE(size_estimate, 0) AS size_estimate,
+			COALESCE(snippet, '') AS snippet, COALESCE(filter_id::text, '') AS filter_id, created_at`).
+		Where("created_at >= ? AND created_at < ?", from, to).
+		Order("created_at ASC").Order("id ASC").
+		Limit(limit).
+		Find(&emails).Error
+	return emails, err
+}
+
+func (r *PostgresRepository) ListEnabledFilters(ctx context.Context) ([]Filter, error) {
+	var filters []Filter
+	err := r.db.WithContext(ctx).
+		Where("enabled = ?", true).
+		Order("priority ASC").Order("created_at ASC").
+		Find(&filters).Error
+	return filters, err
 }
 
 func (r *PostgresRepository) RecordEvent(ctx context.Context, event Event) error {
@@ -104,22 +301,156 @@ func (r *PostgresRepository) RecordEvent(ctx context.Context, event Event) error
 	// 'events' table uses UUID default gen_random_uuid(), so we check if ID is empty, let DB handle it.
 	// However, GORM might try to insert zero value.
 	// Best to use a map or Omit ID if empty.
-	return r.db.WithContext(ctx).Omit("ID").Create(&event).Error
+	// filter_id and mailbox_id are nullable UUID columns, so empty values have to be left out as well.
+	omit := []string{"ID"}
+	if event.FilterID == "" {
+		omit = append(omit, "FilterID")
+	}
+	if event.MailboxID == "" {
+		omit = append(omit, "MailboxID")
+	}
+	if event.RetryOf == "" {
+		omit = append(omit, "RetryOf")
+	}
+	if event.Details == nil {
+		omit = append(omit, "Details")
+	}
+	return r.db.WithContext(ctx).Omit(omit...).Create(&event).Error
+}
+
+func (r *PostgresRepository) GetEvents(ctx context.Context, ids []string) ([]Event, error) {
+	var events []Event
+	if len(ids) == 0 {
+		return events, nil
+	}
+	err := r.db.WithContext(ctx).Raw(`
+		SELECT id, COALESCE(message_id, '') AS message_id, COALESCE(mailbox_id::text, '') AS mailbox_id,
+			COALESCE(filter_id::text, '') AS filter_id, COALESCE(retry_of::text, '') AS retry_of,
+			status, COALESCE(error, '') AS error, COALESCE(duration_ms, 0) AS duration_ms, details, created_at
+		FROM events WHERE id IN ?`, ids,
+	).Scan(&events).Error
+	return events, err
+}
+
+// LatestDecision returns the status of the most recent outcome or unmatched event of the
+// message, or "" when there is none.
+func (r *PostgresRepository) LatestDecision(ctx context.Context, messageID string) (string, error) {
+	var statuses []string
+	err := r.db.WithContext(ctx).Raw(`
+		SELECT status FROM events WHERE message_id = ? AND status IN ?
+		ORDER BY created_at DESC LIMIT 1`, messageID, append([]string{EventUnmatched}, EventOutcomes...),
+	).Scan(&statuses).Error
+	if err != nil || len(statuses) == 0 {
+		return "", err
+	}
+	return statuses[0], nil
+}
+
+// LatestEventIDs returns the ID of the most recent outcome event of each message that has one.
+func (r *PostgresRepository) LatestEventIDs(ctx context.Context, messageIDs []string) (map[string]string, error) {
+	latest := make(map[string]string)
+	if len(messageIDs) == 0 {
+		return latest, nil
+	}
+	var rows []struct {
+		MessageID string
+		ID        string
+	}
+	err := r.db.WithContext(ctx).Raw(`
+		SELECT DISTINCT ON (message_id) message_id, id
+		FROM events WHERE message_id IN ? AND status IN ?
+		ORDER BY message_id, created_at DESC`, messageIDs, EventOutcomes,
+	).Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		latest[row.MessageID] = row.ID
+	}
+	return latest, nil
 }
 
-func (r *PostgresRepository) UpdateDailyStats(ctx context.Context, received, processedOk, processedError int) error {
+func (r *PostgresRepository) UpdateDailyStats(ctx context.Context, mailboxID string, received, processedOk, processedError int) error {
 	day := time.Now().Format("2006-01-02")
+	var mailbox any
+	if mailboxID != "" {
+		mailbox = mailboxID
+	}
 
 	// Atomic upsert via raw SQL because GORM upsert with increments is verbose
-	// "stats_daily" (day, received, processed_ok, processed_error, last_event_at)
+	// "stats_daily" (day, mailbox_id, received, processed_ok, processed_error, last_event_at)
+	// The conflict target matches the idx_stats_daily_day_mailbox expression index.
 	query := `
-		INSERT INTO stats_daily (day, received, processed_ok, processed_error, last_event_at)
-		VALUES (?, ?, ?, ?, NOW())
-		ON CONFLICT (day) DO UPDATE SET
+		INSERT INTO stats_daily (day, mailbox_id, received, processed_ok, processed_error, last_event_at)
+		VALUES (?, ?, ?, ?, ?, NOW())
+		ON CONFLICT (day, (COALESCE(mailbox_id, '00000000-0000-0000-0000-000000000000'::uuid))) DO UPDATE SET
 			received = stats_daily.received + excluded.received,
 			processed_ok = stats_daily.processed_ok + excluded.processed_ok,
 			processed_error = stats_daily.processed_error + excluded.processed_error,
 			last_event_at = NOW();
 	`
-	return r.db.WithContext(ctx).Exec(query, day, received, processedOk, processedError).Error
+	return r.db.WithContext(ctx).Exec(query, day, mailbox, received, processedOk, processedError).Error
+}
+
+// ClaimDelivery records a Pub/Sub message ID and reports whether this is its first delivery
+// within ttl. An expired record is claimed again.
+func (r *PostgresRepository) ClaimDelivery(ctx context.Context, deliveryID string, ttl time.Duration) (bool, error) {
+	var claimed []string
+	err := r.db.WithContext(ctx).Raw(`
+		INSERT INTO pubsub_deliveries (message_id, received_at) VALUES (?, NOW())
+		ON CONFLICT (message_id) DO UPDATE SET received_at = NOW()
+		WHERE pubsub_deliveries.received_at < ?
+		RETURNING message_id`,
+		deliveryID, time.Now().Add(-ttl),
+	).Scan(&claimed).Error
+	if err != nil {
+		return false, err
+	}
+	return len(claimed) > 0, nil
+}
+
+// ReleaseDelivery forgets a claimed delivery so a redelivery of it is processed.
+func (r *PostgresRepository) ReleaseDelivery(ctx context.Context, deliveryID string) error {
+	return r.db.WithContext(ctx).Where("message_id = ?", deliveryID).Delete(&PubSubDelivery{}).Error
+}
+
+func (r *PostgresRepository) PurgeDeliveries(ctx context.Context, before time.Time) (int64, error) {
+	res := r.db.WithContext(ctx).Where("received_at < ?", before).Delete(&PubSubDelivery{})
+	return res.RowsAffected, res.Error
+}
+
+// StartJob marks a queued job as running. Jobs the admin service has already given up on
+// are left alone.
+func (r *PostgresRepository) StartJob(ctx context.Context, jobID string) error {
+	return r.db.WithContext(ctx).Exec(`
+		UPDATE jobs SET status = ?, started_at = NOW(), updated_at = NOW()
+		WHERE id = ? AND status = ?`,
+		JobRunning, jobID, JobQueued,
+	).Error
+}
+
+func (r *PostgresRepository) UpdateJobProgress(ctx context.Context, jobID string, progress []byte) error {
+	return r.db.WithContext(ctx).Exec(`
+		UPDATE jobs SET progress = ?, updated_at = NOW()
+		WHERE id = ? AND status = ?`,
+		string(progress), jobID, JobRunning,
+	).Error
+}
+
+// FinishJob records the outcome of a job. A non-empty errMsg fails it; result is stored
+// either way, so a partial result survives a failure.
+func (r *PostgresRepository) FinishJob(ctx context.Context, jobID string, result []byte, errMsg string) error {
+	status := JobSucceeded
+	if errMsg != "" {
+		status = JobFailed
+	}
+	var res any
+	if len(result) > 0 {
+		res = string(result)
+	}
+	return r.db.WithContext(ctx).Exec(`
+		UPDATE jobs SET status = ?, result = ?, error = NULLIF(?, ''), finished_at = NOW(), updated_at = NOW()
+		WHERE id = ?`,
+		status, res, errMsg, jobID,
+	).Error
 }
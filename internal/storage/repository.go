@@ -2,58 +2,203 @@ package storage
 
 import (
 	"context"
+	"slices"
 	"time"
 )
 
+// HistoryRepository is the side of the storage used by the worker: it records watches,
+// sync cursors, processed messages, events and job progress. See AdminRepository for the
+// admin service's side.
 type HistoryRepository interface {
-	SaveWatchStatus(ctx context.Context, historyID uint64, expiration int64) error
-	SaveProcessedEmail(ctx context.Context, email ProcessedEmail) error
+	ListMailboxes(ctx context.Context) ([]Mailbox, error)
+	GetMailboxByEmail(ctx context.Context, emailAddress string) (*Mailbox, error)
+	SaveWatchStatus(ctx context.Context, mailboxID string, historyID uint64, expiration int64) error
+	GetLatestWatchHistoryID(ctx context.Context, mailboxID string) (uint64, error)
+	ListWatchExpirations(ctx context.Context) (map[string]int64, error)
+	WithAdvisoryLock(ctx context.Context, key int64, fn func(ctx context.Context) error) (bool, error)
+	GetSyncCursor(ctx context.Context, emailAddress string) (uint64, error)
+	SaveSyncCursor(ctx context.Context, emailAddress string, historyID uint64) error
+	SaveProcessedEmail(ctx context.Context, email *ProcessedEmail, overwrite bool) (SaveResult, error)
+	SaveAttachment(ctx context.Context, attachment Attachment) error
+	ListAttachments(ctx context.Context, messageID string) ([]Attachment, error)
+	ProcessedMessageIDs(ctx context.Context, messageIDs []string) (map[string]bool, error)
+	ListProcessedEmails(ctx context.Context, from, to time.Time, limit int) ([]ProcessedEmail, error)
+	ListEnabledFilters(ctx context.Context) ([]Filter, error)
 	RecordEvent(ctx context.Context, event Event) error
-	UpdateDailyStats(ctx context.Context, received, processedOk, processedError int) error
+	GetEvents(ctx context.Context, ids []string) ([]Event, error)
+	LatestEventIDs(ctx context.Context, messageIDs []string) (map[string]string, error)
+	LatestDecision(ctx context.Context, messageID string) (string, error)
+	UpdateDailyStats(ctx context.Context, mailboxID string, received, processedOk, processedError int) error
+	ClaimDelivery(ctx context.Context, deliveryID string, ttl time.Duration) (bool, error)
+	ReleaseDelivery(ctx context.Context, deliveryID string) error
+	PurgeDeliveries(ctx context.Context, before time.Time) (int64, error)
+	StartJob(ctx context.Context, jobID string) error
+	UpdateJobProgress(ctx context.Context, jobID string, progress []byte) error
+	FinishJob(ctx context.Context, jobID string, result []byte, errMsg string) error
 }
 
-type ProcessedEmail struct {
-	ID        uint64 `gorm:"primaryKey"`
-	MessageID string `gorm:"uniqueIndex;not null"`
-	HistoryID uint64 `gorm:"not null"`
-	LabelIDs  string
-	Snippet   string
-	CreatedAt time.Time
+// SaveResult tells what SaveProcessedEmail did with the row.
+type SaveResult int
+
+const (
+	// SaveUnchanged means the message was already stored with the same labels, filter and
+	// mailbox; nothing was written.
+	SaveUnchanged SaveResult = iota
+	SaveCreated
+	SaveUpdated
+)
+
+func (r SaveResult) String() string {
+	switch r {
+	case SaveCreated:
+		return "created"
+	case SaveUpdated:
+		return "updated"
+	default:
+		return "unchanged"
+	}
 }
 
-// Event maps to the 'events' table created by admin service
-type Event struct {
-	ID        string `gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
-	MessageID string
-	FilterID  string // Optional
-	Status    string
-	Error     string
-	CreatedAt time.Time
+// Event statuses. Outcomes end an attempt; steps precede them.
+const (
+	EventProcessed = "processed"
+	EventError     = "error"
+	EventIgnored   = "ignored"
+
+	EventFetched   = "fetched"
+	EventMatched   = "matched"
+	EventUnmatched = "unmatched"
+	EventSaved     = "saved"
+	EventLabeled   = "labeled"
+)
+
+// EventOutcomes are the statuses of outcome events.
+var EventOutcomes = []string{EventProcessed, EventError, EventIgnored}
+
+// IsOutcome reports whether the status ends a processing attempt.
+func IsOutcome(status string) bool {
+	return slices.Contains(EventOutcomes, status)
 }
 
-// DailyStat maps to the 'stats_daily' table
-type DailyStat struct {
-	Day            string `gorm:"primaryKey;type:date"`
-	Received       int
-	ProcessedOk    int
-	ProcessedError int
-	LastEventAt    time.Time
+// IsDecision reports whether the status records what became of a message: an outcome, or a
+// miss that ended its attempt without one.
+func IsDecision(status string) bool {
+	return status == EventUnmatched || IsOutcome(status)
 }
 
+// Mailbox statuses. Only active mailboxes are watched and synced.
+const (
+	MailboxActive   = "active"
+	MailboxDisabled = "disabled"
+)
+
+// Job statuses. The admin service creates jobs as queued; the worker moves them on.
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+)
+
 type NoOpRepository struct{}
 
-func (r *NoOpRepository) SaveWatchStatus(ctx context.Context, historyID uint64, expiration int64) error {
+func (r *NoOpRepository) ListMailboxes(ctx context.Context) ([]Mailbox, error) {
+	return nil, nil
+}
+
+func (r *NoOpRepository) GetMailboxByEmail(ctx context.Context, emailAddress string) (*Mailbox, error) {
+	return nil, nil
+}
+
+func (r *NoOpRepository) SaveWatchStatus(ctx context.Context, mailboxID string, historyID uint64, expiration int64) error {
 	return nil
 }
 
-func (r *NoOpRepository) SaveProcessedEmail(ctx context.Context, email ProcessedEmail) error {
+func (r *NoOpRepository) GetLatestWatchHistoryID(ctx context.Context, mailboxID string) (uint64, error) {
+	return 0, nil
+}
+
+func (r *NoOpRepository) ListWatchExpirations(ctx context.Context) (map[string]int64, error) {
+	return map[string]int64{}, nil
+}
+
+// WithAdvisoryLock runs fn directly: without a database there is nobody to coordinate with.
+func (r *NoOpRepository) WithAdvisoryLock(ctx context.Context, key int64, fn func(ctx context.Context) error) (bool, error) {
+	return true, fn(ctx)
+}
+
+func (r *NoOpRepository) GetSyncCursor(ctx context.Context, emailAddress string) (uint64, error) {
+	return 0, nil
+}
+
+func (r *NoOpRepository) SaveSyncCursor(ctx context.Context, emailAddress string, historyID uint64) error {
 	return nil
 }
 
+func (r *NoOpRepository) SaveProcessedEmail(ctx context.Context, email *ProcessedEmail, overwrite bool) (SaveResult, error) {
+	return SaveCreated, nil
+}
+
+func (r *NoOpRepository) SaveAttachment(ctx context.Context, attachment Attachment) error {
+	return nil
+}
+
+func (r *NoOpRepository) ListAttachments(ctx context.Context, messageID string) ([]Attachment, error) {
+	return nil, nil
+}
+
+func (r *NoOpRepository) ProcessedMessageIDs(ctx context.Context, messageIDs []string) (map[string]bool, error) {
+	return map[string]bool{}, nil
+}
+
+func (r *NoOpRepository) ListProcessedEmails(ctx context.Context, from, to time.Time, limit int) ([]ProcessedEmail, error) {
+	return nil, nil
+}
+
+func (r *NoOpRepository) ListEnabledFilters(ctx context.Context) ([]Filter, error) {
+	return nil, nil
+}
+
 func (r *NoOpRepository) RecordEvent(ctx context.Context, event Event) error {
 	return nil
 }
 
-func (r *NoOpRepository) UpdateDailyStats(ctx context.Context, received, processedOk, processedError int) error {
+func (r *NoOpRepository) GetEvents(ctx context.Context, ids []string) ([]Event, error) {
+	return nil, nil
+}
+
+func (r *NoOpRepository) LatestEventIDs(ctx context.Context, messageIDs []string) (map[string]string, error) {
+	return map[string]string{}, nil
+}
+
+func (r *NoOpRepository) LatestDecision(ctx context.Context, messageID string) (string, error) {
+	return "", nil
+}
+
+func (r *NoOpRepository) UpdateDailyStats(ctx context.Context, mailboxID string, received, processedOk, processedError int) error {
+	return nil
+}
+
+func (r *NoOpRepository) ClaimDelivery(ctx context.Context, deliveryID string, ttl time.Duration) (bool, error) {
+	return true, nil
+}
+
+func (r *NoOpRepository) ReleaseDelivery(ctx context.Context, deliveryID string) error {
+	return nil
+}
+
+func (r *NoOpRepository) PurgeDeliveries(ctx context.Context, before time.Time) (int64, error) {
+	return 0, nil
+}
+
+func (r *NoOpRepository) StartJob(ctx context.Context, jobID string) error {
+	return nil
+}
+
+func (r *NoOpRepository) UpdateJobProgress(ctx context.Context, jobID string, progress []byte) error {
+	return nil
+}
+
+func (r *NoOpRepository) FinishJob(ctx context.Context, jobID string, result []byte, errMsg string) error {
 	return nil
 }
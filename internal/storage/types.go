@@ -0,0 +1,101 @@
+package storage
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"strings"
+)
+
+// StringArray maps a Postgres text[] column to a Go string slice.
+type StringArray []string
+
+// GormDataType tells GORM which column type to use in migrations.
+func (StringArray) GormDataType() string {
+	return "text[]"
+}
+
+func (a StringArray) Value() (driver.Value, error) {
+	if a == nil {
+		return "{}", nil
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, s := range a {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteByte('"')
+		b.WriteString(strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String(), nil
+}
+
+func (a *StringArray) Scan(src any) error {
+	var s string
+	switch v := src.(type) {
+	case nil:
+		*a = nil
+		return nil
+	case string:
+		s = v
+	case []byte:
+		s = string(v)
+	default:
+		return fmt.Errorf("cannot scan %T into StringArray", src)
+	}
+
+	out, err := parseArrayLiteral(s)
+	if err != nil {
+		return err
+	}
+	*a = out
+	return nil
+}
+
+// parseArrayLiteral parses a one-dimensional Postgres array literal such as {a,"b c",NULL}.
+// NULL elements are dropped.
+func parseArrayLiteral(s string) ([]string, error) {
+	if len(s) < 2 || s[0] != '{' || s[len(s)-1] != '}' {
+		return nil, fmt.Errorf("invalid array literal %q", s)
+	}
+	body := s[1 : len(s)-1]
+	out := []string{}
+	if body == "" {
+		return out, nil
+	}
+
+	var cur strings.Builder
+	quoted, inQuotes, escaped := false, false, false
+	flush := func() {
+		v := cur.String()
+		if quoted || v != "NULL" {
+			out = append(out, v)
+		}
+		cur.Reset()
+		quoted = false
+	}
+	for i := 0; i < len(body); i++ {
+		c := body[i]
+		switch {
+		case escaped:
+			cur.WriteByte(c)
+			escaped = false
+		case c == '\\':
+			escaped = true
+		case c == '"':
+			inQuotes = !inQuotes
+			quoted = true
+		case c == ',' && !inQuotes:
+			flush()
+		default:
+			cur.WriteByte(c)
+		}
+	}
+	if inQuotes {
+		return nil, fmt.Errorf("invalid array literal %q", s)
+	}
+	flush()
+	return out, nil
+}
@@ -0,0 +1,238 @@
+// Package worker runs background jobs on a bounded pool of goroutines. Jobs sharing a key
+// (for example a mailbox) never run concurrently and start in submission order.
+package worker
+
+import (
+	"context"
+	"errors"
+	"log"
+	"slices"
+	"sync"
+	"time"
+)
+
+var (
+	ErrQueueFull = errors.New("worker queue is full")
+	ErrClosed    = errors.New("worker pool is shut down")
+)
+
+// Job is a unit of background work.
+type Job struct {
+	// Key serializes jobs: at most one job per key runs at a time. Jobs without a key
+	// are not serialized.
+	Key string
+	// Keys are further keys the job holds while it runs, for jobs spanning several
+	// mailboxes.
+	Keys []string
+	// Name is used in logs.
+	Name string
+	// Timeout replaces the pool's job timeout when positive; a negative Timeout runs the
+	// job without one.
+	Timeout time.Duration
+	Run     func(ctx context.Context) error
+}
+
+// keys returns every key the job holds.
+func (j Job) keys() []string {
+	if j.Key == "" {
+		return j.Keys
+	}
+	return append([]string{j.Key}, j.Keys...)
+}
+
+// Stats is a snapshot of the pool's state.
+type Stats struct {
+	Workers    int    `json:"workers"`
+	QueueSize  int    `json:"queueSize"`
+	QueueDepth int    `json:"queueDepth"`
+	InFlight   int    `json:"inFlight"`
+	Processed  uint64 `json:"processed"`
+	Failed     uint64 `json:"failed"`
+	Rejected   uint64 `json:"rejected"`
+}
+
+// Pool is a fixed set of workers consuming a bounded FIFO queue.
+type Pool struct {
+	workers    int
+	queueSize  int
+	jobTimeout time.Duration
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu       sync.Mutex
+	cond     *sync.Cond
+	queue    []Job
+	busy     map[string]bool
+	inFlight int
+	closed   bool
+	stats    Stats
+}
+
+// NewPool starts workers goroutines sharing a queue of at most queueSize pending jobs.
+// Each job gets jobTimeout to finish (zero means no limit) unless it sets its own Timeout.
+func NewPool(workers, queueSize int, jobTimeout time.Duration) *Pool {
+	if workers < 1 {
+		workers = 1
+	}
+	if queueSize < 1 {
+		queueSize = 1
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &Pool{
+		workers:    workers,
+		queueSize:  queueSize,
+		jobTimeout: jobTimeout,
+		ctx:        ctx,
+		cancel:     cancel,
+		busy:       make(map[string]bool),
+	}
+	p.cond = sync.NewCond(&p.mu)
+	for i := 0; i < workers; i++ {
+		p.wg.Add(1)
+		go p.work()
+	}
+	return p
+}
+
+// Submit enqueues a job without waiting for it to run.
+func (p *Pool) Submit(job Job) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return ErrClosed
+	}
+	if len(p.queue) >= p.queueSize {
+		p.stats.Rejected++
+		return ErrQueueFull
+	}
+	p.queue = append(p.queue, job)
+	p.cond.Signal()
+	return nil
+}
+
+// Stats returns the current queue depth, in-flight count and counters.
+func (p *Pool) Stats() Stats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	s := p.stats
+	s.Workers = p.workers
+	s.QueueSize = p.queueSize
+	s.QueueDepth = len(p.queue)
+	s.InFlight = p.inFlight
+	return s
+}
+
+// Shutdown stops accepting jobs and waits for queued and in-flight jobs to finish. When ctx
+// expires first, running jobs are cancelled, the rest of the queue is dropped and ctx's
+// error is returned.
+func (p *Pool) Shutdown(ctx context.Context) error {
+	p.mu.Lock()
+	p.closed = true
+	p.cond.Broadcast()
+	p.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		p.cancel()
+		return nil
+	case <-ctx.Done():
+		p.mu.Lock()
+		dropped := len(p.queue)
+		p.queue = nil
+		p.mu.Unlock()
+		log.Printf("Worker shutdown timed out, cancelling in-flight jobs and dropping %d queued", dropped)
+		p.cancel()
+		<-done
+		return ctx.Err()
+	}
+}
+
+func (p *Pool) work() {
+	defer p.wg.Done()
+	for {
+		job, ok := p.next()
+		if !ok {
+			return
+		}
+		err := p.run(job)
+
+		p.mu.Lock()
+		for _, key := range job.keys() {
+			delete(p.busy, key)
+		}
+		p.inFlight--
+		if err != nil {
+			p.stats.Failed++
+		} else {
+			p.stats.Processed++
+		}
+		// A job for this key may be waiting behind the one that just finished.
+		p.cond.Broadcast()
+		p.mu.Unlock()
+	}
+}
+
+// next blocks until a job none of whose keys is busy is queued. It returns false once the
+// pool is closed and the queue is drained.
+func (p *Pool) next() (Job, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for {
+		// Keys of jobs left waiting stay blocked for the jobs queued after them, so jobs of
+		// a key start in submission order even when a job holds several keys.
+		blocked := make(map[string]bool)
+		for i, job := range p.queue {
+			keys := job.keys()
+			if slices.ContainsFunc(keys, func(key string) bool { return p.busy[key] || blocked[key] }) {
+				for _, key := range keys {
+					blocked[key] = true
+				}
+				continue
+			}
+			p.queue = append(p.queue[:i], p.queue[i+1:]...)
+			for _, key := range keys {
+				p.busy[key] = true
+			}
+			p.inFlight++
+			return job, true
+		}
+		if p.closed && len(p.queue) == 0 {
+			return Job{}, false
+		}
+		p.cond.Wait()
+	}
+}
+
+func (p *Pool) run(job Job) (err error) {
+	ctx, timeout := p.ctx, p.jobTimeout
+	if job.Timeout != 0 {
+		timeout = job.Timeout
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Job %s panicked: %v", job.Name, r)
+			err = errors.New("job panicked")
+		}
+	}()
+
+	start := time.Now()
+	if err = job.Run(ctx); err != nil {
+		log.Printf("Job %s failed after %s: %v", job.Name, time.Since(start).Round(time.Millisecond), err)
+	}
+	return err
+}
@@ -0,0 +1,203 @@
+package worker_test
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gagarin-soft/internal/worker"
+)
+
+func TestPool_SerializesJobsPerKey(t *testing.T) {
+	pool := worker.NewPool(4, 100, 0)
+
+	var mu sync.Mutex
+	running := map[string]int{}
+	order := map[string][]int{}
+	var overlaps atomic.Int32
+
+	for i := 0; i < 20; i++ {
+		key := []string{"a", "b"}[i%2]
+		i := i
+		err := pool.Submit(worker.Job{Key: key, Run: func(ctx context.Context) error {
+			mu.Lock()
+			running[key]++
+			if running[key] > 1 {
+				overlaps.Add(1)
+			}
+			order[key] = append(order[key], i)
+			mu.Unlock()
+
+			time.Sleep(time.Millisecond)
+
+			mu.Lock()
+			running[key]--
+			mu.Unlock()
+			return nil
+		}})
+		if err != nil {
+			t.Fatalf("Submit: %v", err)
+		}
+	}
+
+	if err := pool.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+	if n := overlaps.Load(); n != 0 {
+		t.Errorf("Expected jobs of one key never to overlap, got %d overlaps", n)
+	}
+	for key, ids := range order {
+		for j := 1; j < len(ids); j++ {
+			if ids[j] < ids[j-1] {
+				t.Errorf("Expected key %s to run in submission order, got %v", key, ids)
+				break
+			}
+		}
+	}
+	if got := pool.Stats().Processed; got != 20 {
+		t.Errorf("Expected 20 processed jobs, got %d", got)
+	}
+}
+
+func TestPool_SerializesJobsHoldingSeveralKeys(t *testing.T) {
+	pool := worker.NewPool(4, 100, 0)
+	release := make(chan struct{})
+	started := make(chan struct{})
+
+	var mu sync.Mutex
+	var order []string
+	record := func(name string) func(ctx context.Context) error {
+		return func(ctx context.Context) error {
+			mu.Lock()
+			order = append(order, name)
+			mu.Unlock()
+			return nil
+		}
+	}
+
+	pool.Submit(worker.Job{Key: "a", Run: func(ctx context.Context) error {
+		close(started)
+		<-release
+		return record("a1")(ctx)
+	}})
+	<-started
+	// The job on a and b waits for a, and the later job on b waits for it.
+	pool.Submit(worker.Job{Key: "a", Keys: []string{"b"}, Run: record("ab")})
+	pool.Submit(worker.Job{Key: "b", Run: record("b1")})
+	pool.Submit(worker.Job{Key: "c", Run: record("c1")})
+
+	time.Sleep(20 * time.Millisecond)
+	mu.Lock()
+	early := slices.Clone(order)
+	mu.Unlock()
+	if !slices.Equal(early, []string{"c1"}) {
+		t.Errorf("Expected only the job on c to run while a is busy, got %v", early)
+	}
+
+	close(release)
+	if err := pool.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+	if want := []string{"c1", "a1", "ab", "b1"}; !slices.Equal(order, want) {
+		t.Errorf("Expected order %v, got %v", want, order)
+	}
+}
+
+func TestPool_RejectsWhenQueueIsFull(t *testing.T) {
+	pool := worker.NewPool(1, 1, 0)
+	release := make(chan struct{})
+	started := make(chan struct{})
+
+	block := worker.Job{Key: "a", Run: func(ctx context.Context) error {
+		close(started)
+		<-release
+		return nil
+	}}
+	noop := worker.Job{Key: "a", Run: func(ctx context.Context) error { return nil }}
+
+	if err := pool.Submit(block); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+	if err := pool.Submit(noop); err != nil {
+		t.Fatal(err)
+	}
+	if err := pool.Submit(noop); !errors.Is(err, worker.ErrQueueFull) {
+		t.Errorf("Expected ErrQueueFull, got %v", err)
+	}
+
+	stats := pool.Stats()
+	if stats.InFlight != 1 || stats.QueueDepth != 1 || stats.Rejected != 1 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+
+	close(release)
+	if err := pool.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+	if err := pool.Submit(noop); !errors.Is(err, worker.ErrClosed) {
+		t.Errorf("Expected ErrClosed after shutdown, got %v", err)
+	}
+}
+
+func TestPool_ShutdownCancelsJobsAfterDeadline(t *testing.T) {
+	pool := worker.NewPool(1, 10, 0)
+	started := make(chan struct{})
+	var cancelled atomic.Bool
+
+	pool.Submit(worker.Job{Run: func(ctx context.Context) error {
+		close(started)
+		<-ctx.Done()
+		cancelled.Store(true)
+		return ctx.Err()
+	}})
+	pool.Submit(worker.Job{Run: func(ctx context.Context) error {
+		t.Error("Expected the queued job to be dropped")
+		return nil
+	}})
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := pool.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected DeadlineExceeded, got %v", err)
+	}
+	if !cancelled.Load() {
+		t.Error("Expected the in-flight job to be cancelled")
+	}
+}
+
+func TestPool_JobTimeoutOverridesPoolTimeout(t *testing.T) {
+	pool := worker.NewPool(2, 10, time.Millisecond)
+
+	deadlines := make(chan bool, 2)
+	run := func(ctx context.Context) error {
+		_, ok := ctx.Deadline()
+		deadlines <- ok
+		return nil
+	}
+	if err := pool.Submit(worker.Job{Name: "pool timeout", Run: run}); err != nil {
+		t.Fatalf("Submit: %v", err)
+	}
+	if err := pool.Submit(worker.Job{Name: "no timeout", Timeout: -1, Run: run}); err != nil {
+		t.Fatalf("Submit: %v", err)
+	}
+	if err := pool.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+	close(deadlines)
+
+	var withDeadline int
+	for ok := range deadlines {
+		if ok {
+			withDeadline++
+		}
+	}
+	if withDeadline != 1 {
+		t.Errorf("Expected only the job without its own timeout to get a deadline, got %d", withDeadline)
+	}
+}
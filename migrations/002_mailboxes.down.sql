@@ -0,0 +1,9 @@
+DROP INDEX IF EXISTS idx_stats_daily_day_mailbox;
+DELETE FROM stats_daily WHERE mailbox_id IS NOT NULL;
+ALTER TABLE stats_daily DROP COLUMN IF EXISTS mailbox_id;
+ALTER TABLE stats_daily ADD PRIMARY KEY (day);
+
+DROP INDEX IF EXISTS idx_events_mailbox_id;
+ALTER TABLE events DROP COLUMN IF EXISTS mailbox_id;
+
+DROP TABLE IF EXISTS mailboxes;
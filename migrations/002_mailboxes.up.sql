@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS mailboxes (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    email_address TEXT NOT NULL UNIQUE,
+    refresh_token_secret TEXT NOT NULL,
+    watch_labels TEXT[] NOT NULL DEFAULT '{INBOX}',
+    status TEXT NOT NULL DEFAULT 'active',
+    created_at TIMESTAMPTZ DEFAULT NOW(),
+    updated_at TIMESTAMPTZ DEFAULT NOW(),
+    updated_by TEXT
+);
+
+ALTER TABLE events ADD COLUMN IF NOT EXISTS mailbox_id UUID REFERENCES mailboxes (id) ON DELETE SET NULL;
+CREATE INDEX IF NOT EXISTS idx_events_mailbox_id ON events (mailbox_id, created_at DESC);
+
+-- stats_daily becomes one row per day and mailbox; rows written before mailboxes existed keep a NULL mailbox_id.
+ALTER TABLE stats_daily ADD COLUMN IF NOT EXISTS mailbox_id UUID REFERENCES mailboxes (id);
+ALTER TABLE stats_daily DROP CONSTRAINT IF EXISTS stats_daily_pkey;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_stats_daily_day_mailbox
+    ON stats_daily (day, (COALESCE(mailbox_id, '00000000-0000-0000-0000-000000000000'::uuid)));
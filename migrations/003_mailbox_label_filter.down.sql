@@ -0,0 +1 @@
+ALTER TABLE mailboxes DROP COLUMN IF EXISTS watch_label_filter_behavior;
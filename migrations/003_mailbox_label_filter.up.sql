@@ -0,0 +1,2 @@
+ALTER TABLE mailboxes ADD COLUMN IF NOT EXISTS watch_label_filter_behavior TEXT NOT NULL DEFAULT 'include'
+    CHECK (watch_label_filter_behavior IN ('include', 'exclude'));
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_events_retry_of;
+ALTER TABLE events DROP COLUMN IF EXISTS retry_of;
@@ -0,0 +1,21 @@
+DROP INDEX IF EXISTS idx_processed_emails_thread_id;
+DROP INDEX IF EXISTS idx_processed_emails_from_address;
+DROP INDEX IF EXISTS idx_processed_emails_label_ids;
+DROP INDEX IF EXISTS idx_processed_emails_created_at;
+DROP INDEX IF EXISTS idx_processed_emails_mailbox_created;
+CREATE INDEX IF NOT EXISTS idx_processed_emails_mailbox_id ON processed_emails (mailbox_id);
+
+ALTER TABLE processed_emails DROP COLUMN IF EXISTS size_estimate;
+ALTER TABLE processed_emails DROP COLUMN IF EXISTS internal_date;
+ALTER TABLE processed_emails DROP COLUMN IF EXISTS subject;
+ALTER TABLE processed_emails DROP COLUMN IF EXISTS from_address;
+ALTER TABLE processed_emails DROP COLUMN IF EXISTS thread_id;
+
+ALTER TABLE processed_emails DROP CONSTRAINT IF EXISTS processed_emails_filter_id_fkey;
+ALTER TABLE processed_emails DROP CONSTRAINT IF EXISTS processed_emails_mailbox_id_fkey;
+ALTER TABLE processed_emails ALTER COLUMN filter_id TYPE TEXT USING COALESCE(filter_id::TEXT, '');
+ALTER TABLE processed_emails ALTER COLUMN mailbox_id TYPE TEXT USING COALESCE(mailbox_id::TEXT, '');
+
+ALTER TABLE processed_emails ALTER COLUMN label_ids DROP NOT NULL;
+ALTER TABLE processed_emails ALTER COLUMN label_ids DROP DEFAULT;
+ALTER TABLE processed_emails ALTER COLUMN label_ids TYPE TEXT USING '[' || array_to_string(label_ids, ' ') || ']';
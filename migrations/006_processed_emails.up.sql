@@ -0,0 +1,81 @@
+-- processed_emails and attachments used to be created by the worker's GORM AutoMigrate.
+-- Their schema is owned by the migrations from here on; the tables are created in the
+-- shape AutoMigrate left them in, then converted.
+CREATE TABLE IF NOT EXISTS processed_emails (
+    id BIGSERIAL PRIMARY KEY,
+    message_id TEXT NOT NULL,
+    history_id BIGINT NOT NULL,
+    mailbox_id TEXT,
+    label_ids TEXT,
+    snippet TEXT,
+    filter_id TEXT,
+    created_at TIMESTAMPTZ
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_processed_emails_message_id ON processed_emails (message_id);
+
+CREATE TABLE IF NOT EXISTS attachments (
+    id BIGSERIAL PRIMARY KEY,
+    processed_email_id BIGINT NOT NULL REFERENCES processed_emails (id) ON DELETE CASCADE,
+    message_id TEXT NOT NULL,
+    filename TEXT,
+    mime_type TEXT,
+    size BIGINT,
+    sha256 TEXT NOT NULL,
+    storage_key TEXT NOT NULL,
+    created_at TIMESTAMPTZ
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_attachments_message_file ON attachments (message_id, filename, sha256);
+CREATE INDEX IF NOT EXISTS idx_attachments_processed_email_id ON attachments (processed_email_id);
+
+DO $$
+BEGIN
+    -- label_ids held fmt's rendering of a Go slice, e.g. "[INBOX UNREAD]".
+    IF (SELECT data_type FROM information_schema.columns
+        WHERE table_name = 'processed_emails' AND column_name = 'label_ids') = 'text' THEN
+        ALTER TABLE processed_emails ALTER COLUMN label_ids TYPE TEXT[] USING
+            CASE WHEN btrim(COALESCE(label_ids, ''), '[] ') = '' THEN '{}'::TEXT[]
+                 ELSE regexp_split_to_array(btrim(label_ids, '[] '), '\s+') END;
+    END IF;
+
+    -- Empty strings stood for "no mailbox" and "matched by TARGET_GMAIL_LABEL".
+    IF (SELECT data_type FROM information_schema.columns
+        WHERE table_name = 'processed_emails' AND column_name = 'mailbox_id') = 'text' THEN
+        UPDATE processed_emails SET mailbox_id = NULL
+            WHERE mailbox_id = '' OR mailbox_id NOT IN (SELECT id::TEXT FROM mailboxes);
+        ALTER TABLE processed_emails ALTER COLUMN mailbox_id TYPE UUID USING mailbox_id::UUID;
+    END IF;
+    IF (SELECT data_type FROM information_schema.columns
+        WHERE table_name = 'processed_emails' AND column_name = 'filter_id') = 'text' THEN
+        UPDATE processed_emails SET filter_id = NULL
+            WHERE filter_id = '' OR filter_id NOT IN (SELECT id::TEXT FROM filters);
+        ALTER TABLE processed_emails ALTER COLUMN filter_id TYPE UUID USING filter_id::UUID;
+    END IF;
+END $$;
+
+ALTER TABLE processed_emails ALTER COLUMN label_ids SET DEFAULT '{}';
+UPDATE processed_emails SET label_ids = '{}' WHERE label_ids IS NULL;
+ALTER TABLE processed_emails ALTER COLUMN label_ids SET NOT NULL;
+UPDATE processed_emails SET created_at = NOW() WHERE created_at IS NULL;
+ALTER TABLE processed_emails ALTER COLUMN created_at SET DEFAULT NOW();
+ALTER TABLE processed_emails ALTER COLUMN created_at SET NOT NULL;
+
+ALTER TABLE processed_emails DROP CONSTRAINT IF EXISTS processed_emails_mailbox_id_fkey;
+ALTER TABLE processed_emails ADD CONSTRAINT processed_emails_mailbox_id_fkey
+    FOREIGN KEY (mailbox_id) REFERENCES mailboxes (id) ON DELETE SET NULL;
+ALTER TABLE processed_emails DROP CONSTRAINT IF EXISTS processed_emails_filter_id_fkey;
+ALTER TABLE processed_emails ADD CONSTRAINT processed_emails_filter_id_fkey
+    FOREIGN KEY (filter_id) REFERENCES filters (id) ON DELETE SET NULL;
+
+-- Not known for rows written before this migration; reprocessing a message fills them in.
+ALTER TABLE processed_emails ADD COLUMN IF NOT EXISTS thread_id TEXT;
+ALTER TABLE processed_emails ADD COLUMN IF NOT EXISTS from_address TEXT;
+ALTER TABLE processed_emails ADD COLUMN IF NOT EXISTS subject TEXT;
+ALTER TABLE processed_emails ADD COLUMN IF NOT EXISTS internal_date TIMESTAMPTZ;
+ALTER TABLE processed_emails ADD COLUMN IF NOT EXISTS size_estimate BIGINT;
+
+DROP INDEX IF EXISTS idx_processed_emails_mailbox_id;
+CREATE INDEX IF NOT EXISTS idx_processed_emails_mailbox_created ON processed_emails (mailbox_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_processed_emails_created_at ON processed_emails (created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_processed_emails_label_ids ON processed_emails USING GIN (label_ids);
+CREATE INDEX IF NOT EXISTS idx_processed_emails_from_address ON processed_emails (lower(from_address));
+CREATE INDEX IF NOT EXISTS idx_processed_emails_thread_id ON processed_emails (thread_id);
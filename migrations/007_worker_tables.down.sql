@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS pubsub_deliveries;
+DROP TABLE IF EXISTS gmail_sync_cursors;
+DROP TABLE IF EXISTS gmail_watch_histories;
@@ -0,0 +1,21 @@
+-- Worker bookkeeping tables, previously created by GORM AutoMigrate.
+CREATE TABLE IF NOT EXISTS gmail_watch_histories (
+    id BIGSERIAL PRIMARY KEY,
+    mailbox_id TEXT,
+    history_id BIGINT NOT NULL,
+    expiration BIGINT NOT NULL,
+    created_at TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS idx_gmail_watch_histories_mailbox_id ON gmail_watch_histories (mailbox_id);
+
+CREATE TABLE IF NOT EXISTS gmail_sync_cursors (
+    email_address TEXT PRIMARY KEY,
+    history_id BIGINT NOT NULL,
+    updated_at TIMESTAMPTZ
+);
+
+CREATE TABLE IF NOT EXISTS pubsub_deliveries (
+    message_id TEXT PRIMARY KEY,
+    received_at TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_pubsub_deliveries_received_at ON pubsub_deliveries (received_at);
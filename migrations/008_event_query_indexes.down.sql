@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_events_error_trgm;
+DROP INDEX IF EXISTS idx_events_filter_created_at;
+DROP INDEX IF EXISTS idx_events_status_created_at;
+DROP INDEX IF EXISTS idx_events_created_at_id;
+CREATE INDEX IF NOT EXISTS idx_events_created_at ON events (created_at DESC);
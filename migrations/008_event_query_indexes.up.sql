@@ -0,0 +1,10 @@
+-- GET /admin/events pages through events newest first by (created_at, id); each filter
+-- gets an index in that order so a page is an index range scan.
+DROP INDEX IF EXISTS idx_events_created_at;
+CREATE INDEX IF NOT EXISTS idx_events_created_at_id ON events (created_at DESC, id DESC);
+CREATE INDEX IF NOT EXISTS idx_events_status_created_at ON events (status, created_at DESC, id DESC);
+CREATE INDEX IF NOT EXISTS idx_events_filter_created_at ON events (filter_id, created_at DESC, id DESC) WHERE filter_id IS NOT NULL;
+
+-- The free-text search is an ILIKE on error, which only a trigram index can serve.
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+CREATE INDEX IF NOT EXISTS idx_events_error_trgm ON events USING GIN (error gin_trgm_ops) WHERE error IS NOT NULL;
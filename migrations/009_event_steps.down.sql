@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS idx_events_message_created_at;
+CREATE INDEX IF NOT EXISTS idx_events_message_id ON events (message_id);
+
+DELETE FROM events WHERE status IN ('fetched', 'matched', 'unmatched', 'saved', 'labeled');
+ALTER TABLE events DROP COLUMN IF EXISTS details;
+ALTER TABLE events DROP COLUMN IF EXISTS duration_ms;
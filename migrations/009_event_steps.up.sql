@@ -0,0 +1,8 @@
+-- The worker records step events (fetched, matched, unmatched, saved, labeled) ahead of the
+-- outcome of each processing attempt. Both carry how long they took and step specifics.
+ALTER TABLE events ADD COLUMN IF NOT EXISTS duration_ms BIGINT;
+ALTER TABLE events ADD COLUMN IF NOT EXISTS details JSONB;
+
+-- The message timeline reads all events of one message in order.
+DROP INDEX IF EXISTS idx_events_message_id;
+CREATE INDEX IF NOT EXISTS idx_events_message_created_at ON events (message_id, created_at);
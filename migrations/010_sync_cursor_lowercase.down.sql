@@ -0,0 +1,2 @@
+-- The original case of merged addresses is not kept; lowercased cursors work as before.
+SELECT 1;
@@ -0,0 +1,12 @@
+-- Sync cursors are keyed by the lowercased address. Rows saved under an address that only
+-- differed in case are merged, keeping the furthest cursor.
+INSERT INTO gmail_sync_cursors (email_address, history_id, updated_at)
+SELECT LOWER(email_address), MAX(history_id), MAX(updated_at)
+FROM gmail_sync_cursors
+WHERE email_address <> LOWER(email_address)
+GROUP BY LOWER(email_address)
+ON CONFLICT (email_address) DO UPDATE SET
+    history_id = GREATEST(gmail_sync_cursors.history_id, excluded.history_id),
+    updated_at = GREATEST(gmail_sync_cursors.updated_at, excluded.updated_at);
+
+DELETE FROM gmail_sync_cursors WHERE email_address <> LOWER(email_address);
@@ -0,0 +1,8 @@
+UPDATE mailboxes SET watch_labels = '{INBOX}' WHERE watch_labels = '{}';
+UPDATE mailboxes SET watch_label_filter_behavior = 'include' WHERE watch_label_filter_behavior = '';
+
+ALTER TABLE mailboxes DROP CONSTRAINT IF EXISTS mailboxes_watch_label_filter_behavior_check;
+ALTER TABLE mailboxes ADD CONSTRAINT mailboxes_watch_label_filter_behavior_check
+    CHECK (watch_label_filter_behavior IN ('include', 'exclude'));
+ALTER TABLE mailboxes ALTER COLUMN watch_label_filter_behavior SET DEFAULT 'include';
+ALTER TABLE mailboxes ALTER COLUMN watch_labels SET DEFAULT '{INBOX}';
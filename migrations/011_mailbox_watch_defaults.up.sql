@@ -0,0 +1,12 @@
+-- Mailboxes without their own watch settings use WATCH_LABELS and
+-- WATCH_LABEL_FILTER_BEHAVIOR, so the columns default to empty. Rows still holding the old
+-- defaults are cleared too; with neither setting configured the watch is the same INBOX
+-- include watch as before.
+ALTER TABLE mailboxes ALTER COLUMN watch_labels SET DEFAULT '{}';
+ALTER TABLE mailboxes ALTER COLUMN watch_label_filter_behavior SET DEFAULT '';
+ALTER TABLE mailboxes DROP CONSTRAINT IF EXISTS mailboxes_watch_label_filter_behavior_check;
+ALTER TABLE mailboxes ADD CONSTRAINT mailboxes_watch_label_filter_behavior_check
+    CHECK (watch_label_filter_behavior IN ('', 'include', 'exclude'));
+
+UPDATE mailboxes SET watch_labels = '{}', watch_label_filter_behavior = ''
+WHERE watch_labels = '{INBOX}' AND watch_label_filter_behavior = 'include';
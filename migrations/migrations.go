@@ -0,0 +1,8 @@
+// Package migrations embeds the versioned SQL migrations of the shared database.
+// Files are named NNN_description.up.sql and NNN_description.down.sql.
+package migrations
+
+import "embed"
+
+//go:embed *.sql
+var FS embed.FS
@@ -25,7 +25,7 @@ export default function EventsPage() {
         fetch('/admin/events?limit=50')
             .then(res => res.json())
             .then(data => {
-                setEvents(data || []);
+                setEvents(data?.events || []);
                 setLoading(false);
             });
     }, []);